@@ -18,20 +18,15 @@ import (
 
 func SetupApiServer(client *mongo.Client) *gin.Engine {
 
-	// create server and allow CORS from all origins
+	// create server and apply the CORS policy of each tenant
 	r := gin.Default()
-	r.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{"*"},
-		AllowMethods:     []string{"*"},
-		AllowHeaders:     []string{"*"},
-		ExposeHeaders:    []string{"Content-Length"},
-		AllowCredentials: true,
-		MaxAge:           12 * time.Hour,
-	}))
 	r.Use(location.Default())
+	r.Use(internal.TenantCors(client))
 
 	internal.SetupUserRoute(r, client)
 	internal.SetupAdminRoute(r, client)
+	internal.SetupBillingRoute(r, client)
+	internal.SetupEmailRoute(r, client)
 	return r
 }
 
@@ -86,6 +81,9 @@ func main() {
 			panic(err)
 		}
 	}()
+	internal.StartUsageMetering(client, 30*time.Second)
+	internal.StartEmailWorkers(client, 4)
+	internal.StartAnnouncementWorker(client)
 	apiServer := SetupApiServer(client)
 	staticServer := SetupStaticServer()
 
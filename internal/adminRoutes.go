@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net/mail"
 	"os"
 	"strconv"
 	"time"
@@ -17,28 +18,38 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// isAdminRequest checks that the request was sent to the admin domain with the
+// correct admin password, otherwise it writes the error response
+func isAdminRequest(c *gin.Context, adminDomain string) bool {
+	url := location.Get(c)
+	if url.Hostname() != adminDomain {
+		c.JSON(400, gin.H{
+			"error": "This route is not available",
+		})
+		return false
+	}
+
+	password, providedPassword := c.Request.URL.Query()["password"]
+	if !providedPassword {
+		c.JSON(400, gin.H{
+			"error": "You must specify the password query field",
+		})
+		return false
+	}
+	if !CheckPasswordHash(password[0], "$2a$14$"+os.Getenv("ADMIN_PASSWORD_HASH")) {
+		c.JSON(400, gin.H{
+			"error": "Passed password is wrong",
+		})
+		return false
+	}
+
+	return true
+}
+
 func SetupAdminRoute(r *gin.Engine, client *mongo.Client) {
 	adminDomain := os.Getenv("ADMIN_DOMAIN")
 	r.GET("/admin/checkPassword", func(c *gin.Context) {
-		url := location.Get(c)
-		if url.Hostname() != adminDomain {
-			c.JSON(400, gin.H{
-				"error": "This route is not available",
-			})
-			return
-		}
-
-		password, providedPassword := c.Request.URL.Query()["password"]
-		if !providedPassword {
-			c.JSON(400, gin.H{
-				"error": "You must specify the password query field",
-			})
-			return
-		}
-		if !CheckPasswordHash(password[0], "$2a$14$"+os.Getenv("ADMIN_PASSWORD_HASH")) {
-			c.JSON(400, gin.H{
-				"error": "Passed password is wrong",
-			})
+		if !isAdminRequest(c, adminDomain) {
 			return
 		}
 
@@ -48,25 +59,7 @@ func SetupAdminRoute(r *gin.Engine, client *mongo.Client) {
 	})
 
 	r.GET("/admin/configs", func(c *gin.Context) {
-		url := location.Get(c)
-		if url.Hostname() != adminDomain {
-			c.JSON(400, gin.H{
-				"error": "This route is not available",
-			})
-			return
-		}
-
-		password, providedPassword := c.Request.URL.Query()["password"]
-		if !providedPassword {
-			c.JSON(400, gin.H{
-				"error": "You must specify the password query field",
-			})
-			return
-		}
-		if !CheckPasswordHash(password[0], "$2a$14$"+os.Getenv("ADMIN_PASSWORD_HASH")) {
-			c.JSON(400, gin.H{
-				"error": "Passed password is wrong",
-			})
+		if !isAdminRequest(c, adminDomain) {
 			return
 		}
 
@@ -85,25 +78,7 @@ func SetupAdminRoute(r *gin.Engine, client *mongo.Client) {
 	})
 
 	r.POST("/admin/configs", func(c *gin.Context) {
-		url := location.Get(c)
-		if url.Hostname() != adminDomain {
-			c.JSON(400, gin.H{
-				"error": "This route is not available",
-			})
-			return
-		}
-
-		password, providedPassword := c.Request.URL.Query()["password"]
-		if !providedPassword {
-			c.JSON(400, gin.H{
-				"error": "You must specify the password query field",
-			})
-			return
-		}
-		if !CheckPasswordHash(password[0], "$2a$14$"+os.Getenv("ADMIN_PASSWORD_HASH")) {
-			c.JSON(400, gin.H{
-				"error": "Passed password is wrong",
-			})
+		if !isAdminRequest(c, adminDomain) {
 			return
 		}
 
@@ -125,6 +100,18 @@ func SetupAdminRoute(r *gin.Engine, client *mongo.Client) {
 			c.JSON(400, gin.H{"error": "You must pass a domain"})
 			return
 		}
+		if _, err := configData.Cors.corsConfig(); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		if err := validateEmailSettings(configData.Email); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		if err := validateDataSchema(configData.DataSchema); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
 
 		// check if a configuration with the same domain exists
 		filter := bson.M{"domain": configData.Domain}
@@ -149,29 +136,18 @@ func SetupAdminRoute(r *gin.Engine, client *mongo.Client) {
 			panic(err)
 		}
 
+		// the configuration is saved anyway, the check tells the admin whether
+		// the emails will be delivered
+		if diagnostic := checkSMTP(configData.Email, configData.Smtp); diagnostic != nil {
+			c.JSON(200, gin.H{"smtpCheck": diagnostic})
+			return
+		}
+
 		c.String(200, "")
 	})
 
 	r.PUT("/admin/configs", func(c *gin.Context) {
-		url := location.Get(c)
-		if url.Hostname() != adminDomain {
-			c.JSON(400, gin.H{
-				"error": "This route is not available",
-			})
-			return
-		}
-
-		password, providedPassword := c.Request.URL.Query()["password"]
-		if !providedPassword {
-			c.JSON(400, gin.H{
-				"error": "You must specify the password query field",
-			})
-			return
-		}
-		if !CheckPasswordHash(password[0], "$2a$14$"+os.Getenv("ADMIN_PASSWORD_HASH")) {
-			c.JSON(400, gin.H{
-				"error": "Passed password is wrong",
-			})
+		if !isAdminRequest(c, adminDomain) {
 			return
 		}
 
@@ -187,6 +163,18 @@ func SetupAdminRoute(r *gin.Engine, client *mongo.Client) {
 			c.JSON(400, gin.H{"error": "Configuration passed is invalid"})
 			return
 		}
+		if _, err := configData.Cors.corsConfig(); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		if err := validateEmailSettings(configData.Email); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		if err := validateDataSchema(configData.DataSchema); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
 
 		// check if a configuration with the same domain exists
 		filter := bson.M{"_id": configData.ID}
@@ -199,6 +187,10 @@ func SetupAdminRoute(r *gin.Engine, client *mongo.Client) {
 			c.JSON(400, gin.H{"error": "No server exists with the passed id"})
 			return
 		}
+		previousConfig, err := loadServerConfigByID(client, configData.ID)
+		if err != nil {
+			panic(err)
+		}
 
 		// Create new server configuration
 		ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
@@ -214,29 +206,19 @@ func SetupAdminRoute(r *gin.Engine, client *mongo.Client) {
 			panic(err)
 		}
 
+		smtpChanged := previousConfig.Smtp != configData.Smtp || usesSMTP(previousConfig.Email) != usesSMTP(configData.Email)
+		if smtpChanged {
+			if diagnostic := checkSMTP(configData.Email, configData.Smtp); diagnostic != nil {
+				c.JSON(200, gin.H{"smtpCheck": diagnostic})
+				return
+			}
+		}
+
 		c.String(200, "")
 	})
 
 	r.DELETE("/admin/configs/:id", func(c *gin.Context) {
-		url := location.Get(c)
-		if url.Hostname() != adminDomain {
-			c.JSON(400, gin.H{
-				"error": "This route is not available",
-			})
-			return
-		}
-
-		password, providedPassword := c.Request.URL.Query()["password"]
-		if !providedPassword {
-			c.JSON(400, gin.H{
-				"error": "You must specify the password query field",
-			})
-			return
-		}
-		if !CheckPasswordHash(password[0], "$2a$14$"+os.Getenv("ADMIN_PASSWORD_HASH")) {
-			c.JSON(400, gin.H{
-				"error": "Passed password is wrong",
-			})
+		if !isAdminRequest(c, adminDomain) {
 			return
 		}
 
@@ -261,25 +243,7 @@ func SetupAdminRoute(r *gin.Engine, client *mongo.Client) {
 	})
 
 	r.GET("/admin/configs/:configId/users", func(c *gin.Context) {
-		url := location.Get(c)
-		if url.Hostname() != adminDomain {
-			c.JSON(400, gin.H{
-				"error": "This route is not available",
-			})
-			return
-		}
-
-		password, providedPassword := c.Request.URL.Query()["password"]
-		if !providedPassword {
-			c.JSON(400, gin.H{
-				"error": "You must specify the password query field",
-			})
-			return
-		}
-		if !CheckPasswordHash(password[0], "$2a$14$"+os.Getenv("ADMIN_PASSWORD_HASH")) {
-			c.JSON(400, gin.H{
-				"error": "Passed password is wrong",
-			})
+		if !isAdminRequest(c, adminDomain) {
 			return
 		}
 
@@ -335,7 +299,12 @@ func SetupAdminRoute(r *gin.Engine, client *mongo.Client) {
 		options.SetLimit(limit)
 		options.SetSkip(offset)
 		options.SetProjection(bson.M{"data": 0})
-		cursor, err := client.Database("generic_"+config.ID.Hex()).Collection("users").Find(ctx, bson.D{}, options)
+		// the users can be filtered by the deliverability status of their email
+		userFilter := bson.M{}
+		if status, ok := c.Request.URL.Query()["deliverability"]; ok {
+			userFilter["deliverability.status"] = status[0]
+		}
+		cursor, err := client.Database("generic_"+config.ID.Hex()).Collection("users").Find(ctx, userFilter, options)
 		if err != nil {
 			c.JSON(500, gin.H{"error": "Could not load the users"})
 			return
@@ -362,25 +331,7 @@ func SetupAdminRoute(r *gin.Engine, client *mongo.Client) {
 	})
 
 	r.GET("/admin/configs/:configId/users/:userId", func(c *gin.Context) {
-		url := location.Get(c)
-		if url.Hostname() != adminDomain {
-			c.JSON(400, gin.H{
-				"error": "This route is not available",
-			})
-			return
-		}
-
-		password, providedPassword := c.Request.URL.Query()["password"]
-		if !providedPassword {
-			c.JSON(400, gin.H{
-				"error": "You must specify the password query field",
-			})
-			return
-		}
-		if !CheckPasswordHash(password[0], "$2a$14$"+os.Getenv("ADMIN_PASSWORD_HASH")) {
-			c.JSON(400, gin.H{
-				"error": "Passed password is wrong",
-			})
+		if !isAdminRequest(c, adminDomain) {
 			return
 		}
 
@@ -431,4 +382,402 @@ func SetupAdminRoute(r *gin.Engine, client *mongo.Client) {
 		c.String(200, string(jsonBytes))
 	})
 
+	r.PUT("/admin/configs/:configId/users/:userId/plan", func(c *gin.Context) {
+		if !isAdminRequest(c, adminDomain) {
+			return
+		}
+
+		plan, providedPlan := c.Request.URL.Query()["plan"]
+		if !providedPlan {
+			c.JSON(400, gin.H{"error": "You must specify the plan query field"})
+			return
+		}
+		reason := c.Request.URL.Query().Get("reason")
+		if reason == "" {
+			reason = "Changed by an administrator"
+		}
+
+		configId, err := primitive.ObjectIDFromHex(c.Param("configId"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Passed an invalid configuration id"})
+			return
+		}
+
+		userId, err := primitive.ObjectIDFromHex(c.Param("userId"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Passed an invalid user id"})
+			return
+		}
+
+		config, err := loadServerConfigByID(client, configId)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+
+		err = config.changeUserPlan(userId, plan[0], reason)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.String(200, "")
+	})
+
+	r.GET("/admin/configs/:configId/usage", func(c *gin.Context) {
+		if !isAdminRequest(c, adminDomain) {
+			return
+		}
+
+		configId, err := primitive.ObjectIDFromHex(c.Param("configId"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Passed an invalid configuration id"})
+			return
+		}
+
+		config, err := loadServerConfigByID(client, configId)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+
+		usage, err := loadTenantUsage(config)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Could not compute the usage of the server"})
+			fmt.Println(err)
+			return
+		}
+
+		c.JSON(200, usage)
+	})
+
+	r.GET("/admin/configs/:configId/stats", func(c *gin.Context) {
+		if !isAdminRequest(c, adminDomain) {
+			return
+		}
+
+		configId, err := primitive.ObjectIDFromHex(c.Param("configId"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Passed an invalid configuration id"})
+			return
+		}
+
+		period := c.Request.URL.Query().Get("period")
+		if period == "" {
+			period = "daily"
+		}
+
+		to := time.Now().UTC()
+		if _to := c.Request.URL.Query().Get("to"); _to != "" {
+			to, err = time.Parse("2006-01-02", _to)
+			if err != nil {
+				c.JSON(400, gin.H{"error": "To must be a date in the format YYYY-MM-DD"})
+				return
+			}
+		}
+		var from time.Time
+		switch period {
+		case "weekly":
+			from = to.AddDate(0, 0, -7*12)
+		case "monthly":
+			from = to.AddDate(-1, 0, 0)
+		default:
+			from = to.AddDate(0, 0, -30)
+		}
+		if _from := c.Request.URL.Query().Get("from"); _from != "" {
+			from, err = time.Parse("2006-01-02", _from)
+			if err != nil {
+				c.JSON(400, gin.H{"error": "From must be a date in the format YYYY-MM-DD"})
+				return
+			}
+		}
+
+		config, err := loadServerConfigByID(client, configId)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+
+		series, err := loadUsageSeries(client, config, period, from, to)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		usage, err := loadTenantUsage(config)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Could not compute the usage of the server"})
+			fmt.Println(err)
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"period":  period,
+			"from":    from.Format("2006-01-02"),
+			"to":      to.Format("2006-01-02"),
+			"current": usage,
+			"series":  series,
+		})
+	})
+
+	r.GET("/admin/configs/:configId/emails/failed", func(c *gin.Context) {
+		if !isAdminRequest(c, adminDomain) {
+			return
+		}
+
+		_limit, providedLimit := c.Request.URL.Query()["limit"]
+		var limit int64 = 30
+		if providedLimit {
+			i1, err := strconv.Atoi(_limit[0])
+			if err != nil {
+				c.JSON(400, gin.H{
+					"error": "Limit must be an integer",
+				})
+				return
+			}
+			if i1 < 30 {
+				limit = int64(i1)
+			}
+		}
+		_offset, providedOffset := c.Request.URL.Query()["offset"]
+		var offset int64 = 0
+		if providedOffset {
+			i1, err := strconv.Atoi(_offset[0])
+			if err != nil {
+				c.JSON(400, gin.H{
+					"error": "Offset must be an integer",
+				})
+				return
+			}
+			if i1 > 0 {
+				offset = int64(i1)
+			}
+		}
+
+		configId, err := primitive.ObjectIDFromHex(c.Param("configId"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Passed an invalid configuration id"})
+			return
+		}
+
+		emails, err := loadDeadEmails(client, configId, limit, offset)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Could not load the failed emails"})
+			fmt.Println(err)
+			return
+		}
+
+		c.JSON(200, emails)
+	})
+
+	r.POST("/admin/configs/:configId/emails/:emailId/retry", func(c *gin.Context) {
+		if !isAdminRequest(c, adminDomain) {
+			return
+		}
+
+		configId, err := primitive.ObjectIDFromHex(c.Param("configId"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Passed an invalid configuration id"})
+			return
+		}
+
+		emailId, err := primitive.ObjectIDFromHex(c.Param("emailId"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Passed an invalid email id"})
+			return
+		}
+
+		err = retryDeadEmail(client, configId, emailId)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.String(200, "")
+	})
+
+	r.POST("/admin/configs/:configId/emails/test", func(c *gin.Context) {
+		if !isAdminRequest(c, adminDomain) {
+			return
+		}
+
+		configId, err := primitive.ObjectIDFromHex(c.Param("configId"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Passed an invalid configuration id"})
+			return
+		}
+		recipient := c.Request.URL.Query().Get("to")
+		if _, err := mail.ParseAddress(recipient); err != nil {
+			c.JSON(400, gin.H{"error": "You must pass a valid recipient in the to parameter"})
+			return
+		}
+
+		config, err := loadServerConfigByID(client, configId)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(200, config.sendTestEmail(recipient))
+	})
+
+	r.POST("/admin/configs/:configId/emails/templates/:name/preview", func(c *gin.Context) {
+		if !isAdminRequest(c, adminDomain) {
+			return
+		}
+
+		configId, err := primitive.ObjectIDFromHex(c.Param("configId"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Passed an invalid configuration id"})
+			return
+		}
+
+		config, err := loadServerConfigByID(client, configId)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+
+		// the body can contain a draft of the template and the sample variables
+		var preview struct {
+			Template *EmailTemplate
+			Vars     map[string]string
+		}
+		jsonData, err := ioutil.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Failed to parse body"})
+			return
+		}
+		if len(jsonData) > 0 {
+			err = json.Unmarshal(jsonData, &preview)
+			if err != nil {
+				c.JSON(400, gin.H{"error": "The preview request is invalid"})
+				return
+			}
+		}
+
+		name := c.Param("name")
+		if preview.Template != nil {
+			if config.Email.Templates == nil {
+				config.Email.Templates = map[string]EmailTemplate{}
+			}
+			config.Email.Templates[name] = *preview.Template
+
+			err = validateEmailSettings(EmailSettings{Templates: map[string]EmailTemplate{name: *preview.Template}})
+			if err != nil {
+				c.JSON(400, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		if preview.Vars == nil {
+			preview.Vars = sampleEmailVars[name]
+		}
+
+		locale := c.Request.URL.Query().Get("locale")
+		message, err := config.renderEmail(name, "jane.doe@example.com", locale, preview.Vars)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(200, gin.H{
+			"from":    message.From,
+			"replyTo": message.ReplyTo,
+			"subject": message.Subject,
+			"html":    message.HTML,
+			"text":    message.Text,
+		})
+	})
+
+	r.POST("/admin/configs/:configId/announcements", func(c *gin.Context) {
+		if !isAdminRequest(c, adminDomain) {
+			return
+		}
+
+		configId, err := primitive.ObjectIDFromHex(c.Param("configId"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Passed an invalid configuration id"})
+			return
+		}
+
+		config, err := loadServerConfigByID(client, configId)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+
+		var request struct {
+			Template EmailTemplate
+			Plan     string
+		}
+		jsonData, err := ioutil.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Failed to parse body"})
+			return
+		}
+		err = json.Unmarshal(jsonData, &request)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "The announcement passed is invalid"})
+			return
+		}
+
+		announcement, err := config.createAnnouncement(request.Template, request.Plan)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(200, announcement)
+	})
+
+	r.GET("/admin/configs/:configId/announcements/:announcementId", func(c *gin.Context) {
+		if !isAdminRequest(c, adminDomain) {
+			return
+		}
+
+		configId, err := primitive.ObjectIDFromHex(c.Param("configId"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Passed an invalid configuration id"})
+			return
+		}
+
+		announcementId, err := primitive.ObjectIDFromHex(c.Param("announcementId"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Passed an invalid announcement id"})
+			return
+		}
+
+		progress, err := loadAnnouncementProgress(client, configId, announcementId)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(200, progress)
+	})
+
+	r.POST("/admin/configs/:configId/announcements/:announcementId/cancel", func(c *gin.Context) {
+		if !isAdminRequest(c, adminDomain) {
+			return
+		}
+
+		configId, err := primitive.ObjectIDFromHex(c.Param("configId"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Passed an invalid configuration id"})
+			return
+		}
+
+		announcementId, err := primitive.ObjectIDFromHex(c.Param("announcementId"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Passed an invalid announcement id"})
+			return
+		}
+
+		err = cancelAnnouncement(client, configId, announcementId)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.String(200, "")
+	})
 }
@@ -0,0 +1,398 @@
+package internal
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	announcementSending   = "sending"
+	announcementCompleted = "completed"
+	announcementCancelled = "cancelled"
+	announcementFailed    = "failed"
+
+	// defaultAnnouncementsPerMinute is the number of announcement emails
+	// queued per minute if the tenant doesn't configure it
+	defaultAnnouncementsPerMinute = 300
+	// announcementInterval is the time between two batches of an announcement
+	announcementInterval = 10 * time.Second
+	// maxAnnouncementAttempts is the number of consecutive failed batches
+	// after which an announcement is marked as failed
+	maxAnnouncementAttempts = 8
+)
+
+// Announcement is an email sent to all the users of a tenant, or to the
+// users of a plan, the users are queued in batches to throttle the sending
+type Announcement struct {
+	ID       primitive.ObjectID `bson:"_id,omitempty"`
+	ConfigID primitive.ObjectID `bson:"configId"`
+	Template EmailTemplate
+	// Plan restricts the announcement to the users of the plan if not empty
+	Plan        string
+	Status      string
+	Total       int64
+	Queued      int64
+	Skipped     int64
+	LastUserID  primitive.ObjectID `bson:"lastUserId"`
+	NextBatchAt time.Time          `bson:"nextBatchAt"`
+	CreatedAt   time.Time          `bson:"createdAt"`
+	CompletedAt time.Time          `bson:"completedAt,omitempty"`
+	// FailedAttempts counts the consecutive batches that stopped on an error
+	FailedAttempts int    `bson:"failedAttempts"`
+	LastError      string `bson:"lastError,omitempty"`
+}
+
+// AnnouncementProgress is the state of an announcement and of its emails
+type AnnouncementProgress struct {
+	Announcement
+	Sent    int64
+	Pending int64
+	Failed  int64
+}
+
+func announcementsCollection(client *mongo.Client) *mongo.Collection {
+	return client.Database("administration").Collection("announcements")
+}
+
+// announcementsPerMinute returns the number of announcement emails of the
+// tenant queued each minute
+func (config *DatabaseConfig) announcementsPerMinute() int {
+	if config.Email.AnnouncementsPerMinute > 0 {
+		return config.Email.AnnouncementsPerMinute
+	}
+
+	return defaultAnnouncementsPerMinute
+}
+
+// announcementRecipients returns the filter matching the users that receive
+// an announcement for the passed plan, users without a plan are on the
+// default one
+func (config *DatabaseConfig) announcementRecipients(plan string) bson.M {
+	if plan == "" {
+		return bson.M{}
+	} else if plan == config.defaultPlan() {
+		return bson.M{"plan": bson.M{"$in": bson.A{plan, "", nil}}}
+	}
+
+	return bson.M{"plan": plan}
+}
+
+// unsubscribeToken signs the id of the user so that the unsubscribe links
+// cannot be forged
+func (config *DatabaseConfig) unsubscribeToken(userID primitive.ObjectID) string {
+	mac := hmac.New(sha256.New, []byte(os.Getenv("JWT_SECRET")))
+	mac.Write([]byte("unsubscribe/" + config.ID.Hex() + "/" + userID.Hex()))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// checkUnsubscribeToken returns true if the token was generated for the user
+func (config *DatabaseConfig) checkUnsubscribeToken(userID primitive.ObjectID, token string) bool {
+	return hmac.Equal([]byte(config.unsubscribeToken(userID)), []byte(token))
+}
+
+// unsubscribeLink returns the link that stops the announcements to the user
+func (config *DatabaseConfig) unsubscribeLink(userID primitive.ObjectID) string {
+	query := url.Values{}
+	query.Set("user", userID.Hex())
+	query.Set("token", config.unsubscribeToken(userID))
+
+	return "https://" + config.Domain + "/user/unsubscribe?" + query.Encode()
+}
+
+// withUnsubscribeLink adds the unsubscribe link at the bottom of the
+// template, unless the template already shows it
+func withUnsubscribeLink(emailTemplate EmailTemplate) EmailTemplate {
+	if !strings.Contains(emailTemplate.Html, ".Vars.UnsubscribeLink") {
+		emailTemplate.Html += `<br/><br/><small><a href="{{.Vars.UnsubscribeLink}}">Unsubscribe</a></small>`
+	}
+	if emailTemplate.Text != "" && !strings.Contains(emailTemplate.Text, ".Vars.UnsubscribeLink") {
+		emailTemplate.Text += "\n\nUnsubscribe: {{.Vars.UnsubscribeLink}}"
+	}
+
+	return emailTemplate
+}
+
+// localizedTemplate returns the translation of the template for the locale,
+// falling back like the transactional emails
+func localizedTemplate(emailTemplate EmailTemplate, locale string, defaultLocale string) EmailTemplate {
+	candidates := append(localeFallbackChain(locale), localeFallbackChain(defaultLocale)...)
+	for _, candidate := range candidates {
+		if translation, ok := emailTemplate.variant(candidate); ok {
+			return mergeEmailTemplates(emailTemplate, translation)
+		}
+	}
+
+	return mergeEmailTemplates(emailTemplate, EmailTemplate{})
+}
+
+// createAnnouncement validates the template and schedules the announcement
+func (config *DatabaseConfig) createAnnouncement(emailTemplate EmailTemplate, plan string) (*Announcement, error) {
+	if emailTemplate.Subject == "" || emailTemplate.Html == "" {
+		return nil, fmt.Errorf("The announcement must have a subject and an html body")
+	}
+	err := validateEmailTemplate("announcement", emailTemplate)
+	if err != nil {
+		return nil, err
+	}
+	for locale, translation := range emailTemplate.Locales {
+		if !isValidLocale(locale) {
+			return nil, fmt.Errorf("The locale %s of the announcement is not a valid language tag", locale)
+		}
+		err = validateEmailTemplate("announcement ("+locale+")", translation)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if _, ok := config.Plans[plan]; plan != "" && !ok && plan != config.defaultPlan() {
+		return nil, fmt.Errorf("The plan %s is not defined in the server configuration", plan)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	total, err := config.UserCollection.CountDocuments(ctx, config.announcementRecipients(plan))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	announcement := Announcement{
+		ConfigID:    config.ID,
+		Template:    emailTemplate,
+		Plan:        plan,
+		Status:      announcementSending,
+		Total:       total,
+		NextBatchAt: now,
+		CreatedAt:   now,
+	}
+	res, err := announcementsCollection(config.UserCollection.Database().Client()).InsertOne(ctx, announcement)
+	if err != nil {
+		return nil, err
+	}
+	announcement.ID = res.InsertedID.(primitive.ObjectID)
+
+	return &announcement, nil
+}
+
+// StartAnnouncementWorker periodically queues the next batch of recipients
+// of the announcements being sent
+func StartAnnouncementWorker(client *mongo.Client) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := emailOutbox(client).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.M{"announcementId": 1},
+		Options: options.Index().SetSparse(true),
+	})
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	go func() {
+		for range time.Tick(time.Second) {
+			for queueNextAnnouncementBatch(client) {
+			}
+		}
+	}()
+}
+
+// queueNextAnnouncementBatch claims an announcement whose batch is due and
+// queues its next recipients, it returns false if no batch was due. The
+// time of the next batch is set when claiming so that the throttling holds
+// across multiple servers
+func queueNextAnnouncementBatch(client *mongo.Client) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	var announcement Announcement
+	err := announcementsCollection(client).FindOneAndUpdate(
+		ctx,
+		bson.M{"status": announcementSending, "nextBatchAt": bson.M{"$lte": now}},
+		bson.M{"$set": bson.M{"nextBatchAt": now.Add(announcementInterval)}},
+		options.FindOneAndUpdate().SetSort(bson.M{"nextBatchAt": 1}),
+	).Decode(&announcement)
+	if err == mongo.ErrNoDocuments {
+		return false
+	} else if err != nil {
+		fmt.Println(err)
+		return false
+	}
+
+	config, err := loadServerConfigByID(client, announcement.ConfigID)
+	if err != nil {
+		// the tenant was deleted
+		updateAnnouncement(ctx, client, announcement.ID, bson.M{"$set": bson.M{"status": announcementCancelled}})
+		return true
+	}
+
+	batchSize := config.announcementsPerMinute() * int(announcementInterval/time.Second) / 60
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	filter := config.announcementRecipients(announcement.Plan)
+	filter["_id"] = bson.M{"$gt": announcement.LastUserID}
+	cursor, err := config.UserCollection.Find(
+		ctx,
+		filter,
+		options.Find().
+			SetSort(bson.M{"_id": 1}).
+			SetLimit(int64(batchSize)).
+			SetProjection(bson.M{"email": 1, "locale": 1, "unsubscribed": 1, "deliverability": 1}),
+	)
+	if err != nil {
+		failAnnouncementBatch(ctx, client, announcement, bson.M{"$set": bson.M{}}, err)
+		return true
+	}
+	var users []User
+	err = cursor.All(ctx, &users)
+	if err != nil {
+		failAnnouncementBatch(ctx, client, announcement, bson.M{"$set": bson.M{}}, err)
+		return true
+	}
+
+	var queued, skipped int64
+	var batchErr error
+	lastUserID := announcement.LastUserID
+	for _, user := range users {
+		if !user.Unsubscribed && user.Deliverability.Status != hardBounced {
+			emailTemplate := withUnsubscribeLink(localizedTemplate(announcement.Template, user.Locale, config.Email.DefaultLocale))
+			message, err := config.renderEmailTemplate(emailTemplate, config.emailTemplateData(user.Email, map[string]string{
+				"UnsubscribeLink": config.unsubscribeLink(user.ID),
+			}))
+			if err != nil {
+				batchErr = err
+				break
+			}
+			err = config.queueOutboxEmail(OutboxEmail{Message: message, AnnouncementID: announcement.ID})
+			if err == errSuppressed {
+				skipped++
+			} else if err != nil {
+				batchErr = err
+				break
+			} else {
+				queued++
+			}
+		} else {
+			skipped++
+		}
+		lastUserID = user.ID
+	}
+
+	update := bson.M{
+		"$set": bson.M{"lastUserId": lastUserID},
+		"$inc": bson.M{"queued": queued, "skipped": skipped},
+	}
+	if batchErr != nil {
+		failAnnouncementBatch(ctx, client, announcement, update, batchErr)
+		return true
+	}
+
+	update["$set"].(bson.M)["failedAttempts"] = 0
+	if len(users) < batchSize {
+		update["$set"].(bson.M)["status"] = announcementCompleted
+		update["$set"].(bson.M)["completedAt"] = time.Now()
+	}
+	updateAnnouncement(ctx, client, announcement.ID, update)
+
+	return true
+}
+
+// failAnnouncementBatch applies the update of a batch that stopped on an
+// error, the batch is retried at the next interval until the announcement
+// fails maxAnnouncementAttempts times in a row
+func failAnnouncementBatch(ctx context.Context, client *mongo.Client, announcement Announcement, update bson.M, batchErr error) {
+	fmt.Println(batchErr)
+
+	attempts := announcement.FailedAttempts + 1
+	update["$set"].(bson.M)["failedAttempts"] = attempts
+	update["$set"].(bson.M)["lastError"] = batchErr.Error()
+	if attempts >= maxAnnouncementAttempts {
+		update["$set"].(bson.M)["status"] = announcementFailed
+		update["$set"].(bson.M)["completedAt"] = time.Now()
+	}
+	updateAnnouncement(ctx, client, announcement.ID, update)
+}
+
+func updateAnnouncement(ctx context.Context, client *mongo.Client, id primitive.ObjectID, update bson.M) {
+	_, err := announcementsCollection(client).UpdateOne(ctx, bson.M{"_id": id}, update)
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+// loadAnnouncementProgress returns the announcement with the delivery state
+// of the emails queued so far
+func loadAnnouncementProgress(client *mongo.Client, configID primitive.ObjectID, announcementID primitive.ObjectID) (*AnnouncementProgress, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var progress AnnouncementProgress
+	err := announcementsCollection(client).FindOne(
+		ctx,
+		bson.M{"_id": announcementID, "configId": configID},
+	).Decode(&progress.Announcement)
+	if err == mongo.ErrNoDocuments {
+		return nil, fmt.Errorf("No announcement exists with the passed id")
+	} else if err != nil {
+		return nil, err
+	}
+
+	cursor, err := emailOutbox(client).Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"announcementId": announcementID}}},
+		{{Key: "$group", Value: bson.M{"_id": "$status", "count": bson.M{"$sum": 1}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var statuses []struct {
+		Status string `bson:"_id"`
+		Count  int64
+	}
+	err = cursor.All(ctx, &statuses)
+	if err != nil {
+		return nil, err
+	}
+	for _, status := range statuses {
+		switch status.Status {
+		case emailSent:
+			progress.Sent = status.Count
+		case emailDead:
+			progress.Failed = status.Count
+		default:
+			progress.Pending += status.Count
+		}
+	}
+
+	return &progress, nil
+}
+
+// cancelAnnouncement stops queuing the recipients of the announcement, the
+// emails already queued are still sent
+func cancelAnnouncement(client *mongo.Client, configID primitive.ObjectID, announcementID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := announcementsCollection(client).UpdateOne(
+		ctx,
+		bson.M{"_id": announcementID, "configId": configID, "status": announcementSending},
+		bson.M{"$set": bson.M{"status": announcementCancelled, "completedAt": time.Now()}},
+	)
+	if err != nil {
+		return err
+	} else if res.MatchedCount == 0 {
+		return fmt.Errorf("No announcement is being sent with the passed id")
+	}
+
+	return nil
+}
@@ -0,0 +1,137 @@
+package internal
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// apiKeyPrefix is the prefix of the Authorization header of the requests
+// authenticated with an API key
+const apiKeyPrefix = "ApiKey "
+
+// ApiKey is a long lived credential of a user that authenticates the access
+// to the data, only the hash of the key is stored
+type ApiKey struct {
+	ID        primitive.ObjectID `bson:"id" json:"id"`
+	Name      string             `json:"name"`
+	KeyHash   string             `bson:"keyHash" json:"-"`
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+// errApiKeyLimit is returned when the plan of the user doesn't allow
+// another API key
+type errApiKeyLimit struct {
+	max int
+}
+
+func (e errApiKeyLimit) Error() string {
+	return fmt.Sprintf("Your plan allows at most %d API keys", e.max)
+}
+
+// errStorageLimit is returned when a change would make the record of the
+// user bigger than the storage allowed by the plan
+type errStorageLimit struct{}
+
+func (e errStorageLimit) Error() string {
+	return "The storage allowed by your plan is exhausted"
+}
+
+// createApiKey creates a new API key for the user and returns it, the key
+// cannot be retrieved afterwards
+func (config *DatabaseConfig) createApiKey(userID string, planName string, name string) (string, *ApiKey, error) {
+	config.ensureUserIndexes()
+
+	keyBytes := make([]byte, 32)
+	_, err := rand.Read(keyBytes)
+	if err != nil {
+		return "", nil, err
+	}
+	key := hex.EncodeToString(keyBytes)
+	apiKey := ApiKey{
+		ID:        primitive.NewObjectID(),
+		Name:      name,
+		KeyHash:   hashToken(key),
+		CreatedAt: time.Now(),
+	}
+
+	limits := config.planLimits(planName)
+	if limits.MaxStorageBytes > 0 {
+		record, err := config.loadUserRecord(userID)
+		if err != nil {
+			return "", nil, err
+		}
+		apiKeys, _ := record["apiKeys"].(bson.A)
+		record["apiKeys"] = append(apiKeys, apiKey)
+
+		exceeded, err := config.exceedsStorageLimit(planName, record)
+		if err != nil {
+			return "", nil, err
+		} else if exceeded {
+			return "", nil, errStorageLimit{}
+		}
+	}
+
+	// the filter on the last allowed key makes the limit hold with
+	// concurrent requests
+	objID, _ := primitive.ObjectIDFromHex(userID)
+	filter := bson.M{"_id": objID}
+	if limits.MaxApiKeys > 0 {
+		filter["apiKeys."+strconv.Itoa(limits.MaxApiKeys-1)] = bson.M{"$exists": false}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	res, err := config.UserCollection.UpdateOne(ctx, filter, bson.M{
+		"$push": bson.M{"apiKeys": apiKey},
+	})
+	if err != nil {
+		return "", nil, err
+	} else if res.ModifiedCount == 0 {
+		return "", nil, errApiKeyLimit{limits.MaxApiKeys}
+	}
+
+	return key, &apiKey, nil
+}
+
+// deleteApiKey revokes the API key of the user, it returns false if the key
+// doesn't exist
+func (config *DatabaseConfig) deleteApiKey(userID string, keyID primitive.ObjectID) (bool, error) {
+	objID, _ := primitive.ObjectIDFromHex(userID)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := config.UserCollection.UpdateOne(ctx, bson.M{"_id": objID}, bson.M{
+		"$pull": bson.M{"apiKeys": bson.M{"id": keyID}},
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return res.ModifiedCount > 0, nil
+}
+
+// findApiKeyUser returns the id of the user owning the API key
+func (config *DatabaseConfig) findApiKeyUser(key string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var user User
+	err := config.UserCollection.FindOne(
+		ctx,
+		bson.M{"apiKeys.keyHash": hashToken(key)},
+		options.FindOne().SetProjection(bson.M{"_id": 1}),
+	).Decode(&user)
+	if err != nil {
+		return "", err
+	}
+
+	return user.ID.Hex(), nil
+}
@@ -6,6 +6,8 @@ import (
 	"strings"
 
 	"github.com/dgrijalva/jwt-go"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -43,6 +45,55 @@ func parseBearer(authorizationHeader []string) (*AccessToken, error) {
 	return parsedToken, nil
 }
 
+// authenticateUser checks the credentials of the request and the request
+// rate of the plan of the user, it returns the id and the plan of the user.
+// If the request must not be handled it replies and returns false. API keys
+// are accepted only if allowApiKey is set
+func (config *DatabaseConfig) authenticateUser(c *gin.Context, allowApiKey bool) (string, string, bool) {
+	authorizationHeader := c.Request.Header["Authorization"]
+
+	var userID string
+	if len(authorizationHeader) > 0 && strings.HasPrefix(authorizationHeader[0], apiKeyPrefix) {
+		if !allowApiKey {
+			c.JSON(403, gin.H{"error": "This route requires an authentication token instead of an API key"})
+			return "", "", false
+		}
+
+		keyUserID, err := config.findApiKeyUser(authorizationHeader[0][len(apiKeyPrefix):])
+		if err == mongo.ErrNoDocuments {
+			c.JSON(401, gin.H{"error": "Invalid API key"})
+			return "", "", false
+		} else if err != nil {
+			panic(err)
+		}
+		userID = keyUserID
+	} else {
+		parsedToken, err := parseBearer(authorizationHeader)
+		if err != nil {
+			c.JSON(500, gin.H{"error": "Failed to parse authorization token"})
+			return "", "", false
+		}
+		userID = parsedToken.UserID
+	}
+
+	// the token of a deleted user is still correctly signed
+	userPlan, err := config.loadUserPlan(userID)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(401, gin.H{"error": "The authenticated user doesn't exist anymore"})
+		return "", "", false
+	} else if err != nil {
+		panic(err)
+	}
+
+	config.recordActivity(userID)
+	if !config.allowUserRequest(userID, userPlan) {
+		c.JSON(429, gin.H{"error": "Too many requests, try again later"})
+		return "", "", false
+	}
+
+	return userID, userPlan, true
+}
+
 // VerifyToken checks that the passed token is valid and returns its decoded content
 func VerifyToken(tokenString string) (*AccessToken, error) {
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
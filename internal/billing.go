@@ -0,0 +1,219 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ZaninAndrea/shipyard-backend/pkg/billing"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// BillingSettings configures how the events of the payment provider of a
+// tenant are mapped to plan changes
+type BillingSettings struct {
+	Provider      string
+	WebhookSecret string
+	// PricePlans maps the price ids of the provider to the plan names
+	PricePlans map[string]string
+	// FallbackPlan is assigned when a subscription ends, the default plan
+	// is used if it is empty
+	FallbackPlan string
+	// GracePeriodDays is how long a user keeps the plan after a failed payment
+	GracePeriodDays int
+}
+
+// UserBilling is the billing state of a user
+type UserBilling struct {
+	GraceUntil *time.Time `bson:"graceUntil,omitempty"`
+	// LastEventAt is the creation time of the last applied billing event
+	LastEventAt *time.Time `bson:"lastEventAt,omitempty"`
+}
+
+func (config *DatabaseConfig) fallbackPlan() string {
+	if config.Billing.FallbackPlan != "" {
+		return config.Billing.FallbackPlan
+	}
+
+	return config.defaultPlan()
+}
+
+// loadUserPlan returns the plan of the user, downgrading it to the fallback
+// plan if the grace period after a failed payment expired
+func (config *DatabaseConfig) loadUserPlan(userID string) (string, error) {
+	user, err := findUserByID(userID, config.UserCollection, bson.M{"plan": 1, "billing": 1})
+	if err != nil {
+		return "", err
+	}
+	if user.Billing.GraceUntil == nil || time.Now().Before(*user.Billing.GraceUntil) {
+		return user.Plan, nil
+	}
+
+	err = config.changeUserPlan(user.ID, config.fallbackPlan(), "The grace period after a failed payment expired")
+	if err != nil {
+		fmt.Println(err)
+		return user.Plan, nil
+	}
+	err = config.clearGracePeriod(user.ID)
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	return config.fallbackPlan(), nil
+}
+
+func (config *DatabaseConfig) clearGracePeriod(userID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := config.UserCollection.UpdateOne(ctx, bson.M{"_id": userID}, bson.M{
+		"$unset": bson.M{"billing.graceUntil": ""},
+	})
+	return err
+}
+
+// registerBillingEvent stores the id of the event and returns false if the
+// event was already processed
+func (config *DatabaseConfig) registerBillingEvent(event *billing.Event) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := config.UserCollection.Database().Collection("billingEvents").InsertOne(ctx, bson.M{
+		"_id":        event.ID,
+		"type":       event.Type,
+		"receivedAt": time.Now(),
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// forgetBillingEvent removes the id of an event whose processing failed, so
+// that the provider can retry it
+func (config *DatabaseConfig) forgetBillingEvent(event *billing.Event) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := config.UserCollection.Database().Collection("billingEvents").DeleteOne(ctx, bson.M{"_id": event.ID})
+	return err
+}
+
+func (config *DatabaseConfig) findBillingUser(event *billing.Event) (*User, error) {
+	var filter bson.M
+	if event.UserID != "" {
+		objID, err := primitive.ObjectIDFromHex(event.UserID)
+		if err != nil {
+			return nil, fmt.Errorf("The event references an invalid user id")
+		}
+		filter = bson.M{"_id": objID}
+	} else if event.Email != "" {
+		filter = bson.M{"email": event.Email}
+	} else {
+		return nil, fmt.Errorf("The event does not reference a user")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var user User
+	err := config.UserCollection.FindOne(ctx, filter).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return nil, fmt.Errorf("The user referenced by the event does not exist")
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// claimBillingEvent records the event as the last one applied to the user
+// and returns false if a more recent event was already applied, the
+// providers don't guarantee the delivery order
+func (config *DatabaseConfig) claimBillingEvent(user *User, event *billing.Event) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := config.UserCollection.UpdateOne(
+		ctx,
+		bson.M{
+			"_id": user.ID,
+			"$or": bson.A{
+				bson.M{"billing.lastEventAt": bson.M{"$exists": false}},
+				bson.M{"billing.lastEventAt": bson.M{"$lte": event.CreatedAt}},
+			},
+		},
+		bson.M{"$set": bson.M{"billing.lastEventAt": event.CreatedAt}},
+	)
+	if err != nil {
+		return false, err
+	}
+
+	return res.MatchedCount > 0, nil
+}
+
+// applyBillingEvent transitions the plan of the user referenced by the
+// event, it returns false if the event is older than the last applied one
+func (config *DatabaseConfig) applyBillingEvent(event *billing.Event) (bool, error) {
+	user, err := config.findBillingUser(event)
+	if err != nil {
+		return false, err
+	}
+	claimed, err := config.claimBillingEvent(user, event)
+	if err != nil || !claimed {
+		return false, err
+	}
+
+	return true, config.applyBillingTransition(user, event)
+}
+
+// applyBillingTransition changes the plan and the grace period of the user
+// as required by the event
+func (config *DatabaseConfig) applyBillingTransition(user *User, event *billing.Event) error {
+	var err error
+	reason := fmt.Sprintf("Billing event %s (%s)", event.Type, event.ID)
+
+	switch event.Type {
+	case billing.SubscriptionCreated, billing.SubscriptionUpdated:
+		plan, ok := config.Billing.PricePlans[event.PriceID]
+		if !ok {
+			return fmt.Errorf("The price %s is not associated to any plan", event.PriceID)
+		}
+
+		err = config.changeUserPlan(user.ID, plan, reason)
+		if err != nil {
+			return err
+		}
+		return config.clearGracePeriod(user.ID)
+	case billing.SubscriptionCancelled:
+		err = config.changeUserPlan(user.ID, config.fallbackPlan(), reason)
+		if err != nil {
+			return err
+		}
+		return config.clearGracePeriod(user.ID)
+	case billing.PaymentFailed:
+		if config.Billing.GracePeriodDays <= 0 {
+			return config.changeUserPlan(user.ID, config.fallbackPlan(), reason)
+		}
+		if user.Billing.GraceUntil != nil {
+			return nil
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		graceUntil := time.Now().AddDate(0, 0, config.Billing.GracePeriodDays)
+		_, err = config.UserCollection.UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{
+			"$set": bson.M{"billing.graceUntil": graceUntil},
+		})
+		return err
+	case billing.PaymentSucceeded:
+		return config.clearGracePeriod(user.ID)
+	}
+
+	return nil
+}
@@ -0,0 +1,75 @@
+package internal
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/ZaninAndrea/shipyard-backend/pkg/billing"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func SetupBillingRoute(r *gin.Engine, client *mongo.Client) {
+	r.POST("/billing/webhook", func(c *gin.Context) {
+		config, err := GetServerConfig(c, client)
+		if err != nil {
+			c.JSON(400, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		if config.Billing.WebhookSecret == "" {
+			c.JSON(400, gin.H{"error": "Billing is not configured for this server"})
+			return
+		}
+
+		provider, err := billing.GetProvider(config.Billing.Provider)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+
+		payload, err := ioutil.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Failed to read body"})
+			return
+		}
+		err = provider.VerifySignature(payload, c.Request.Header, config.Billing.WebhookSecret)
+		if err != nil {
+			c.JSON(401, gin.H{"error": err.Error()})
+			return
+		}
+
+		event, err := provider.ParseEvent(payload)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		} else if event == nil {
+			c.JSON(200, gin.H{"ignored": true})
+			return
+		}
+
+		isNew, err := config.registerBillingEvent(event)
+		if err != nil {
+			panic(err)
+		} else if !isNew {
+			c.JSON(200, gin.H{"duplicate": true})
+			return
+		}
+
+		applied, err := config.applyBillingEvent(event)
+		if err != nil {
+			if forgetErr := config.forgetBillingEvent(event); forgetErr != nil {
+				fmt.Println(forgetErr)
+			}
+
+			c.JSON(422, gin.H{"error": err.Error()})
+			return
+		} else if !applied {
+			c.JSON(200, gin.H{"outdated": true})
+			return
+		}
+
+		c.String(200, "")
+	})
+}
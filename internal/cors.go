@@ -0,0 +1,151 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-contrib/cors"
+	"github.com/gin-contrib/location"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// CorsSettings is the CORS policy of a tenant, if AllowOrigins is empty every
+// origin is allowed
+type CorsSettings struct {
+	AllowOrigins     []string
+	AllowMethods     []string
+	AllowHeaders     []string
+	AllowCredentials bool
+	MaxAgeSeconds    int
+}
+
+// corsConfig converts the settings to a configuration for the cors middleware
+func (settings CorsSettings) corsConfig() (cors.Config, error) {
+	config := cors.Config{
+		AllowOrigins:     settings.AllowOrigins,
+		AllowMethods:     settings.AllowMethods,
+		AllowHeaders:     settings.AllowHeaders,
+		ExposeHeaders:    []string{"Content-Length"},
+		AllowCredentials: settings.AllowCredentials,
+		AllowWildcard:    true,
+		MaxAge:           time.Duration(settings.MaxAgeSeconds) * time.Second,
+	}
+	if len(config.AllowOrigins) == 0 {
+		config.AllowOrigins = []string{"*"}
+	}
+	if len(config.AllowMethods) == 0 {
+		config.AllowMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE"}
+	}
+	if len(config.AllowHeaders) == 0 {
+		config.AllowHeaders = []string{"Origin", "Content-Type", "Authorization"}
+	}
+	if settings.MaxAgeSeconds == 0 {
+		config.MaxAge = 12 * time.Hour
+	}
+
+	for _, origin := range config.AllowOrigins {
+		if strings.Count(origin, "*") > 1 {
+			return config, fmt.Errorf("The CORS origin %s contains more than one *", origin)
+		}
+	}
+	// Validate sets AllowAllOrigins for the * origin, which cors.New would
+	// then reject as conflicting with AllowOrigins, so a copy is validated
+	validated := config
+	err := validated.Validate()
+	if err != nil {
+		return config, fmt.Errorf("The CORS settings are invalid: %s", err.Error())
+	}
+
+	return config, nil
+}
+
+// adminCorsConfig only allows the origins listed in ADMIN_ALLOWED_ORIGINS
+func adminCorsConfig() cors.Config {
+	config := cors.Config{
+		AllowMethods:  []string{"GET", "POST", "PUT", "DELETE"},
+		AllowHeaders:  []string{"Origin", "Content-Type"},
+		ExposeHeaders: []string{"Content-Length"},
+		MaxAge:        time.Hour,
+	}
+
+	allowedOrigins := map[string]bool{}
+	for _, origin := range strings.Split(os.Getenv("ADMIN_ALLOWED_ORIGINS"), ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			allowedOrigins[origin] = true
+		}
+	}
+	config.AllowOriginFunc = func(origin string) bool {
+		return allowedOrigins[origin]
+	}
+
+	return config
+}
+
+type cachedCorsHandler struct {
+	handler gin.HandlerFunc
+	expires time.Time
+}
+
+var (
+	corsHandlersMutex sync.Mutex
+	corsHandlers      = map[string]cachedCorsHandler{}
+)
+
+// tenantCorsHandler returns the cors middleware of the tenant serving the
+// request, handlers are cached for a minute to avoid a lookup per request
+func tenantCorsHandler(c *gin.Context, client *mongo.Client) (gin.HandlerFunc, error) {
+	hostname := location.Get(c).Hostname()
+
+	corsHandlersMutex.Lock()
+	cached, ok := corsHandlers[hostname]
+	corsHandlersMutex.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.handler, nil
+	}
+
+	config, err := GetServerConfig(c, client)
+	if err != nil {
+		return nil, err
+	}
+	corsConfig, err := config.Cors.corsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	handler := cors.New(corsConfig)
+	corsHandlersMutex.Lock()
+	corsHandlers[hostname] = cachedCorsHandler{handler: handler, expires: time.Now().Add(time.Minute)}
+	corsHandlersMutex.Unlock()
+
+	return handler, nil
+}
+
+// TenantCors applies the CORS policy of the tenant serving the request, the
+// admin domain has its own strict policy
+func TenantCors(client *mongo.Client) gin.HandlerFunc {
+	adminDomain := os.Getenv("ADMIN_DOMAIN")
+	adminCors := cors.New(adminCorsConfig())
+
+	return func(c *gin.Context) {
+		// requests without an origin are not CORS requests
+		if c.Request.Header.Get("Origin") == "" {
+			return
+		}
+
+		if location.Get(c).Hostname() == adminDomain {
+			adminCors(c)
+			return
+		}
+
+		handler, err := tenantCorsHandler(c, client)
+		if err != nil {
+			// the route handler reports the missing or broken configuration
+			return
+		}
+		handler(c)
+	}
+}
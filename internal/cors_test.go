@@ -0,0 +1,77 @@
+package internal
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+)
+
+func TestTenantCorsConfig(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	settings := map[string]CorsSettings{
+		"Default settings":  {},
+		"Wildcard origin":   {AllowOrigins: []string{"*"}, AllowCredentials: true},
+		"Listed origin":     {AllowOrigins: []string{"https://app.example.com"}},
+		"Subdomain pattern": {AllowOrigins: []string{"https://*.example.com"}},
+	}
+
+	for name, setting := range settings {
+		t.Run(name, func(t *testing.T) {
+			config, err := setting.corsConfig()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r := gin.New()
+			r.Use(cors.New(config))
+			r.GET("/user", func(c *gin.Context) {
+				c.String(200, "")
+			})
+
+			request := httptest.NewRequest("GET", "/user", nil)
+			request.Header.Set("Origin", "https://app.example.com")
+			recorder := httptest.NewRecorder()
+			r.ServeHTTP(recorder, request)
+
+			if recorder.Code != http.StatusOK {
+				t.Errorf("Expected status 200, got %d", recorder.Code)
+			}
+			if recorder.Header().Get("Access-Control-Allow-Origin") == "" {
+				t.Error("The origin should be allowed")
+			}
+		})
+	}
+
+	t.Run("Origin not listed", func(t *testing.T) {
+		config, err := CorsSettings{AllowOrigins: []string{"https://app.example.com"}}.corsConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r := gin.New()
+		r.Use(cors.New(config))
+		r.GET("/user", func(c *gin.Context) {
+			c.String(200, "")
+		})
+
+		request := httptest.NewRequest("GET", "/user", nil)
+		request.Header.Set("Origin", "https://evil.example.org")
+		recorder := httptest.NewRecorder()
+		r.ServeHTTP(recorder, request)
+
+		if recorder.Code != http.StatusForbidden {
+			t.Errorf("Expected status 403, got %d", recorder.Code)
+		}
+	})
+
+	t.Run("Invalid origin", func(t *testing.T) {
+		_, err := CorsSettings{AllowOrigins: []string{"https://*.*.example.com"}}.corsConfig()
+		if err == nil {
+			t.Error("Origins with more than one * should be rejected")
+		}
+	})
+}
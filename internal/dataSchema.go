@@ -0,0 +1,197 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/ZaninAndrea/shipyard-backend/pkg/validator"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// dataValidationProblem is the type of the problem responses returned when
+// the user data doesn't match the schema of the tenant
+const dataValidationProblem = "/problems/data-validation"
+
+// dataSchemaProblem is the type of the problem responses returned when the
+// stored schema of the tenant cannot be loaded anymore, e.g. because it uses
+// a custom format that isn't registered
+const dataSchemaProblem = "/problems/data-schema"
+
+// ProblemDetails is an RFC 7807 problem response
+type ProblemDetails struct {
+	Type     string                      `json:"type"`
+	Title    string                      `json:"title"`
+	Status   int                         `json:"status"`
+	Detail   string                      `json:"detail,omitempty"`
+	Instance string                      `json:"instance,omitempty"`
+	Errors   []validator.ValidationError `json:"errors,omitempty"`
+}
+
+func respondWithProblem(c *gin.Context, problem ProblemDetails) {
+	c.Header("Content-Type", "application/problem+json")
+	c.JSON(problem.Status, problem)
+}
+
+// respondWithValidationError describes the error returned by the validator of
+// the user data
+func respondWithValidationError(c *gin.Context, err error) {
+	errors := validator.ValidationErrors(err)
+	if errors == nil {
+		respondWithProblem(c, ProblemDetails{
+			Type:     "about:blank",
+			Title:    "Bad Request",
+			Status:   400,
+			Detail:   err.Error(),
+			Instance: c.Request.URL.Path,
+		})
+		return
+	}
+
+	respondWithProblem(c, ProblemDetails{
+		Type:     dataValidationProblem,
+		Title:    "The data doesn't match the schema",
+		Status:   422,
+		Detail:   fmt.Sprintf("The data has %d validation errors", len(errors)),
+		Instance: c.Request.URL.Path,
+		Errors:   errors,
+	})
+}
+
+// validateDataSchema checks that the data schema of a tenant can be loaded
+func validateDataSchema(schema json.RawMessage) error {
+	if len(schema) == 0 || string(schema) == "null" {
+		return nil
+	}
+
+	var v validator.Validator
+	err := json.Unmarshal(schema, &v)
+	if err != nil {
+		return fmt.Errorf("The data schema is invalid: %s", err.Error())
+	}
+
+	return nil
+}
+
+// respondWithSchemaError describes the error returned when loading the data
+// schema of the tenant
+func respondWithSchemaError(c *gin.Context, err error) {
+	respondWithProblem(c, ProblemDetails{
+		Type:     dataSchemaProblem,
+		Title:    "The data schema of the server cannot be loaded",
+		Status:   500,
+		Detail:   err.Error(),
+		Instance: c.Request.URL.Path,
+	})
+}
+
+type cachedDataValidator struct {
+	schema    json.RawMessage
+	validator *validator.Validator
+}
+
+// dataValidatorCache stores the loaded validator of each tenant together
+// with the schema it was loaded from, so that a changed schema is reloaded
+type dataValidatorCache struct {
+	mutex      sync.Mutex
+	validators map[string]cachedDataValidator
+}
+
+var dataValidators = dataValidatorCache{validators: make(map[string]cachedDataValidator)}
+
+// Load returns the validator of the schema for the passed key, the schema is
+// loaded only if it differs from the cached one
+func (dc *dataValidatorCache) Load(key string, schema json.RawMessage) (*validator.Validator, error) {
+	dc.mutex.Lock()
+	cached, ok := dc.validators[key]
+	dc.mutex.Unlock()
+	if ok && bytes.Equal(cached.schema, schema) {
+		return cached.validator, nil
+	}
+
+	var v validator.Validator
+	err := json.Unmarshal(schema, &v)
+	if err != nil {
+		return nil, err
+	}
+
+	dc.mutex.Lock()
+	dc.validators[key] = cachedDataValidator{schema: schema, validator: &v}
+	dc.mutex.Unlock()
+
+	return &v, nil
+}
+
+// dataValidator loads the validator of the user data, it returns nil if the
+// tenant doesn't define a data schema
+func (config *DatabaseConfig) dataValidator() (*validator.Validator, error) {
+	if len(config.DataSchema) == 0 || string(config.DataSchema) == "null" {
+		return nil, nil
+	}
+
+	return dataValidators.Load(config.ID.Hex(), config.DataSchema)
+}
+
+// normalizeUserData applies the defaults of the data schema to the passed
+// data and validates it, if the data is invalid a problem response is sent
+// and false is returned
+func (config *DatabaseConfig) normalizeUserData(c *gin.Context, jsonData []byte) ([]byte, bool) {
+	dataValidator, err := config.dataValidator()
+	if err != nil {
+		respondWithSchemaError(c, err)
+		return nil, false
+	} else if dataValidator == nil {
+		return jsonData, true
+	}
+
+	// a missing body is an empty document, so that the defaults are applied
+	if len(bytes.TrimSpace(jsonData)) == 0 {
+		jsonData = []byte("{}")
+	}
+
+	normalized, err := dataValidator.Normalize(jsonData)
+	if err != nil {
+		respondWithValidationError(c, err)
+		return nil, false
+	}
+
+	return normalized, true
+}
+
+// validateUserDataPatch validates the JSON patch against the data schema
+// simulating it on the current data, if the patch is invalid a problem
+// response is sent and false is returned
+func (config *DatabaseConfig) validateUserDataPatch(c *gin.Context, data bson.M, rawPatch []byte) bool {
+	dataValidator, err := config.dataValidator()
+	if err != nil {
+		respondWithSchemaError(c, err)
+		return false
+	} else if dataValidator == nil {
+		return true
+	}
+
+	if data == nil {
+		data = bson.M{}
+	}
+	document, err := bson.MarshalExtJSON(data, false, true)
+	if err != nil {
+		respondWithProblem(c, ProblemDetails{
+			Type:     "about:blank",
+			Title:    "Internal Server Error",
+			Status:   500,
+			Detail:   "Failed to encode the stored data",
+			Instance: c.Request.URL.Path,
+		})
+		return false
+	}
+
+	err = dataValidator.ValidatePatchesWithDocument(document, rawPatch)
+	if err != nil {
+		respondWithValidationError(c, err)
+		return false
+	}
+
+	return true
+}
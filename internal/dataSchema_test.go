@@ -0,0 +1,70 @@
+package internal
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestDataValidator(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	config := DatabaseConfig{
+		ID:         primitive.NewObjectID(),
+		DataSchema: json.RawMessage(`{"type": "object", "fields": {"theme": {"type": "string", "default": "light"}}}`),
+	}
+
+	t.Run("Cached validator", func(t *testing.T) {
+		first, err := config.dataValidator()
+		if err != nil {
+			t.Fatal(err)
+		}
+		second, err := config.dataValidator()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if first != second {
+			t.Error("The validator should be loaded only once")
+		}
+	})
+
+	t.Run("Changed schema", func(t *testing.T) {
+		first, _ := config.dataValidator()
+		changed := config
+		changed.DataSchema = json.RawMessage(`{"type": "object", "fields": {"theme": {"type": "string"}}}`)
+		second, err := changed.dataValidator()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if first == second {
+			t.Error("A changed schema should be loaded again")
+		}
+	})
+
+	t.Run("Schema that cannot be loaded", func(t *testing.T) {
+		broken := DatabaseConfig{
+			ID:         primitive.NewObjectID(),
+			DataSchema: json.RawMessage(`{"type": "string", "format": "unregistered"}`),
+		}
+
+		recorder := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(recorder)
+		c.Request = httptest.NewRequest("PUT", "/user/data", nil)
+
+		if _, valid := broken.normalizeUserData(c, []byte(`"x"`)); valid {
+			t.Fatal("The data cannot be validated without a schema")
+		}
+		if recorder.Code != http.StatusInternalServerError {
+			t.Errorf("Expected status 500, got %d", recorder.Code)
+		}
+
+		var problem ProblemDetails
+		if err := json.Unmarshal(recorder.Body.Bytes(), &problem); err != nil || problem.Type != dataSchemaProblem {
+			t.Error("Expected a data schema problem, got", recorder.Body.String())
+		}
+	})
+}
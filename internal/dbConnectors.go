@@ -2,25 +2,39 @@ package internal
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"time"
 
+	"github.com/ZaninAndrea/shipyard-backend/pkg/mailer"
 	"github.com/gin-contrib/location"
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
-	gomail "gopkg.in/gomail.v2"
 )
 
 // User is a representation of a document from the users collection in MongoDB
 type User struct {
-	ID       primitive.ObjectID `bson:"_id, omitempty"`
-	Email    string
-	Password string
-	Plan 	 string
-	Data     bson.M
+	ID          primitive.ObjectID `bson:"_id, omitempty"`
+	Email       string
+	Password    string
+	Plan        string
+	Locale      string
+	PlanHistory []PlanChange `bson:"planHistory"`
+	Billing     UserBilling
+	// KnownDevices are the devices the user logged in from
+	KnownDevices []KnownDevice `bson:"knownDevices"`
+	// PendingEmail is set while a change of email waits for confirmation
+	PendingEmail *PendingEmail `bson:"pendingEmail,omitempty"`
+	// Unsubscribed users don't receive the announcements of the tenant
+	Unsubscribed bool
+	// Deliverability records the bounces and complaints of the email
+	Deliverability UserDeliverability
+	// ApiKeys are the API keys created by the user
+	ApiKeys []ApiKey `bson:"apiKeys"`
+	Data    bson.M
 }
 
 func loadUserByEmail(email string, collection *mongo.Collection) User {
@@ -38,6 +52,17 @@ func loadUserByEmail(email string, collection *mongo.Collection) User {
 	return result
 }
 func loadUserByID(id string, collection *mongo.Collection, projection bson.M) User {
+	result, err := findUserByID(id, collection, projection)
+	if err != nil {
+		panic(err)
+	}
+
+	return result
+}
+
+// findUserByID loads the user with the passed id, it returns
+// mongo.ErrNoDocuments if the user doesn't exist
+func findUserByID(id string, collection *mongo.Collection, projection bson.M) (User, error) {
 	objID, _ := primitive.ObjectIDFromHex(id)
 	filter := bson.M{"_id": objID}
 
@@ -47,11 +72,7 @@ func loadUserByID(id string, collection *mongo.Collection, projection bson.M) Us
 
 	err := collection.FindOne(ctx, filter, options.FindOne().SetProjection(projection)).Decode(&result)
 
-	if err != nil {
-		panic(err)
-	}
-
-	return result
+	return result, err
 }
 
 type DatabaseConfig struct {
@@ -68,13 +89,19 @@ type DatabaseConfig struct {
 		Name    string
 		Address string
 	}
-	Smtp struct {
-		Server      string
-		Port        int
-		Username    string
-		Password    string
-		EmailDialer *gomail.Dialer `bson:"-" json:"-"`
-	}
+	Smtp        mailer.SMTPSettings
+	Limits      TenantLimits
+	Plans       map[string]Plan
+	DefaultPlan string
+	Billing     BillingSettings
+	Cors        CorsSettings
+	Email       EmailSettings
+	// FeatureFields maps a feature flag of the plans to the top level fields
+	// of the user data that only the users with the feature can write
+	FeatureFields map[string][]string
+	// DataSchema is the pkg/validator schema of the user data, the data is
+	// not validated if it's empty
+	DataSchema json.RawMessage
 }
 type DatabaseConfigNoID struct {
 	Domain         string
@@ -89,13 +116,19 @@ type DatabaseConfigNoID struct {
 		Name    string
 		Address string
 	}
-	Smtp struct {
-		Server      string
-		Port        int
-		Username    string
-		Password    string
-		EmailDialer *gomail.Dialer `bson:"-" json:"-"`
-	}
+	Smtp        mailer.SMTPSettings
+	Limits      TenantLimits
+	Plans       map[string]Plan
+	DefaultPlan string
+	Billing     BillingSettings
+	Cors        CorsSettings
+	Email       EmailSettings
+	// FeatureFields maps a feature flag of the plans to the top level fields
+	// of the user data that only the users with the feature can write
+	FeatureFields map[string][]string
+	// DataSchema is the pkg/validator schema of the user data, the data is
+	// not validated if it's empty
+	DataSchema json.RawMessage
 }
 type DatabaseConfigNoInternals struct {
 	ID     primitive.ObjectID `bson:"_id, omitempty"`
@@ -110,15 +143,30 @@ type DatabaseConfigNoInternals struct {
 		Name    string
 		Address string
 	}
-	Smtp struct {
-		Server   string
-		Port     int
-		Username string
-		Password string
-	}
+	Smtp        mailer.SMTPSettings
+	Limits      TenantLimits
+	Plans       map[string]Plan
+	DefaultPlan string
+	Billing     BillingSettings
+	Cors        CorsSettings
+	Email       EmailSettings
+	// FeatureFields maps a feature flag of the plans to the top level fields
+	// of the user data that only the users with the feature can write
+	FeatureFields map[string][]string
+	// DataSchema is the pkg/validator schema of the user data, the data is
+	// not validated if it's empty
+	DataSchema json.RawMessage
 }
 
+// serverConfigKey is the key of the server configuration in the gin context,
+// it's set by the middlewares that already loaded it
+const serverConfigKey = "serverConfig"
+
 func GetServerConfig(c *gin.Context, client *mongo.Client) (*DatabaseConfig, error) {
+	if config, ok := c.Get(serverConfigKey); ok {
+		return config.(*DatabaseConfig), nil
+	}
+
 	url := location.Get(c)
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -133,9 +181,26 @@ func GetServerConfig(c *gin.Context, client *mongo.Client) (*DatabaseConfig, err
 	}
 
 	config.UserCollection = client.Database("generic_" + config.ID.Hex()).Collection("users")
-	config.Smtp.EmailDialer = gomail.NewDialer(
-		config.Smtp.Server, config.Smtp.Port, config.Smtp.Username, config.Smtp.Password,
-	)
+
+	return &config, nil
+}
+
+// loadServerConfigByID loads the server configuration with the passed id
+// and initializes its internals
+func loadServerConfigByID(client *mongo.Client, id primitive.ObjectID) (*DatabaseConfig, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var config DatabaseConfig
+	err := client.Database("administration").Collection("servers").FindOne(ctx, bson.M{
+		"_id": id,
+	}).Decode(&config)
+
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't load the server configuration matching the passed id")
+	}
+
+	config.UserCollection = client.Database("generic_" + config.ID.Hex()).Collection("users")
 
 	return &config, nil
 }
@@ -0,0 +1,125 @@
+package internal
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/ZaninAndrea/shipyard-backend/pkg/mailer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	softBounced = "soft-bounced"
+	hardBounced = "hard-bounced"
+	complained  = "complained"
+
+	// softBounceLimit is the number of soft bounces after which an address
+	// is handled like a hard bounce
+	softBounceLimit = 5
+)
+
+// UserDeliverability is the state of the deliveries to the email of a user,
+// an empty status means that no problem was reported
+type UserDeliverability struct {
+	Status      string
+	SoftBounces int `bson:"softBounces"`
+	Diagnostic  string
+	UpdatedAt   time.Time `bson:"updatedAt"`
+}
+
+// errSuppressed is returned when queuing an email to an address that
+// bounced permanently
+var errSuppressed = fmt.Errorf("The recipient address bounced permanently, the email was not sent")
+
+// verifyBounceSignature checks the X-Signature header, the hex HMAC-SHA256
+// of the payload with the bounce webhook secret of the tenant
+func verifyBounceSignature(payload []byte, signature string, secret string) error {
+	expected, err := hex.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("The X-Signature header is not a valid hex string")
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	if !hmac.Equal(mac.Sum(nil), expected) {
+		return fmt.Errorf("The signature of the payload is invalid")
+	}
+
+	return nil
+}
+
+// isSuppressed returns true if emails to the address must not be sent
+func (config *DatabaseConfig) isSuppressed(address string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	count, err := config.UserCollection.CountDocuments(ctx, bson.M{
+		"email":                 address,
+		"deliverability.status": hardBounced,
+	})
+	return count > 0, err
+}
+
+// recordDeliveryNotification updates the deliverability of the user with
+// the email of the notification. Complaints also unsubscribe the user from
+// the announcements
+func (config *DatabaseConfig) recordDeliveryNotification(notification mailer.Notification) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	filter := bson.M{"email": notification.Recipient}
+	var update bson.M
+	switch notification.Kind {
+	case mailer.HardBounce:
+		update = bson.M{"$set": bson.M{
+			"deliverability.status":     hardBounced,
+			"deliverability.diagnostic": notification.Diagnostic,
+			"deliverability.updatedAt":  now,
+		}}
+	case mailer.Complaint:
+		update = bson.M{"$set": bson.M{
+			"deliverability.status":     complained,
+			"deliverability.diagnostic": notification.Diagnostic,
+			"deliverability.updatedAt":  now,
+			"unsubscribed":              true,
+		}}
+		// a complaint doesn't make a permanent bounce deliverable again
+		filter["deliverability.status"] = bson.M{"$ne": hardBounced}
+	case mailer.SoftBounce:
+		var user User
+		filter["deliverability.status"] = bson.M{"$nin": bson.A{hardBounced, complained}}
+		err := config.UserCollection.FindOneAndUpdate(
+			ctx,
+			filter,
+			bson.M{
+				"$set": bson.M{
+					"deliverability.status":     softBounced,
+					"deliverability.diagnostic": notification.Diagnostic,
+					"deliverability.updatedAt":  now,
+				},
+				"$inc": bson.M{"deliverability.softBounces": 1},
+			},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&user)
+		if err == mongo.ErrNoDocuments || (err == nil && user.Deliverability.SoftBounces < softBounceLimit) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		filter = bson.M{"_id": user.ID}
+		update = bson.M{"$set": bson.M{"deliverability.status": hardBounced}}
+	default:
+		return fmt.Errorf("The notification kind %s is not supported", notification.Kind)
+	}
+
+	_, err := config.UserCollection.UpdateMany(ctx, filter, update)
+	return err
+}
@@ -1,12 +1,7 @@
 package internal
 
 import (
-	"bytes"
-	"strconv"
 	"text/template"
-	"time"
-
-	gomail "gopkg.in/gomail.v2"
 )
 
 const rawEmailTemplate string = `<!DOCTYPE html PUBLIC "-//W3C//DTD XHTML 1.0 Transitional //EN" "http://www.w3.org/TR/xhtml1/DTD/xhtml1-transitional.dtd">
@@ -521,6 +516,10 @@ type BrandedEmailData struct {
 
 var _emailTemplate *template.Template
 
+// BrandedEmailTemplate returns the layout wrapping the emails. It is a
+// text/template because html/template would strip the conditional comments
+// needed by Outlook, so the fields of BrandedEmailData must be escaped by
+// the caller
 func BrandedEmailTemplate() *template.Template {
 	if _emailTemplate != nil {
 		return _emailTemplate
@@ -537,32 +536,6 @@ func BrandedEmailTemplate() *template.Template {
 	return emailTemplate
 }
 
-func (config *DatabaseConfig) sendPasswordChangedEmail(recipient string) {
-	emailTemplate := BrandedEmailTemplate()
-	bodyBuffer := new(bytes.Buffer)
-
-	emailTemplate.Execute(bodyBuffer, BrandedEmailData{
-		Company:     config.Company.Name,
-		Address:     config.Company.Address,
-		LogoLink:    config.App.LogoLink,
-		Domain:      config.App.Link,
-		HeaderColor: config.App.HeaderColor,
-		Year:        strconv.Itoa(time.Now().Year()),
-		HtmlContent: "You just changed the password of your " + config.App.Name +
-			" account. If this was a mistake contact us to avoid losing access to your account.<br/><br/>Cheers,<br/>The " +
-			config.App.Name + " team",
-	})
-
-	m := gomail.NewMessage()
-	m.SetHeader("From", "binder@baida.dev")
-	m.SetHeader("To", recipient)
-	m.SetHeader("Subject", "Password successfully changed")
-	m.SetBody("text/html", bodyBuffer.String())
-	m.AddAlternative("text/plain", "You just changed the password of your "+config.App.Name+
-		" account. If this was a mistake contact us to avoid losing access to your account.\n\nCheers,\nThe "+
-		config.App.Name+" team")
-
-	if err := config.Smtp.EmailDialer.DialAndSend(m); err != nil {
-		panic(err)
-	}
+func (config *DatabaseConfig) sendPasswordChangedEmail(recipient string, locale string) {
+	config.sendEmail("passwordChanged", recipient, locale, nil)
 }
@@ -0,0 +1,139 @@
+package internal
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// emailChangeExpiration is how long the confirmation link of an email
+// change is valid
+const emailChangeExpiration = 24 * time.Hour
+
+// PendingEmail is an email change waiting for the confirmation of the new
+// address, only the hash of the confirmation token is stored
+type PendingEmail struct {
+	Email     string
+	TokenHash string    `bson:"tokenHash"`
+	ExpiresAt time.Time `bson:"expiresAt"`
+}
+
+// indexedTenants contains the ids of the tenants whose user indexes were created
+var indexedTenants sync.Map
+
+// ensureUserIndexes creates the unique index on the emails of the users of
+// the tenant and the index on their API keys once per process. Tenants that
+// already contain duplicate emails fail to create it and rely on the checks
+// done by the routes
+func (config *DatabaseConfig) ensureUserIndexes() {
+	if _, done := indexedTenants.LoadOrStore(config.ID, true); done {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := config.UserCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.M{"email": 1},
+		Options: options.Index().SetUnique(true),
+	})
+	if err != nil {
+		fmt.Println(err)
+	}
+	_, err = config.UserCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.M{"apiKeys.keyHash": 1},
+	})
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+// hashToken returns the hash stored in place of a random token
+func hashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+// requestEmailChange stores the new email of the user as pending and returns
+// the token confirming it
+func (config *DatabaseConfig) requestEmailChange(userID primitive.ObjectID, newEmail string) (string, error) {
+	tokenBytes := make([]byte, 32)
+	_, err := rand.Read(tokenBytes)
+	if err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(tokenBytes)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = config.UserCollection.UpdateOne(ctx, bson.M{"_id": userID}, bson.M{
+		"$set": bson.M{"pendingEmail": PendingEmail{
+			Email:     newEmail,
+			TokenHash: hashToken(token),
+			ExpiresAt: time.Now().Add(emailChangeExpiration),
+		}},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// errEmailTaken is returned when confirming the change to an email used by
+// another user
+var errEmailTaken = fmt.Errorf("Another user with this email already exists")
+
+// confirmEmailChange replaces the email of the user that requested the change
+// with the passed token, the swap is a single update so the unique index on
+// the emails rejects concurrent changes to the same address. The bounces of
+// the previous address are forgotten. It returns the user as it was before
+// the change
+func (config *DatabaseConfig) confirmEmailChange(token string) (*User, error) {
+	config.ensureUserIndexes()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var user User
+	filter := bson.M{
+		"pendingEmail.tokenHash": hashToken(token),
+		"pendingEmail.expiresAt": bson.M{"$gt": time.Now()},
+	}
+	err := config.UserCollection.FindOne(ctx, filter).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return nil, fmt.Errorf("The confirmation link is invalid or expired")
+	} else if err != nil {
+		return nil, err
+	}
+
+	count, err := config.UserCollection.CountDocuments(ctx, bson.M{"email": user.PendingEmail.Email})
+	if err != nil {
+		return nil, err
+	} else if count > 0 {
+		return nil, errEmailTaken
+	}
+
+	filter["_id"] = user.ID
+	res, err := config.UserCollection.UpdateOne(ctx, filter, bson.M{
+		"$set":   bson.M{"email": user.PendingEmail.Email},
+		"$unset": bson.M{"pendingEmail": "", "deliverability": ""},
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, errEmailTaken
+	} else if err != nil {
+		return nil, err
+	} else if res.ModifiedCount == 0 {
+		return nil, fmt.Errorf("The confirmation link is invalid or expired")
+	}
+
+	return &user, nil
+}
@@ -0,0 +1,66 @@
+package internal
+
+import (
+	"time"
+
+	"github.com/ZaninAndrea/shipyard-backend/pkg/mailer"
+)
+
+// smtpCheckTimeout bounds each network operation of the SMTP check
+const smtpCheckTimeout = 10 * time.Second
+
+// EmailTestResult is the outcome of sending a test email, SmtpCheck is nil
+// when the tenant doesn't use the smtp transport
+type EmailTestResult struct {
+	SmtpCheck *mailer.SMTPDiagnostic
+	Sent      bool
+	Error     string
+}
+
+// usesSMTP returns true if the emails of the tenant are delivered via SMTP
+func usesSMTP(settings EmailSettings) bool {
+	return settings.Transport == "" || settings.Transport == "smtp"
+}
+
+// checkSMTP runs the SMTP connectivity check if the tenant uses the smtp
+// transport and has a server configured
+func checkSMTP(settings EmailSettings, smtp mailer.SMTPSettings) *mailer.SMTPDiagnostic {
+	if !usesSMTP(settings) || smtp.Server == "" {
+		return nil
+	}
+
+	diagnostic := mailer.CheckSMTP(smtp, smtpCheckTimeout)
+	return &diagnostic
+}
+
+// sendTestEmail checks the SMTP settings of the tenant and sends the test
+// email to the recipient right away, bypassing the outbox so that the
+// result can be reported
+func (config *DatabaseConfig) sendTestEmail(recipient string) EmailTestResult {
+	result := EmailTestResult{SmtpCheck: checkSMTP(config.Email, config.Smtp)}
+	if result.SmtpCheck != nil && !result.SmtpCheck.Ok {
+		result.Error = "The SMTP check failed, the test email was not sent"
+		return result
+	}
+
+	message, err := config.renderEmail("testEmail", recipient, config.Email.DefaultLocale, nil)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	sender, err := config.emailSender()
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	err = sender.Send(message)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	config.recordUsage("emailsSent", 1)
+	result.Sent = true
+
+	return result
+}
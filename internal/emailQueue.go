@@ -0,0 +1,324 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ZaninAndrea/shipyard-backend/pkg/mailer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	emailPending = "pending"
+	emailSending = "sending"
+	emailSent    = "sent"
+	emailDead    = "dead"
+
+	// maxEmailAttempts is the number of failed deliveries after which an
+	// email is dead-lettered
+	maxEmailAttempts = 8
+	// defaultEmailConcurrency is the number of emails of a tenant that can be
+	// sent at the same time if the tenant doesn't configure it
+	defaultEmailConcurrency = 2
+)
+
+// OutboxEmail is a document of the email outbox collection
+type OutboxEmail struct {
+	ID       primitive.ObjectID `bson:"_id,omitempty"`
+	ConfigID primitive.ObjectID `bson:"configId"`
+	// AnnouncementID is set on the emails of an announcement
+	AnnouncementID primitive.ObjectID `bson:"announcementId,omitempty"`
+	Message        mailer.Message
+	Status         string
+	Attempts       int
+	LastError      string    `bson:"lastError"`
+	NextAttemptAt  time.Time `bson:"nextAttemptAt"`
+	LockedUntil    time.Time `bson:"lockedUntil"`
+	CreatedAt      time.Time `bson:"createdAt"`
+	SentAt         time.Time `bson:"sentAt,omitempty"`
+}
+
+func emailOutbox(client *mongo.Client) *mongo.Collection {
+	return client.Database("administration").Collection("emailOutbox")
+}
+
+// enqueueEmail stores the message in the outbox, it will be delivered by the
+// email workers
+func (config *DatabaseConfig) enqueueEmail(message mailer.Message) error {
+	return config.queueOutboxEmail(OutboxEmail{Message: message})
+}
+
+// queueOutboxEmail stores the email in the outbox of the tenant as pending,
+// emails to addresses that bounced permanently are suppressed
+func (config *DatabaseConfig) queueOutboxEmail(email OutboxEmail) error {
+	suppressed, err := config.isSuppressed(email.Message.To)
+	if err != nil {
+		return err
+	} else if suppressed {
+		return errSuppressed
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	email.ConfigID = config.ID
+	email.Status = emailPending
+	email.NextAttemptAt = now
+	email.CreatedAt = now
+	_, err = emailOutbox(config.UserCollection.Database().Client()).InsertOne(ctx, email)
+	return err
+}
+
+// tenantSlots tracks the emails being sent for each tenant to enforce the
+// per-tenant concurrency limits
+type tenantSlots struct {
+	mutex    sync.Mutex
+	inFlight map[primitive.ObjectID]int
+	limits   map[primitive.ObjectID]int
+}
+
+func (s *tenantSlots) busyTenants() []primitive.ObjectID {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	busy := []primitive.ObjectID{}
+	for tenant, count := range s.inFlight {
+		if count >= s.limits[tenant] {
+			busy = append(busy, tenant)
+		}
+	}
+
+	return busy
+}
+
+func (s *tenantSlots) acquire(tenant primitive.ObjectID, limit int) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.limits[tenant] = limit
+	if s.inFlight[tenant] >= limit {
+		return false
+	}
+	s.inFlight[tenant]++
+
+	return true
+}
+
+func (s *tenantSlots) release(tenant primitive.ObjectID) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.inFlight[tenant]--
+	if s.inFlight[tenant] <= 0 {
+		delete(s.inFlight, tenant)
+	}
+}
+
+// StartEmailWorkers starts a pool of workers delivering the emails in the
+// outbox, failed deliveries are retried with exponential backoff
+func StartEmailWorkers(client *mongo.Client, workers int) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := emailOutbox(client).Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "status", Value: 1}, {Key: "nextAttemptAt", Value: 1}},
+	})
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	slots := &tenantSlots{
+		inFlight: make(map[primitive.ObjectID]int),
+		limits:   make(map[primitive.ObjectID]int),
+	}
+	for i := 0; i < workers; i++ {
+		go func() {
+			for {
+				if !deliverNextEmail(client, slots) {
+					time.Sleep(2 * time.Second)
+				}
+			}
+		}()
+	}
+}
+
+// claimNextEmail locks the next email that is due, emails locked by a worker
+// that crashed are claimed again once the lock expires. An expired lock
+// counts as a failed attempt, so that an email that keeps crashing or hanging
+// the workers is dead-lettered
+func claimNextEmail(client *mongo.Client, busyTenants []primitive.ObjectID) (*OutboxEmail, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	for {
+		now := time.Now()
+		expired := bson.M{"$eq": bson.A{"$status", emailSending}}
+		var email OutboxEmail
+		err := emailOutbox(client).FindOneAndUpdate(
+			ctx,
+			bson.M{
+				"configId": bson.M{"$nin": busyTenants},
+				"$or": bson.A{
+					bson.M{"status": emailPending, "nextAttemptAt": bson.M{"$lte": now}},
+					bson.M{"status": emailSending, "lockedUntil": bson.M{"$lte": now}},
+				},
+			},
+			bson.A{bson.M{"$set": bson.M{
+				"status":      emailSending,
+				"lockedUntil": now.Add(5 * time.Minute),
+				"attempts":    bson.M{"$cond": bson.A{expired, bson.M{"$add": bson.A{"$attempts", 1}}, "$attempts"}},
+				"lastError":   bson.M{"$cond": bson.A{expired, "The delivery didn't complete before the lock expired", "$lastError"}},
+			}}},
+			options.FindOneAndUpdate().
+				SetSort(bson.M{"nextAttemptAt": 1}).
+				SetReturnDocument(options.After),
+		).Decode(&email)
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		if email.Attempts < maxEmailAttempts {
+			return &email, nil
+		}
+
+		_, err = emailOutbox(client).UpdateOne(ctx, bson.M{"_id": email.ID}, bson.M{
+			"$set": bson.M{"status": emailDead},
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// deliverNextEmail sends the next email of the outbox and returns false if
+// there was nothing to send
+func deliverNextEmail(client *mongo.Client, slots *tenantSlots) bool {
+	email, err := claimNextEmail(client, slots.busyTenants())
+	if err != nil {
+		fmt.Println(err)
+		return false
+	} else if email == nil {
+		return false
+	}
+
+	config, err := loadServerConfigByID(client, email.ConfigID)
+	if err != nil {
+		// the tenant was deleted, nobody can fix the delivery
+		updateEmailStatus(client, email, mailer.PermanentError{Err: err})
+		return true
+	}
+
+	limit := config.Limits.MaxConcurrentEmails
+	if limit <= 0 {
+		limit = defaultEmailConcurrency
+	}
+	if !slots.acquire(email.ConfigID, limit) {
+		// another worker filled the slots of the tenant in the meantime
+		releaseEmail(client, email)
+		return true
+	}
+	defer slots.release(email.ConfigID)
+
+	sender, err := config.emailSender()
+	if err != nil {
+		updateEmailStatus(client, email, mailer.PermanentError{Err: err})
+		return true
+	}
+
+	err = sender.Send(email.Message)
+	updateEmailStatus(client, email, err)
+	if err == nil {
+		config.recordUsage("emailsSent", 1)
+	}
+
+	return true
+}
+
+// releaseEmail puts a claimed email back in the queue without counting an attempt
+func releaseEmail(client *mongo.Client, email *OutboxEmail) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := emailOutbox(client).UpdateOne(ctx, bson.M{"_id": email.ID}, bson.M{
+		"$set": bson.M{"status": emailPending},
+	})
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+// updateEmailStatus records the result of a delivery, failed deliveries are
+// scheduled again or dead-lettered if the error is permanent or the email
+// exhausted its attempts
+func updateEmailStatus(client *mongo.Client, email *OutboxEmail, deliveryErr error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var update bson.M
+	if deliveryErr == nil {
+		update = bson.M{"$set": bson.M{"status": emailSent, "sentAt": time.Now(), "lastError": ""}}
+	} else {
+		attempts := email.Attempts + 1
+		status := emailPending
+		if mailer.IsPermanent(deliveryErr) || attempts >= maxEmailAttempts {
+			status = emailDead
+		}
+
+		update = bson.M{"$set": bson.M{
+			"status":        status,
+			"attempts":      attempts,
+			"lastError":     deliveryErr.Error(),
+			"nextAttemptAt": time.Now().Add(mailer.Backoff(attempts)),
+		}}
+	}
+
+	_, err := emailOutbox(client).UpdateOne(ctx, bson.M{"_id": email.ID}, update)
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+// loadDeadEmails returns the emails of the tenant that could not be delivered
+func loadDeadEmails(client *mongo.Client, configID primitive.ObjectID, limit int64, offset int64) ([]OutboxEmail, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cursor, err := emailOutbox(client).Find(
+		ctx,
+		bson.M{"configId": configID, "status": emailDead},
+		options.Find().SetSort(bson.M{"createdAt": -1}).SetLimit(limit).SetSkip(offset),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	emails := make([]OutboxEmail, 0)
+	err = cursor.All(ctx, &emails)
+	return emails, err
+}
+
+// retryDeadEmail puts a dead-lettered email back in the queue
+func retryDeadEmail(client *mongo.Client, configID primitive.ObjectID, emailID primitive.ObjectID) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := emailOutbox(client).UpdateOne(
+		ctx,
+		bson.M{"_id": emailID, "configId": configID, "status": emailDead},
+		bson.M{"$set": bson.M{"status": emailPending, "attempts": 0, "nextAttemptAt": time.Now()}},
+	)
+	if err != nil {
+		return err
+	} else if res.MatchedCount == 0 {
+		return fmt.Errorf("No failed email exists with the passed id")
+	}
+
+	return nil
+}
@@ -0,0 +1,67 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+
+	"github.com/ZaninAndrea/shipyard-backend/pkg/mailer"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func SetupEmailRoute(r *gin.Engine, client *mongo.Client) {
+	// the bounce and complaint notifications are either forwarded from a
+	// mailbox as raw reports or posted as JSON by the email provider
+	r.POST("/email/notifications", func(c *gin.Context) {
+		config, err := GetServerConfig(c, client)
+		if err != nil {
+			c.JSON(400, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		if config.Email.NotificationsWebhookSecret == "" {
+			c.JSON(400, gin.H{"error": "Bounce notifications are not configured for this server"})
+			return
+		}
+
+		payload, err := ioutil.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Failed to read body"})
+			return
+		}
+		err = verifyBounceSignature(payload, c.GetHeader("X-Signature"), config.Email.NotificationsWebhookSecret)
+		if err != nil {
+			c.JSON(401, gin.H{"error": err.Error()})
+			return
+		}
+
+		var notifications []mailer.Notification
+		mediaType, _, _ := mime.ParseMediaType(c.ContentType())
+		switch mediaType {
+		case "message/rfc822":
+			notifications, err = mailer.ParseReport(bytes.NewReader(payload))
+		case "application/json":
+			err = json.Unmarshal(payload, &notifications)
+		default:
+			err = fmt.Errorf("The content type must be either message/rfc822 or application/json")
+		}
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+
+		for _, notification := range notifications {
+			err = config.recordDeliveryNotification(notification)
+			if err != nil {
+				c.JSON(422, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		c.JSON(200, gin.H{"recorded": len(notifications)})
+	})
+}
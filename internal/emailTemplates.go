@@ -0,0 +1,472 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"io/ioutil"
+	"net/mail"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/ZaninAndrea/shipyard-backend/pkg/htmlmail"
+	"github.com/ZaninAndrea/shipyard-backend/pkg/mailer"
+)
+
+// EmailTemplate is a transactional email whose fields are Go templates
+// executed with EmailTemplateData, empty fields fall back to the built-in
+// template of the same email. Html is an html/template, so the variables are
+// escaped, and the plain text is generated from it when Text is empty
+type EmailTemplate struct {
+	Subject string
+	Html    string
+	Text    string
+	// Layout is "branded" (the default) to wrap Html in the branded email
+	// template, or "none" to send Html as is
+	Layout string
+	// Locales contains the translations of the template keyed by language
+	// tag, e.g. "it" or "it-CH"
+	Locales map[string]EmailTemplate `json:",omitempty" bson:",omitempty"`
+}
+
+// EmailSettings configures the emails sent to the users of a tenant
+type EmailSettings struct {
+	From          string
+	ReplyTo       string
+	DefaultLocale string
+	Templates     map[string]EmailTemplate
+	// Transport is how the emails are delivered: smtp (the default), http or file
+	Transport     string
+	Http          HttpTransportSettings
+	Notifications NotificationSettings
+	// AnnouncementsPerMinute throttles the emails of the announcements
+	AnnouncementsPerMinute int
+	// NotificationsWebhookSecret signs the bounce and complaint notifications
+	NotificationsWebhookSecret string
+}
+
+// EmailTemplateData is the data available to the email templates
+type EmailTemplateData struct {
+	AppName        string
+	AppLink        string
+	LogoLink       string
+	CompanyName    string
+	CompanyAddress string
+	Year           string
+	// Email is the address of the recipient
+	Email string
+	// Vars are the variables specific to each email
+	Vars map[string]string
+}
+
+// builtinEmailTemplates are used when a tenant doesn't customize an email
+var builtinEmailTemplates = map[string]EmailTemplate{
+	"passwordChanged": {
+		Subject: "Password successfully changed",
+		Html: "You just changed the password of your {{.AppName}} account. If this was a mistake contact us " +
+			"to avoid losing access to your account.<br/><br/>Cheers,<br/>The {{.AppName}} team",
+		Locales: map[string]EmailTemplate{
+			"it": {
+				Subject: "Password modificata con successo",
+				Html: "Hai appena modificato la password del tuo account {{.AppName}}. Se non sei stato tu contattaci " +
+					"per evitare di perdere l'accesso al tuo account.<br/><br/>A presto,<br/>Il team di {{.AppName}}",
+			},
+		},
+	},
+	"newLogin": {
+		Subject: "New login to your {{.AppName}} account",
+		Html: "Your {{.AppName}} account was accessed from a new device.<br/><br/>Device: {{.Vars.Device}}<br/>" +
+			"IP address: {{.Vars.IP}}<br/>Time: {{.Vars.Time}}<br/><br/>If this was you, you can ignore this email. " +
+			"Otherwise change your password right away.<br/><br/>Cheers,<br/>The {{.AppName}} team",
+		Locales: map[string]EmailTemplate{
+			"it": {
+				Subject: "Nuovo accesso al tuo account {{.AppName}}",
+				Html: "È stato effettuato l'accesso al tuo account {{.AppName}} da un nuovo dispositivo.<br/><br/>" +
+					"Dispositivo: {{.Vars.Device}}<br/>Indirizzo IP: {{.Vars.IP}}<br/>Ora: {{.Vars.Time}}<br/><br/>" +
+					"Se sei stato tu puoi ignorare questa email, altrimenti modifica subito la password." +
+					"<br/><br/>A presto,<br/>Il team di {{.AppName}}",
+			},
+		},
+	},
+	"emailChanged": {
+		Subject: "The email of your {{.AppName}} account was changed",
+		Html: "The email of your {{.AppName}} account was changed to {{.Vars.NewEmail}}, this address will not " +
+			"receive our emails anymore.<br/><br/>Device: {{.Vars.Device}}<br/>IP address: {{.Vars.IP}}<br/>" +
+			"Time: {{.Vars.Time}}<br/><br/>If this was a mistake contact us to avoid losing access to your account." +
+			"<br/><br/>Cheers,<br/>The {{.AppName}} team",
+		Locales: map[string]EmailTemplate{
+			"it": {
+				Subject: "L'email del tuo account {{.AppName}} è stata modificata",
+				Html: "L'email del tuo account {{.AppName}} è stata modificata in {{.Vars.NewEmail}}, questo " +
+					"indirizzo non riceverà più le nostre email.<br/><br/>Dispositivo: {{.Vars.Device}}<br/>" +
+					"Indirizzo IP: {{.Vars.IP}}<br/>Ora: {{.Vars.Time}}<br/><br/>Se non sei stato tu contattaci " +
+					"per evitare di perdere l'accesso al tuo account.<br/><br/>A presto,<br/>Il team di {{.AppName}}",
+			},
+		},
+	},
+	"confirmEmailChange": {
+		Subject: "Confirm your new {{.AppName}} email",
+		Html: "Someone asked to use this address for their {{.AppName}} account. Open the link below to confirm " +
+			"the change, it expires in 24 hours.<br/><br/><a href=\"{{.Vars.Link}}\">{{.Vars.Link}}</a><br/><br/>" +
+			"If you didn't ask for it, you can ignore this email.<br/><br/>Cheers,<br/>The {{.AppName}} team",
+		Locales: map[string]EmailTemplate{
+			"it": {
+				Subject: "Conferma la tua nuova email di {{.AppName}}",
+				Html: "È stato chiesto di usare questo indirizzo per un account {{.AppName}}. Apri il link qui sotto " +
+					"per confermare la modifica, scade tra 24 ore.<br/><br/><a href=\"{{.Vars.Link}}\">{{.Vars.Link}}</a>" +
+					"<br/><br/>Se non l'hai chiesto tu puoi ignorare questa email.<br/><br/>A presto,<br/>Il team di {{.AppName}}",
+			},
+		},
+	},
+	"emailChangeRequested": {
+		Subject: "Your {{.AppName}} email is about to change",
+		Html: "You asked to change the email of your {{.AppName}} account to {{.Vars.NewEmail}}, the change " +
+			"will be applied once the new address is confirmed.<br/><br/>Device: {{.Vars.Device}}<br/>" +
+			"IP address: {{.Vars.IP}}<br/>Time: {{.Vars.Time}}<br/><br/>If this was not you, change your password " +
+			"right away.<br/><br/>Cheers,<br/>The {{.AppName}} team",
+		Locales: map[string]EmailTemplate{
+			"it": {
+				Subject: "L'email del tuo account {{.AppName}} sta per cambiare",
+				Html: "Hai chiesto di modificare l'email del tuo account {{.AppName}} in {{.Vars.NewEmail}}, la " +
+					"modifica sarà applicata quando il nuovo indirizzo sarà confermato.<br/><br/>" +
+					"Dispositivo: {{.Vars.Device}}<br/>Indirizzo IP: {{.Vars.IP}}<br/>Ora: {{.Vars.Time}}<br/><br/>" +
+					"Se non sei stato tu modifica subito la password.<br/><br/>A presto,<br/>Il team di {{.AppName}}",
+			},
+		},
+	},
+	"apiKeyCreated": {
+		Subject: "New API key for your {{.AppName}} account",
+		Html: "The API key {{.Vars.KeyName}} was created for your {{.AppName}} account, it can access your data." +
+			"<br/><br/>Device: {{.Vars.Device}}<br/>IP address: {{.Vars.IP}}<br/>Time: {{.Vars.Time}}<br/><br/>" +
+			"If this was not you, delete the key and change your password right away.<br/><br/>" +
+			"Cheers,<br/>The {{.AppName}} team",
+		Locales: map[string]EmailTemplate{
+			"it": {
+				Subject: "Nuova chiave API per il tuo account {{.AppName}}",
+				Html: "È stata creata la chiave API {{.Vars.KeyName}} per il tuo account {{.AppName}}, può accedere " +
+					"ai tuoi dati.<br/><br/>Dispositivo: {{.Vars.Device}}<br/>Indirizzo IP: {{.Vars.IP}}<br/>" +
+					"Ora: {{.Vars.Time}}<br/><br/>Se non sei stato tu elimina la chiave e modifica subito la password." +
+					"<br/><br/>A presto,<br/>Il team di {{.AppName}}",
+			},
+		},
+	},
+	"accountDeleted": {
+		Subject: "Your {{.AppName}} account was deleted",
+		Html: "Your {{.AppName}} account and all its data were deleted.<br/><br/>Device: {{.Vars.Device}}<br/>" +
+			"IP address: {{.Vars.IP}}<br/>Time: {{.Vars.Time}}<br/><br/>We are sorry to see you go.<br/><br/>" +
+			"Cheers,<br/>The {{.AppName}} team",
+		Locales: map[string]EmailTemplate{
+			"it": {
+				Subject: "Il tuo account {{.AppName}} è stato eliminato",
+				Html: "Il tuo account {{.AppName}} e tutti i suoi dati sono stati eliminati.<br/><br/>" +
+					"Dispositivo: {{.Vars.Device}}<br/>Indirizzo IP: {{.Vars.IP}}<br/>Ora: {{.Vars.Time}}<br/><br/>" +
+					"Ci dispiace vederti andare via.<br/><br/>A presto,<br/>Il team di {{.AppName}}",
+			},
+		},
+	},
+	"testEmail": {
+		Subject: "Test email from {{.AppName}}",
+		Html: "This is a test email sent from the administration panel to check the email settings of " +
+			"{{.AppName}}. If you are reading it, the emails are delivered correctly.",
+	},
+}
+
+// sampleEmailVars are the variables used to preview each email
+var sampleEmailVars = map[string]map[string]string{
+	"passwordChanged":      {},
+	"testEmail":            {},
+	"newLogin":             {"Device": "Mozilla/5.0 (Windows NT 10.0; Win64; x64) Firefox/91.0", "IP": "203.0.113.7", "Time": "1 March 2021 09:30 UTC"},
+	"emailChanged":         {"NewEmail": "jane.new@example.com", "Device": "Mozilla/5.0 (Windows NT 10.0; Win64; x64) Firefox/91.0", "IP": "203.0.113.7", "Time": "1 March 2021 09:30 UTC"},
+	"confirmEmailChange":   {"Link": "https://example.com/user/email/confirm?token=0123456789abcdef"},
+	"emailChangeRequested": {"NewEmail": "jane.new@example.com", "Device": "Mozilla/5.0 (Windows NT 10.0; Win64; x64) Firefox/91.0", "IP": "203.0.113.7", "Time": "1 March 2021 09:30 UTC"},
+	"apiKeyCreated":        {"KeyName": "Backup script", "Device": "Mozilla/5.0 (Windows NT 10.0; Win64; x64) Firefox/91.0", "IP": "203.0.113.7", "Time": "1 March 2021 09:30 UTC"},
+	"accountDeleted":       {"Device": "Mozilla/5.0 (Windows NT 10.0; Win64; x64) Firefox/91.0", "IP": "203.0.113.7", "Time": "1 March 2021 09:30 UTC"},
+}
+
+// emailTemplateData returns the data used to render an email for the recipient
+func (config *DatabaseConfig) emailTemplateData(recipient string, vars map[string]string) EmailTemplateData {
+	if vars == nil {
+		vars = map[string]string{}
+	}
+
+	return EmailTemplateData{
+		AppName:        config.App.Name,
+		AppLink:        config.App.Link,
+		LogoLink:       config.App.LogoLink,
+		CompanyName:    config.Company.Name,
+		CompanyAddress: config.Company.Address,
+		Year:           strconv.Itoa(time.Now().Year()),
+		Email:          recipient,
+		Vars:           vars,
+	}
+}
+
+// variant returns the translation of the template for the lowercase locale,
+// the empty locale is the template itself
+func (t EmailTemplate) variant(locale string) (EmailTemplate, bool) {
+	if locale == "" {
+		return t, t.Subject != "" || t.Html != "" || t.Text != ""
+	}
+
+	for key, translation := range t.Locales {
+		if strings.ToLower(key) == locale {
+			return translation, true
+		}
+	}
+
+	return EmailTemplate{}, false
+}
+
+// mergeEmailTemplates overrides the fields of base with the non empty ones of custom
+func mergeEmailTemplates(base EmailTemplate, custom EmailTemplate) EmailTemplate {
+	merged := EmailTemplate{Subject: base.Subject, Html: base.Html, Text: base.Text, Layout: base.Layout}
+	if custom.Subject != "" {
+		merged.Subject = custom.Subject
+	}
+	if custom.Html != "" {
+		merged.Html = custom.Html
+		merged.Layout = custom.Layout
+		// a text written for another html would drift from it
+		merged.Text = ""
+	}
+	if custom.Text != "" {
+		merged.Text = custom.Text
+	}
+
+	return merged
+}
+
+// emailTemplate returns the template of the tenant for the email in the
+// passed locale, merged with the built-in one. Locales fall back to their
+// parent language (it-CH to it), then to the default locale of the tenant and
+// finally to the untranslated template
+func (config *DatabaseConfig) emailTemplate(name string, locale string) (EmailTemplate, error) {
+	builtin, ok := builtinEmailTemplates[name]
+	if !ok {
+		return EmailTemplate{}, fmt.Errorf("The email %s does not exist", name)
+	}
+	custom := config.Email.Templates[name]
+
+	candidates := append(localeFallbackChain(locale), localeFallbackChain(config.Email.DefaultLocale)...)
+	candidates = append(candidates, "")
+	for _, candidate := range candidates {
+		customVariant, hasCustom := custom.variant(candidate)
+		builtinVariant, hasBuiltin := builtin.variant(candidate)
+		if !hasCustom && !hasBuiltin {
+			continue
+		}
+		if !hasBuiltin {
+			builtinVariant = builtin
+		}
+
+		return mergeEmailTemplates(builtinVariant, customVariant), nil
+	}
+
+	return mergeEmailTemplates(builtin, EmailTemplate{}), nil
+}
+
+func executeTemplate(name string, source string, data interface{}) (string, error) {
+	parsedTemplate, err := template.New(name).Option("missingkey=zero").Parse(source)
+	if err != nil {
+		return "", err
+	}
+
+	buffer := new(bytes.Buffer)
+	err = parsedTemplate.Execute(buffer, data)
+	if err != nil {
+		return "", err
+	}
+
+	return buffer.String(), nil
+}
+
+// executeHTMLTemplate executes the source as an html/template, which escapes
+// the variables depending on where they appear in the HTML
+func executeHTMLTemplate(name string, source string, data interface{}) (string, error) {
+	parsedTemplate, err := htmltemplate.New(name).Option("missingkey=zero").Parse(source)
+	if err != nil {
+		return "", err
+	}
+
+	buffer := new(bytes.Buffer)
+	err = parsedTemplate.Execute(buffer, data)
+	if err != nil {
+		return "", err
+	}
+
+	return buffer.String(), nil
+}
+
+// renderEmailTemplate executes the template, wraps the HTML in the branded
+// email template unless the layout is "none" and inlines its CSS. The plain
+// text is generated from the HTML content if the template has no text
+func (config *DatabaseConfig) renderEmailTemplate(emailTemplate EmailTemplate, data EmailTemplateData) (mailer.Message, error) {
+	subject, err := executeTemplate("subject", emailTemplate.Subject, data)
+	if err != nil {
+		return mailer.Message{}, fmt.Errorf("The subject template is invalid: %s", err.Error())
+	}
+	htmlContent, err := executeHTMLTemplate("html", emailTemplate.Html, data)
+	if err != nil {
+		return mailer.Message{}, fmt.Errorf("The html template is invalid: %s", err.Error())
+	}
+
+	var text string
+	if emailTemplate.Text != "" {
+		text, err = executeTemplate("text", emailTemplate.Text, data)
+		if err != nil {
+			return mailer.Message{}, fmt.Errorf("The text template is invalid: %s", err.Error())
+		}
+	} else {
+		text, err = htmlmail.HTMLToText(htmlContent)
+		if err != nil {
+			return mailer.Message{}, err
+		}
+	}
+
+	html := htmlContent
+	if emailTemplate.Layout != "none" {
+		bodyBuffer := new(bytes.Buffer)
+		err = BrandedEmailTemplate().Execute(bodyBuffer, BrandedEmailData{
+			Company:     template.HTMLEscapeString(config.Company.Name),
+			Address:     template.HTMLEscapeString(config.Company.Address),
+			LogoLink:    template.HTMLEscapeString(config.App.LogoLink),
+			Domain:      template.HTMLEscapeString(config.App.Link),
+			HeaderColor: template.HTMLEscapeString(config.App.HeaderColor),
+			Year:        data.Year,
+			HtmlContent: htmlContent,
+		})
+		if err != nil {
+			return mailer.Message{}, err
+		}
+		html = bodyBuffer.String()
+	}
+	html, err = htmlmail.InlineCSS(html)
+	if err != nil {
+		return mailer.Message{}, err
+	}
+
+	return mailer.Message{
+		From:    config.emailFrom(),
+		ReplyTo: config.Email.ReplyTo,
+		To:      data.Email,
+		Subject: subject,
+		HTML:    html,
+		Text:    text,
+	}, nil
+}
+
+// emailFrom returns the sender of the emails of the tenant
+func (config *DatabaseConfig) emailFrom() string {
+	if config.Email.From != "" {
+		return config.Email.From
+	}
+
+	sender := mail.Address{Name: config.App.Name, Address: "noreply@" + config.Domain}
+	return sender.String()
+}
+
+// renderEmail renders the named email for the recipient in its locale
+func (config *DatabaseConfig) renderEmail(name string, recipient string, locale string, vars map[string]string) (mailer.Message, error) {
+	emailTemplate, err := config.emailTemplate(name, locale)
+	if err != nil {
+		return mailer.Message{}, err
+	}
+
+	return config.renderEmailTemplate(emailTemplate, config.emailTemplateData(recipient, vars))
+}
+
+// sendEmail renders the named email and adds it to the outbox, failures are
+// logged because emails are sent after the response
+func (config *DatabaseConfig) sendEmail(name string, recipient string, locale string, vars map[string]string) {
+	message, err := config.renderEmail(name, recipient, locale, vars)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	err = config.enqueueEmail(message)
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+// validateEmailSettings checks that the templates of the tenant refer to
+// existing emails and can be parsed
+func validateEmailSettings(settings EmailSettings) error {
+	err := validateEmailTransport(settings)
+	if err != nil {
+		return err
+	}
+	if settings.DefaultLocale != "" && !isValidLocale(settings.DefaultLocale) {
+		return fmt.Errorf("The default locale %s is not a valid language tag", settings.DefaultLocale)
+	}
+
+	for name, emailTemplate := range settings.Templates {
+		if _, ok := builtinEmailTemplates[name]; !ok {
+			return fmt.Errorf("The email %s does not exist", name)
+		}
+
+		err := validateEmailTemplate(name, emailTemplate)
+		if err != nil {
+			return err
+		}
+		for locale, translation := range emailTemplate.Locales {
+			if !isValidLocale(locale) {
+				return fmt.Errorf("The locale %s of the email %s is not a valid language tag", locale, name)
+			}
+			if len(translation.Locales) > 0 {
+				return fmt.Errorf("The translations of the email %s cannot have nested locales", name)
+			}
+
+			err = validateEmailTemplate(name+" ("+locale+")", translation)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// validateEmailTemplate parses the templates and executes them with sample
+// data, because html/template reports the ambiguous uses of the variables
+// only when executing
+func validateEmailTemplate(name string, emailTemplate EmailTemplate) error {
+	if emailTemplate.Layout != "" && emailTemplate.Layout != "branded" && emailTemplate.Layout != "none" {
+		return fmt.Errorf("The layout of the email %s must be either branded or none", name)
+	}
+
+	data := EmailTemplateData{
+		AppName: "App",
+		AppLink: "https://example.com",
+		Year:    strconv.Itoa(time.Now().Year()),
+		Email:   "jane.doe@example.com",
+		Vars:    map[string]string{},
+	}
+	for _, field := range []string{"subject", "text"} {
+		source := emailTemplate.Subject
+		if field == "text" {
+			source = emailTemplate.Text
+		}
+
+		parsedTemplate, err := template.New(field).Option("missingkey=zero").Parse(source)
+		if err == nil {
+			err = parsedTemplate.Execute(ioutil.Discard, data)
+		}
+		if err != nil {
+			return fmt.Errorf("The %s template of the email %s is invalid: %s", field, name, err.Error())
+		}
+	}
+
+	parsedTemplate, err := htmltemplate.New("html").Option("missingkey=zero").Parse(emailTemplate.Html)
+	if err == nil {
+		err = parsedTemplate.Execute(ioutil.Discard, data)
+	}
+	if err != nil {
+		return fmt.Errorf("The html template of the email %s is invalid: %s", name, err.Error())
+	}
+
+	return nil
+}
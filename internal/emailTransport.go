@@ -0,0 +1,50 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/ZaninAndrea/shipyard-backend/pkg/mailer"
+)
+
+// HttpTransportSettings configures the delivery of emails through the HTTP
+// API of an email provider
+type HttpTransportSettings struct {
+	Url     string
+	Headers map[string]string
+}
+
+// emailSender returns the sender delivering the emails of the tenant, the
+// file transport writes to a directory of the tenant in EMAIL_FILES_DIRECTORY
+func (config *DatabaseConfig) emailSender() (mailer.Sender, error) {
+	switch config.Email.Transport {
+	case "", "smtp":
+		return &mailer.SMTPSender{Settings: config.Smtp}, nil
+	case "http":
+		return &mailer.HTTPSender{URL: config.Email.Http.Url, Headers: config.Email.Http.Headers}, nil
+	case "file":
+		root := os.Getenv("EMAIL_FILES_DIRECTORY")
+		if root == "" {
+			return nil, fmt.Errorf("The file email transport requires the EMAIL_FILES_DIRECTORY variable")
+		}
+
+		return &mailer.FileSender{Directory: filepath.Join(root, config.ID.Hex())}, nil
+	}
+
+	return nil, fmt.Errorf("The email transport %s is not supported", config.Email.Transport)
+}
+
+func validateEmailTransport(settings EmailSettings) error {
+	switch settings.Transport {
+	case "", "smtp", "file":
+		return nil
+	case "http":
+		if settings.Http.Url == "" {
+			return fmt.Errorf("The http email transport requires an url")
+		}
+		return nil
+	}
+
+	return fmt.Errorf("The email transport must be one of smtp, http or file")
+}
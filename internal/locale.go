@@ -0,0 +1,68 @@
+package internal
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var localeRegex = regexp.MustCompile(`^[A-Za-z]{2,3}(-[A-Za-z0-9]{2,8})*$`)
+
+// isValidLocale checks that the locale is a well formed language tag, e.g. it-CH
+func isValidLocale(locale string) bool {
+	return localeRegex.MatchString(locale)
+}
+
+// parseAcceptLanguage returns the language tag with the highest quality in
+// an Accept-Language header, or an empty string if there is none
+func parseAcceptLanguage(header string) string {
+	type weightedLocale struct {
+		locale  string
+		quality float64
+	}
+
+	locales := []weightedLocale{}
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		locale := strings.TrimSpace(fields[0])
+		if !isValidLocale(locale) {
+			continue
+		}
+
+		quality := 1.0
+		for _, parameter := range fields[1:] {
+			parameter = strings.TrimSpace(parameter)
+			if strings.HasPrefix(parameter, "q=") {
+				value, err := strconv.ParseFloat(parameter[2:], 64)
+				if err == nil {
+					quality = value
+				}
+			}
+		}
+		if quality > 0 {
+			locales = append(locales, weightedLocale{locale, quality})
+		}
+	}
+	if len(locales) == 0 {
+		return ""
+	}
+
+	sort.SliceStable(locales, func(i, j int) bool { return locales[i].quality > locales[j].quality })
+	return locales[0].locale
+}
+
+// localeFallbackChain returns the locales to try in order for the passed
+// locale, e.g. it-CH gives it-ch and it
+func localeFallbackChain(locale string) []string {
+	chain := []string{}
+	parts := strings.Split(strings.ToLower(locale), "-")
+	for i := len(parts); i > 0; i-- {
+		if parts[0] == "" {
+			break
+		}
+		chain = append(chain, strings.Join(parts[:i], "-"))
+	}
+
+	return chain
+}
@@ -0,0 +1,303 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const dayFormat = "2006-01-02"
+
+// usageRecorder accumulates the usage counters in memory, they are written
+// to the database periodically by StartUsageMetering
+type usageRecorder struct {
+	mutex sync.Mutex
+	// counters maps a tenant id to the increments of each counter
+	counters map[string]map[string]int64
+	// activeUsers maps a tenant id to the users that sent a request today
+	activeUsers map[string]map[string]bool
+	// snapshots maps a tenant id to the time of the last data size snapshot
+	snapshots map[string]time.Time
+}
+
+var tenantUsage = usageRecorder{
+	counters:    make(map[string]map[string]int64),
+	activeUsers: make(map[string]map[string]bool),
+	snapshots:   make(map[string]time.Time),
+}
+
+// UsageBucket contains the usage of a tenant in a day, week or month
+type UsageBucket struct {
+	Bucket        string
+	Signups       int64
+	Logins        int64
+	EmailsSent    int64
+	ApiCalls      map[string]int64
+	ApiCallsTotal int64
+	ActiveUsers   int64
+	DataBytes     int64
+}
+
+// recordUsage increments a usage counter of the tenant
+func (config *DatabaseConfig) recordUsage(counter string, increment int64) {
+	tenantUsage.mutex.Lock()
+	defer tenantUsage.mutex.Unlock()
+
+	tenantID := config.ID.Hex()
+	if _, ok := tenantUsage.counters[tenantID]; !ok {
+		tenantUsage.counters[tenantID] = make(map[string]int64)
+	}
+	tenantUsage.counters[tenantID][counter] += increment
+}
+
+// recordActivity marks the user as active in the current day
+func (config *DatabaseConfig) recordActivity(userID string) {
+	tenantUsage.mutex.Lock()
+	defer tenantUsage.mutex.Unlock()
+
+	tenantID := config.ID.Hex()
+	if _, ok := tenantUsage.activeUsers[tenantID]; !ok {
+		tenantUsage.activeUsers[tenantID] = make(map[string]bool)
+	}
+	tenantUsage.activeUsers[tenantID][userID] = true
+}
+
+// registerRequest meters an API call to the tenant and checks it against the
+// configured request rate
+func (config *DatabaseConfig) registerRequest(c *gin.Context) bool {
+	config.recordUsage("apiCalls."+c.Request.Method+" "+c.FullPath(), 1)
+
+	return tenantRequests.Allow(config.ID.Hex(), config.Limits.MaxRequestsPerMinute)
+}
+
+// limitTenantRequests is a middleware that registers the request to the
+// tenant and rejects it once the tenant exceeds its request rate, the loaded
+// configuration is reused by GetServerConfig in the handler
+func limitTenantRequests(client *mongo.Client) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		config, err := GetServerConfig(c, client)
+		if err != nil {
+			// the handler reports the missing configuration
+			return
+		}
+		c.Set(serverConfigKey, config)
+
+		if !config.registerRequest(c) {
+			c.AbortWithStatusJSON(429, gin.H{"error": "Too many requests, try again later"})
+		}
+	}
+}
+
+// StartUsageMetering periodically writes the usage counters to the database
+func StartUsageMetering(client *mongo.Client, interval time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			flushUsage(client)
+		}
+	}()
+}
+
+func flushUsage(client *mongo.Client) {
+	tenantUsage.mutex.Lock()
+	counters := tenantUsage.counters
+	activeUsers := tenantUsage.activeUsers
+	tenantUsage.counters = make(map[string]map[string]int64)
+	tenantUsage.activeUsers = make(map[string]map[string]bool)
+	tenantUsage.mutex.Unlock()
+
+	day := time.Now().UTC().Truncate(24 * time.Hour)
+	usageCollection := client.Database("administration").Collection("usage")
+
+	for tenantID, increments := range counters {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		_, err := usageCollection.UpdateOne(
+			ctx,
+			bson.M{"_id": tenantID + "/" + day.Format(dayFormat)},
+			bson.M{
+				"$setOnInsert": bson.M{"configId": tenantID, "day": day},
+				"$inc":         increments,
+			},
+			options.Update().SetUpsert(true),
+		)
+		cancel()
+		if err != nil {
+			fmt.Println(err)
+		}
+
+		snapshotDataSize(client, tenantID, day)
+	}
+
+	for tenantID, users := range activeUsers {
+		activityCollection := client.Database("generic_" + tenantID).Collection("activity")
+		for userID := range users {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			_, err := activityCollection.UpdateOne(
+				ctx,
+				bson.M{"_id": userID + "/" + day.Format(dayFormat)},
+				bson.M{"$setOnInsert": bson.M{"userId": userID, "day": day}},
+				options.Update().SetUpsert(true),
+			)
+			cancel()
+			if err != nil {
+				fmt.Println(err)
+			}
+		}
+	}
+}
+
+// snapshotDataSize stores the size of the data of the tenant in the usage
+// of the day, at most once per hour
+func snapshotDataSize(client *mongo.Client, tenantID string, day time.Time) {
+	tenantUsage.mutex.Lock()
+	lastSnapshot := tenantUsage.snapshots[tenantID]
+	if time.Since(lastSnapshot) < time.Hour {
+		tenantUsage.mutex.Unlock()
+		return
+	}
+	tenantUsage.snapshots[tenantID] = time.Now()
+	tenantUsage.mutex.Unlock()
+
+	id, err := primitive.ObjectIDFromHex(tenantID)
+	if err != nil {
+		return
+	}
+	config, err := loadServerConfigByID(client, id)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	usage, err := loadTenantUsage(config)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = client.Database("administration").Collection("usage").UpdateOne(
+		ctx,
+		bson.M{"_id": tenantID + "/" + day.Format(dayFormat)},
+		bson.M{"$set": bson.M{"dataBytes": usage.DataBytes}},
+	)
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+// bucketFormats maps the supported periods to the $dateToString format that
+// identifies a bucket
+var bucketFormats = map[string]string{
+	"daily":   "%Y-%m-%d",
+	"weekly":  "%G-W%V",
+	"monthly": "%Y-%m",
+}
+
+// loadUsageSeries returns the usage of the tenant between from and to,
+// grouped in buckets of the passed period
+func loadUsageSeries(client *mongo.Client, config *DatabaseConfig, period string, from time.Time, to time.Time) ([]UsageBucket, error) {
+	format, ok := bucketFormats[period]
+	if !ok {
+		return nil, fmt.Errorf("The period must be one of daily, weekly or monthly")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cursor, err := client.Database("administration").Collection("usage").Find(
+		ctx,
+		bson.M{"configId": config.ID.Hex(), "day": bson.M{"$gte": from, "$lte": to}},
+		options.Find().SetSort(bson.M{"day": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	var days []struct {
+		Day        time.Time
+		Signups    int64
+		Logins     int64
+		EmailsSent int64            `bson:"emailsSent"`
+		ApiCalls   map[string]int64 `bson:"apiCalls"`
+		DataBytes  int64            `bson:"dataBytes"`
+	}
+	err = cursor.All(ctx, &days)
+	if err != nil {
+		return nil, err
+	}
+
+	// the distinct active users of a bucket are counted by the database
+	cursor, err = config.UserCollection.Database().Collection("activity").Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"day": bson.M{"$gte": from, "$lte": to}}}},
+		{{Key: "$group", Value: bson.M{"_id": bson.M{
+			"bucket": bson.M{"$dateToString": bson.M{"format": format, "date": "$day"}},
+			"userId": "$userId",
+		}}}},
+		{{Key: "$group", Value: bson.M{"_id": "$_id.bucket", "activeUsers": bson.M{"$sum": 1}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var activity []struct {
+		Bucket      string `bson:"_id"`
+		ActiveUsers int64  `bson:"activeUsers"`
+	}
+	err = cursor.All(ctx, &activity)
+	if err != nil {
+		return nil, err
+	}
+
+	buckets := map[string]*UsageBucket{}
+	getBucket := func(key string) *UsageBucket {
+		if _, ok := buckets[key]; !ok {
+			buckets[key] = &UsageBucket{Bucket: key, ApiCalls: map[string]int64{}}
+		}
+		return buckets[key]
+	}
+
+	for _, day := range days {
+		bucket := getBucket(bucketKey(period, day.Day))
+		bucket.Signups += day.Signups
+		bucket.Logins += day.Logins
+		bucket.EmailsSent += day.EmailsSent
+		for route, calls := range day.ApiCalls {
+			bucket.ApiCalls[route] += calls
+			bucket.ApiCallsTotal += calls
+		}
+		// days are sorted, so the bucket keeps the latest snapshot
+		if day.DataBytes > 0 {
+			bucket.DataBytes = day.DataBytes
+		}
+	}
+	for _, entry := range activity {
+		getBucket(entry.Bucket).ActiveUsers = entry.ActiveUsers
+	}
+
+	series := make([]UsageBucket, 0, len(buckets))
+	for _, bucket := range buckets {
+		series = append(series, *bucket)
+	}
+	sort.Slice(series, func(i, j int) bool { return series[i].Bucket < series[j].Bucket })
+
+	return series, nil
+}
+
+// bucketKey formats the day like the $dateToString formats in bucketFormats
+func bucketKey(period string, day time.Time) string {
+	day = day.UTC()
+	switch period {
+	case "weekly":
+		year, week := day.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	case "monthly":
+		return day.Format("2006-01")
+	default:
+		return day.Format(dayFormat)
+	}
+}
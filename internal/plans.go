@@ -0,0 +1,220 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Plan describes the limits and the features granted to the users subscribed
+// to it, a zero limit falls back to the limits of the tenant
+type Plan struct {
+	MaxDataBytes         int
+	MaxRequestsPerMinute int
+	MaxStorageBytes      int
+	MaxApiKeys           int
+	// Features enables the fields of the user data listed for the feature in
+	// the FeatureFields of the server configuration
+	Features map[string]bool
+}
+
+// PlanChange is an entry of the plan history of a user
+type PlanChange struct {
+	Plan         string
+	PreviousPlan string `bson:"previousPlan"`
+	Reason       string
+	ChangedAt    time.Time `bson:"changedAt"`
+}
+
+// defaultPlan returns the plan assigned to new users
+func (config *DatabaseConfig) defaultPlan() string {
+	if config.DefaultPlan != "" {
+		return config.DefaultPlan
+	}
+
+	return "BASIC"
+}
+
+// planLimits returns the limits that apply to a user subscribed to the
+// passed plan, plan limits take precedence over the tenant ones
+func (config *DatabaseConfig) planLimits(planName string) TenantLimits {
+	limits := config.Limits
+	plan, ok := config.Plans[planName]
+	if !ok {
+		return limits
+	}
+
+	if plan.MaxDataBytes > 0 {
+		limits.MaxDataBytes = plan.MaxDataBytes
+	}
+	if plan.MaxRequestsPerMinute > 0 {
+		limits.MaxRequestsPerMinute = plan.MaxRequestsPerMinute
+	}
+	if plan.MaxStorageBytes > 0 {
+		limits.MaxStorageBytes = plan.MaxStorageBytes
+	}
+	if plan.MaxApiKeys > 0 {
+		limits.MaxApiKeys = plan.MaxApiKeys
+	}
+
+	return limits
+}
+
+// planFeatures returns the feature flags enabled by the passed plan
+func (config *DatabaseConfig) planFeatures(planName string) map[string]bool {
+	features := map[string]bool{}
+	for feature, enabled := range config.Plans[planName].Features {
+		features[feature] = enabled
+	}
+
+	return features
+}
+
+// lockedDataField returns the first of the passed fields of the user data
+// that belongs to a feature not enabled by the plan, along with the feature
+func (config *DatabaseConfig) lockedDataField(planName string, fields []string) (string, string, bool) {
+	features := make([]string, 0, len(config.FeatureFields))
+	for feature := range config.FeatureFields {
+		features = append(features, feature)
+	}
+	sort.Strings(features)
+
+	enabled := config.planFeatures(planName)
+	lockedFields := map[string]string{}
+	for _, feature := range features {
+		if enabled[feature] {
+			continue
+		}
+		for _, field := range config.FeatureFields[feature] {
+			if _, ok := lockedFields[field]; !ok {
+				lockedFields[field] = feature
+			}
+		}
+	}
+
+	for _, field := range fields {
+		if feature, ok := lockedFields[field]; ok {
+			return field, feature, true
+		}
+	}
+
+	return "", "", false
+}
+
+// checkDataFeatures replies with 403 and returns false if the request writes
+// a field of the user data that requires a feature missing from the plan
+func (config *DatabaseConfig) checkDataFeatures(c *gin.Context, planName string, fields []string) bool {
+	field, feature, locked := config.lockedDataField(planName, fields)
+	if locked {
+		c.JSON(403, gin.H{"error": "The field " + field + " requires the feature " + feature + ", which is not included in your plan"})
+		return false
+	}
+
+	return true
+}
+
+// dataFields returns the top level fields of a JSON object, it returns nil
+// if the value is not an object
+func dataFields(jsonData []byte) []string {
+	var object map[string]json.RawMessage
+	if json.Unmarshal(jsonData, &object) != nil {
+		return nil
+	}
+
+	fields := make([]string, 0, len(object))
+	for field := range object {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	return fields
+}
+
+// patchedDataFields returns the top level fields of the user data written
+// by the JSON patch
+func patchedDataFields(rawPatch []byte) []string {
+	var patches []struct {
+		Path  string          `json:"path"`
+		Value json.RawMessage `json:"value"`
+	}
+	if json.Unmarshal(rawPatch, &patches) != nil {
+		return nil
+	}
+
+	fields := []string{}
+	for _, patch := range patches {
+		if patch.Path == "" {
+			fields = append(fields, dataFields(patch.Value)...)
+			continue
+		}
+
+		token := strings.SplitN(strings.TrimPrefix(patch.Path, "/"), "/", 2)[0]
+		fields = append(fields, strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~"))
+	}
+
+	return fields
+}
+
+// allowUserRequest registers a request of the user and checks it against
+// the request rate of its plan
+func (config *DatabaseConfig) allowUserRequest(userID string, planName string) bool {
+	plan, ok := config.Plans[planName]
+	if !ok || plan.MaxRequestsPerMinute <= 0 {
+		return true
+	}
+
+	return tenantRequests.Allow(config.ID.Hex()+"/"+userID, plan.MaxRequestsPerMinute)
+}
+
+// changeUserPlan subscribes the user to the passed plan and records the
+// change in the plan history of the user
+func (config *DatabaseConfig) changeUserPlan(userID primitive.ObjectID, planName string, reason string) error {
+	if _, ok := config.Plans[planName]; !ok && planName != config.defaultPlan() {
+		return fmt.Errorf("The plan %s is not defined in the server configuration", planName)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var user User
+	err := config.UserCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
+	if err == mongo.ErrNoDocuments {
+		return fmt.Errorf("No user exists with the passed id")
+	} else if err != nil {
+		return err
+	}
+	if user.Plan == planName {
+		return nil
+	}
+
+	// the filter on the previous plan makes concurrent changes fail instead
+	// of recording a wrong history
+	res, err := config.UserCollection.UpdateOne(
+		ctx,
+		bson.M{"_id": userID, "plan": user.Plan},
+		bson.M{
+			"$set": bson.M{"plan": planName},
+			"$push": bson.M{"planHistory": PlanChange{
+				Plan:         planName,
+				PreviousPlan: user.Plan,
+				Reason:       reason,
+				ChangedAt:    time.Now(),
+			}},
+		},
+	)
+	if err != nil {
+		return err
+	} else if res.ModifiedCount == 0 {
+		return fmt.Errorf("The plan of the user was changed concurrently, try again")
+	}
+
+	return nil
+}
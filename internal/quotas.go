@@ -0,0 +1,317 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// TenantLimits bounds the resources a single tenant can use on the shared
+// database, a zero value means that the resource is not limited
+type TenantLimits struct {
+	MaxUsers             int64
+	MaxDataBytes         int
+	MaxRequestsPerMinute int
+	MaxConcurrentEmails  int
+	// MaxStorageBytes bounds the whole record of a user: its data, API keys,
+	// known devices and plan history
+	MaxStorageBytes int
+	MaxApiKeys      int
+}
+
+// TenantUsage is the current resource usage of a tenant, the requests are
+// counted only if the tenant has a request rate limit
+type TenantUsage struct {
+	Users                int64
+	MaxUsers             int64
+	DataBytes            int64
+	LargestDataBytes     int64
+	MaxDataBytes         int
+	RequestsLastMinute   int
+	MaxRequestsPerMinute int
+}
+
+type requestWindow struct {
+	start time.Time
+	count int
+}
+
+// requestCounter counts the requests received in fixed one minute windows,
+// the expired windows are dropped so that the keys of inactive users don't
+// accumulate
+type requestCounter struct {
+	mutex     sync.Mutex
+	windows   map[string]*requestWindow
+	lastSweep time.Time
+}
+
+var tenantRequests = requestCounter{windows: make(map[string]*requestWindow)}
+
+// Allow registers a request for the passed key and returns false if the key
+// already reached the limit in the current window, a limit of 0 disables
+// the check and the request isn't counted
+func (rc *requestCounter) Allow(key string, limit int) bool {
+	if limit <= 0 {
+		return true
+	}
+
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+
+	now := time.Now()
+	if now.Sub(rc.lastSweep) >= time.Minute {
+		rc.sweep(now)
+	}
+
+	window, ok := rc.windows[key]
+	if !ok || now.Sub(window.start) >= time.Minute {
+		window = &requestWindow{start: now}
+		rc.windows[key] = window
+	}
+
+	if window.count >= limit {
+		return false
+	}
+
+	window.count++
+	return true
+}
+
+// Count returns the number of requests registered for the key in the current window
+func (rc *requestCounter) Count(key string) int {
+	rc.mutex.Lock()
+	defer rc.mutex.Unlock()
+
+	window, ok := rc.windows[key]
+	if !ok {
+		return 0
+	} else if time.Since(window.start) >= time.Minute {
+		delete(rc.windows, key)
+		return 0
+	}
+
+	return window.count
+}
+
+// sweep drops the windows that expired, the caller must hold the mutex
+func (rc *requestCounter) sweep(now time.Time) {
+	for key, window := range rc.windows {
+		if now.Sub(window.start) >= time.Minute {
+			delete(rc.windows, key)
+		}
+	}
+	rc.lastSweep = now
+}
+
+// reserveUser atomically counts a new user of the tenant, it returns false if
+// the tenant already has the maximum number of users. The counter is kept up
+// to date also without a limit, so that setting one later holds
+func (config *DatabaseConfig) reserveUser() (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	counters := config.UserCollection.Database().Collection("counters")
+	filter := bson.M{"_id": "users"}
+	if config.Limits.MaxUsers > 0 {
+		filter["count"] = bson.M{"$lt": config.Limits.MaxUsers}
+	}
+	update := bson.M{"$inc": bson.M{"count": 1}}
+
+	res, err := counters.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	} else if res.MatchedCount > 0 {
+		return true, nil
+	}
+
+	// the counter is created by the first signup, starting from the users
+	// that already exist
+	count, err := config.UserCollection.CountDocuments(ctx, bson.M{})
+	if err != nil {
+		return false, err
+	}
+	_, err = counters.InsertOne(ctx, bson.M{"_id": "users", "count": count})
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		return false, err
+	}
+
+	res, err = counters.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return false, err
+	}
+
+	return res.MatchedCount > 0, nil
+}
+
+// releaseUser removes a user from the counter of the users of the tenant,
+// either because it was deleted or because its creation failed
+func (config *DatabaseConfig) releaseUser() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := config.UserCollection.Database().Collection("counters").UpdateOne(
+		ctx,
+		bson.M{"_id": "users", "count": bson.M{"$gt": 0}},
+		bson.M{"$inc": bson.M{"count": -1}},
+	)
+	return err
+}
+
+// exceedsDataLimit checks whether the passed user data is bigger than the
+// maximum size allowed by the tenant for the plan of the user
+func (config *DatabaseConfig) exceedsDataLimit(planName string, data interface{}) (bool, error) {
+	maxDataBytes := config.planLimits(planName).MaxDataBytes
+	if maxDataBytes <= 0 {
+		return false, nil
+	}
+
+	size, err := dataSize(data)
+	if err != nil {
+		return false, err
+	}
+
+	return size > maxDataBytes, nil
+}
+
+// exceedsStorageLimit checks whether the passed record of a user is bigger
+// than the storage allowed by the tenant for the plan of the user
+func (config *DatabaseConfig) exceedsStorageLimit(planName string, record bson.M) (bool, error) {
+	maxStorageBytes := config.planLimits(planName).MaxStorageBytes
+	if maxStorageBytes <= 0 {
+		return false, nil
+	}
+
+	bytes, err := bson.Marshal(record)
+	if err != nil {
+		return false, err
+	}
+
+	return len(bytes) > maxStorageBytes, nil
+}
+
+// loadUserRecord loads the whole stored record of the user, it is used to
+// compute the storage of the user after an update
+func (config *DatabaseConfig) loadUserRecord(userID string) (bson.M, error) {
+	objID, _ := primitive.ObjectIDFromHex(userID)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var record bson.M
+	err := config.UserCollection.FindOne(ctx, bson.M{"_id": objID}).Decode(&record)
+	if err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
+
+// exceedsStorageLimitWithData checks whether the record of the user exceeds
+// the storage allowed by its plan once its data is replaced by the passed one
+func (config *DatabaseConfig) exceedsStorageLimitWithData(planName string, userID string, data interface{}) (bool, error) {
+	if config.planLimits(planName).MaxStorageBytes <= 0 {
+		return false, nil
+	}
+
+	record, err := config.loadUserRecord(userID)
+	if err != nil {
+		return false, err
+	}
+	record["data"] = data
+
+	return config.exceedsStorageLimit(planName, record)
+}
+
+// dataSize returns the size in bytes of the BSON encoding of the user data
+func dataSize(data interface{}) (int, error) {
+	bytes, err := bson.Marshal(bson.M{"data": data})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(bytes), nil
+}
+
+// applyPatchToData applies the JSON patch to the passed user data in memory,
+// it is used to check the result of a patch before writing it to the database
+func applyPatchToData(data bson.M, rawPatch []byte) (interface{}, error) {
+	if data == nil {
+		data = bson.M{}
+	}
+	jsonData, err := bson.MarshalExtJSON(data, false, true)
+	if err != nil {
+		return nil, err
+	}
+
+	patch, err := jsonpatch.DecodePatch(rawPatch)
+	if err != nil {
+		return nil, err
+	}
+	patchedData, err := patch.Apply(jsonData)
+	if err != nil {
+		return nil, err
+	}
+
+	var result interface{}
+	err = json.Unmarshal(patchedData, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// loadTenantUsage computes the current resource usage of the tenant
+func loadTenantUsage(config *DatabaseConfig) (*TenantUsage, error) {
+	usage := TenantUsage{
+		MaxUsers:             config.Limits.MaxUsers,
+		MaxDataBytes:         config.Limits.MaxDataBytes,
+		RequestsLastMinute:   tenantRequests.Count(config.ID.Hex()),
+		MaxRequestsPerMinute: config.Limits.MaxRequestsPerMinute,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	users, err := config.UserCollection.CountDocuments(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	usage.Users = users
+
+	cursor, err := config.UserCollection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$project", Value: bson.M{"size": bson.M{"$cond": bson.A{
+			bson.M{"$eq": bson.A{bson.M{"$type": "$data"}, "object"}},
+			bson.M{"$bsonSize": "$data"},
+			0,
+		}}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":     nil,
+			"total":   bson.M{"$sum": "$size"},
+			"largest": bson.M{"$max": "$size"},
+		}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var sizes []struct {
+		Total   int64
+		Largest int64
+	}
+	err = cursor.All(ctx, &sizes)
+	if err != nil {
+		return nil, err
+	}
+	if len(sizes) > 0 {
+		usage.DataBytes = sizes[0].Total
+		usage.LargestDataBytes = sizes[0].Largest
+	}
+
+	return &usage, nil
+}
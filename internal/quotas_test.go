@@ -0,0 +1,47 @@
+package internal
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRequestCounter(t *testing.T) {
+	t.Run("Limit reached", func(t *testing.T) {
+		rc := requestCounter{windows: make(map[string]*requestWindow)}
+		if !rc.Allow("a", 2) || !rc.Allow("a", 2) {
+			t.Fatal("The first two requests should be allowed")
+		}
+		if rc.Allow("a", 2) {
+			t.Error("The third request exceeds the limit")
+		}
+		if rc.Count("a") != 2 {
+			t.Errorf("Expected 2 requests, got %d", rc.Count("a"))
+		}
+	})
+
+	t.Run("Unlimited key", func(t *testing.T) {
+		rc := requestCounter{windows: make(map[string]*requestWindow)}
+		if !rc.Allow("a", 0) {
+			t.Error("A request without limit should be allowed")
+		}
+		if len(rc.windows) != 0 {
+			t.Error("The requests without limit should not be stored")
+		}
+	})
+
+	t.Run("Expired windows", func(t *testing.T) {
+		rc := requestCounter{windows: make(map[string]*requestWindow)}
+		rc.Allow("a", 5)
+		rc.Allow("b", 5)
+		rc.windows["a"].start = time.Now().Add(-2 * time.Minute)
+		rc.lastSweep = time.Now().Add(-2 * time.Minute)
+
+		rc.Allow("b", 5)
+		if _, ok := rc.windows["a"]; ok {
+			t.Error("The expired window should be dropped")
+		}
+		if rc.Count("b") != 2 {
+			t.Errorf("Expected 2 requests, got %d", rc.Count("b"))
+		}
+	})
+}
@@ -0,0 +1,122 @@
+package internal
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// maxKnownDevices is the number of devices remembered for each user, the
+// least recently added are forgotten first
+const maxKnownDevices = 20
+
+// NotificationSettings enables the security notifications sent to the users
+// of a tenant, the password changed email is always sent
+type NotificationSettings struct {
+	// NewLogin notifies logins from a device or IP address not seen before
+	NewLogin       bool
+	EmailChanged   bool
+	ApiKeyCreated  bool
+	AccountDeleted bool
+}
+
+// KnownDevice is a device from which a user logged in
+type KnownDevice struct {
+	Fingerprint string
+	UserAgent   string `bson:"userAgent"`
+	IP          string
+	AddedAt     time.Time `bson:"addedAt"`
+}
+
+// newKnownDevice identifies the device that sent the request by its user
+// agent and IP address
+func newKnownDevice(c *gin.Context) KnownDevice {
+	userAgent := c.Request.UserAgent()
+	if len(userAgent) > 256 {
+		userAgent = userAgent[:256]
+	}
+	hash := sha256.Sum256([]byte(userAgent + "\n" + c.ClientIP()))
+
+	return KnownDevice{
+		Fingerprint: hex.EncodeToString(hash[:]),
+		UserAgent:   userAgent,
+		IP:          c.ClientIP(),
+		AddedAt:     time.Now(),
+	}
+}
+
+// notificationEnabled returns true if the tenant enabled the security
+// notification with the passed email name
+func (config *DatabaseConfig) notificationEnabled(name string) bool {
+	switch name {
+	case "newLogin":
+		return config.Email.Notifications.NewLogin
+	case "emailChanged":
+		return config.Email.Notifications.EmailChanged
+	case "apiKeyCreated":
+		return config.Email.Notifications.ApiKeyCreated
+	case "accountDeleted":
+		return config.Email.Notifications.AccountDeleted
+	}
+
+	return false
+}
+
+// securityEmailVars returns the details of the request shown in the
+// security notifications
+func securityEmailVars(c *gin.Context) map[string]string {
+	device := c.Request.UserAgent()
+	if device == "" {
+		device = "Unknown device"
+	}
+
+	return map[string]string{
+		"Device": device,
+		"IP":     c.ClientIP(),
+		"Time":   time.Now().UTC().Format("2 January 2006 15:04 MST"),
+	}
+}
+
+// sendSecurityNotification sends the security notification to the recipient
+// if the tenant enabled it
+func (config *DatabaseConfig) sendSecurityNotification(name string, c *gin.Context, recipient string, locale string, vars map[string]string) {
+	if !config.notificationEnabled(name) {
+		return
+	}
+
+	emailVars := securityEmailVars(c)
+	for key, value := range vars {
+		emailVars[key] = value
+	}
+	config.sendEmail(name, recipient, locale, emailVars)
+}
+
+// registerDevice adds the device that sent the request to the known devices
+// of the user and returns true if it was not known. The first device of
+// users created before devices were tracked is not considered new
+func (config *DatabaseConfig) registerDevice(user User, c *gin.Context) bool {
+	device := newKnownDevice(c)
+	for _, known := range user.KnownDevices {
+		if known.Fingerprint == device.Fingerprint {
+			return false
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err := config.UserCollection.UpdateOne(
+		ctx,
+		bson.M{"_id": user.ID, "knownDevices.fingerprint": bson.M{"$ne": device.Fingerprint}},
+		bson.M{"$push": bson.M{"knownDevices": bson.M{"$each": bson.A{device}, "$slice": -maxKnownDevices}}},
+	)
+	if err != nil {
+		fmt.Println(err)
+	}
+
+	return len(user.KnownDevices) > 0
+}
@@ -5,9 +5,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net/mail"
 	"time"
 
 	jsonpatchtomongo "github.com/ZaninAndrea/json-patch-to-mongo"
+	"github.com/gin-contrib/location"
 	"github.com/gin-gonic/gin"
 	"github.com/nbutton23/zxcvbn-go"
 	"go.mongodb.org/mongo-driver/bson"
@@ -15,7 +17,9 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
-func SetupUserRoute(r *gin.Engine, client *mongo.Client) {
+func SetupUserRoute(engine *gin.Engine, client *mongo.Client) {
+	r := engine.Group("", limitTenantRequests(client))
+
 	r.POST("/login", func(c *gin.Context) {
 		config, err := GetServerConfig(c, client)
 		if err != nil {
@@ -63,9 +67,14 @@ func SetupUserRoute(r *gin.Engine, client *mongo.Client) {
 			return
 		}
 
+		config.recordUsage("logins", 1)
+		config.recordActivity(userFound.ID.Hex())
 		c.JSON(200, gin.H{
 			"token": GenerateToken(userFound.ID.Hex()),
 		})
+		if config.registerDevice(userFound, c) {
+			config.sendSecurityNotification("newLogin", c, userFound.Email, userFound.Locale, nil)
+		}
 	})
 
 	r.POST("/changePassword", func(c *gin.Context) {
@@ -134,10 +143,10 @@ func SetupUserRoute(r *gin.Engine, client *mongo.Client) {
 		}
 
 		c.String(200, "")
-		config.sendPasswordChangedEmail(email[0])
+		config.sendPasswordChangedEmail(email[0], userFound.Locale)
 	})
 
-	r.GET("/user", func(c *gin.Context) {
+	r.POST("/user/email", func(c *gin.Context) {
 		config, err := GetServerConfig(c, client)
 		if err != nil {
 			c.JSON(400, gin.H{
@@ -148,9 +157,129 @@ func SetupUserRoute(r *gin.Engine, client *mongo.Client) {
 		userCollection := config.UserCollection
 
 		// check authentication
-		parsedToken, err := parseBearer(c.Request.Header["Authorization"])
+		userID, _, ok := config.authenticateUser(c, false)
+		if !ok {
+			return
+		}
+
+		newEmail, providedNewEmail := c.Request.URL.Query()["newEmail"]
+		password, providedPassword := c.Request.URL.Query()["password"]
+		if !providedNewEmail || !providedPassword {
+			c.JSON(400, gin.H{
+				"error": "You need to pass newEmail and password in the query",
+			})
+			return
+		}
+		if address, err := mail.ParseAddress(newEmail[0]); err != nil || address.Address != newEmail[0] {
+			c.JSON(400, gin.H{"error": "The new email is not a valid address"})
+			return
+		}
+
+		userFound := loadUserByID(userID, userCollection, bson.M{})
+		if !CheckPasswordHash(password[0], userFound.Password) {
+			c.JSON(400, gin.H{
+				"error": "Wrong password",
+			})
+			return
+		}
+		if userFound.Email == newEmail[0] {
+			c.JSON(400, gin.H{"error": "The new email is the current one"})
+			return
+		}
+
+		// check if a user with the new email exists
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		count, err := userCollection.CountDocuments(ctx, bson.M{"email": newEmail[0]})
 		if err != nil {
-			c.JSON(500, gin.H{"error": "Failed to parse authorization token"})
+			panic(err)
+		} else if count > 0 {
+			c.JSON(400, gin.H{"error": "Another user with this email already exists"})
+			return
+		}
+
+		token, err := config.requestEmailChange(userFound.ID, newEmail[0])
+		if err != nil {
+			panic(err)
+		}
+
+		c.String(200, "")
+		url := location.Get(c)
+		config.sendEmail("confirmEmailChange", newEmail[0], userFound.Locale, map[string]string{
+			"Link": url.Scheme + "://" + url.Host + "/user/email/confirm?token=" + token,
+		})
+		vars := securityEmailVars(c)
+		vars["NewEmail"] = newEmail[0]
+		config.sendEmail("emailChangeRequested", userFound.Email, userFound.Locale, vars)
+	})
+
+	r.GET("/user/email/confirm", func(c *gin.Context) {
+		config, err := GetServerConfig(c, client)
+		if err != nil {
+			c.JSON(400, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		token := c.Request.URL.Query().Get("token")
+		if token == "" {
+			c.JSON(400, gin.H{"error": "You need to pass the confirmation token in the query"})
+			return
+		}
+
+		previousUser, err := config.confirmEmailChange(token)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(200, gin.H{"email": previousUser.PendingEmail.Email})
+		config.sendSecurityNotification("emailChanged", c, previousUser.Email, previousUser.Locale, map[string]string{
+			"NewEmail": previousUser.PendingEmail.Email,
+		})
+	})
+
+	r.GET("/user/unsubscribe", func(c *gin.Context) {
+		config, err := GetServerConfig(c, client)
+		if err != nil {
+			c.JSON(400, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		userID, err := primitive.ObjectIDFromHex(c.Request.URL.Query().Get("user"))
+		if err != nil || !config.checkUnsubscribeToken(userID, c.Request.URL.Query().Get("token")) {
+			c.JSON(400, gin.H{"error": "The unsubscribe link is invalid"})
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_, err = config.UserCollection.UpdateOne(ctx, bson.M{"_id": userID}, bson.M{
+			"$set": bson.M{"unsubscribed": true},
+		})
+		if err != nil {
+			panic(err)
+		}
+
+		c.String(200, "You will not receive our announcements anymore")
+	})
+
+	r.GET("/user", func(c *gin.Context) {
+		config, err := GetServerConfig(c, client)
+		if err != nil {
+			c.JSON(400, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+		userCollection := config.UserCollection
+
+		// check authentication
+		userID, _, ok := config.authenticateUser(c, true)
+		if !ok {
 			return
 		}
 
@@ -167,7 +296,7 @@ func SetupUserRoute(r *gin.Engine, client *mongo.Client) {
 			projection["data."+k] = v
 		}
 
-		userFound := loadUserByID(parsedToken.UserID, userCollection, projection)
+		userFound := loadUserByID(userID, userCollection, projection)
 
 		// parse the bson data into JSON saved as []byte
 		jsonBytes, err := bson.MarshalExtJSON(userFound.Data, false, true)
@@ -187,17 +316,18 @@ func SetupUserRoute(r *gin.Engine, client *mongo.Client) {
 		userCollection := config.UserCollection
 
 		// check authentication
-		parsedToken, err := parseBearer(c.Request.Header["Authorization"])
-		if err != nil {
-			c.JSON(500, gin.H{"error": "Failed to parse authorization token"})
+		userID, _, ok := config.authenticateUser(c, true)
+		if !ok {
 			return
 		}
 
-		userFound := loadUserByID(parsedToken.UserID, userCollection, bson.M{})
+		userFound := loadUserByID(userID, userCollection, bson.M{})
 
 		jsonBytes, err := json.Marshal(map[string]interface{}{
-			"email": userFound.Email,
-			"plan": userFound.Plan,
+			"email":    userFound.Email,
+			"plan":     userFound.Plan,
+			"features": config.planFeatures(userFound.Plan),
+			"locale":   userFound.Locale,
 		})
 		if err != nil {
 			c.JSON(500, gin.H{"error": "Internal error marshaling the JSON"})
@@ -235,6 +365,17 @@ func SetupUserRoute(r *gin.Engine, client *mongo.Client) {
 			return
 		}
 
+		// the locale is used to send the emails in the language of the user
+		locale := c.Request.URL.Query().Get("locale")
+		if locale == "" {
+			locale = parseAcceptLanguage(c.GetHeader("Accept-Language"))
+		} else if !isValidLocale(locale) {
+			c.JSON(400, gin.H{
+				"error": "The locale must be a valid language tag, e.g. en or it-CH",
+			})
+			return
+		}
+
 		// check if a user with the same email exists
 		filter := bson.M{"email": email[0]}
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -253,11 +394,31 @@ func SetupUserRoute(r *gin.Engine, client *mongo.Client) {
 		jsonData, err := ioutil.ReadAll(c.Request.Body)
 		if err != nil {
 			c.JSON(400, gin.H{"error": "Failed to parse body"})
+			return
+		}
+		if !config.checkDataFeatures(c, config.defaultPlan(), dataFields(jsonData)) {
+			return
+		}
+		jsonData, valid := config.normalizeUserData(c, jsonData)
+		if !valid {
+			return
 		}
 		var initialData interface{}
 		err = bson.UnmarshalExtJSON(jsonData, true, &initialData)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Failed to parse body"})
+			return
+		}
+		exceeded, err := config.exceedsDataLimit(config.defaultPlan(), initialData)
+		if err != nil {
+			panic(err)
+		} else if exceeded {
+			c.JSON(413, gin.H{"error": "The data exceeds the maximum size allowed"})
+			return
+		}
 
 		// create new user
+		config.ensureUserIndexes()
 		ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 		passwordHash, err := HashPassword(password[0])
@@ -266,13 +427,46 @@ func SetupUserRoute(r *gin.Engine, client *mongo.Client) {
 			panic(err)
 		}
 
-		res, err := userCollection.InsertOne(ctx, bson.M{
+		newUser := bson.M{
 			"email": email[0],
 			"password": passwordHash,
-			"plan": "BASIC",
+			"plan": config.defaultPlan(),
+			"locale": locale,
+			"knownDevices": []KnownDevice{newKnownDevice(c)},
 			"data": initialData,
-		})
+		}
+		exceeded, err = config.exceedsStorageLimit(config.defaultPlan(), newUser)
+		if err != nil {
+			panic(err)
+		} else if exceeded {
+			c.JSON(413, gin.H{"error": "The storage allowed by your plan is exhausted"})
+			return
+		}
+
+		// check that the tenant has room for another user
+		reserved, err := config.reserveUser()
+		if err != nil {
+			panic(err)
+		} else if !reserved {
+			c.JSON(403, gin.H{"error": "The maximum number of users has been reached"})
+			return
+		}
+
+		res, err := userCollection.InsertOne(ctx, newUser)
+		if err != nil {
+			if releaseErr := config.releaseUser(); releaseErr != nil {
+				panic(releaseErr)
+			}
+		}
+		if mongo.IsDuplicateKeyError(err) {
+			c.JSON(400, gin.H{"error": "Another user with this email already exists"})
+			return
+		} else if err != nil {
+			panic(err)
+		}
 		id := res.InsertedID.(primitive.ObjectID).Hex()
+		config.recordUsage("signups", 1)
+		config.recordActivity(id)
 
 		c.JSON(200, gin.H{
 			"token": GenerateToken(id),
@@ -290,9 +484,8 @@ func SetupUserRoute(r *gin.Engine, client *mongo.Client) {
 		userCollection := config.UserCollection
 
 		// Check authorization
-		parsedToken, err := parseBearer(c.Request.Header["Authorization"])
-		if err != nil {
-			c.JSON(500, gin.H{"error": "Failed to parse token"})
+		userID, userPlan, ok := config.authenticateUser(c, true)
+		if !ok {
 			return
 		}
 
@@ -307,8 +500,46 @@ func SetupUserRoute(r *gin.Engine, client *mongo.Client) {
 			return
 		}
 
+		if !config.checkDataFeatures(c, userPlan, patchedDataFields(rawPatch)) {
+			return
+		}
+
+		// check the schema, the size of the patched data and the storage of
+		// the user before writing it
+		limits := config.planLimits(userPlan)
+		if limits.MaxDataBytes > 0 || limits.MaxStorageBytes > 0 || len(config.DataSchema) > 0 {
+			userFound := loadUserByID(userID, userCollection, bson.M{"data": 1})
+			if !config.validateUserDataPatch(c, userFound.Data, rawPatch) {
+				return
+			}
+
+			if limits.MaxDataBytes > 0 || limits.MaxStorageBytes > 0 {
+				patchedData, err := applyPatchToData(userFound.Data, rawPatch)
+				if err != nil {
+					c.JSON(400, gin.H{"error": "Failed to apply patch: " + err.Error()})
+					return
+				}
+
+				exceeded, err := config.exceedsDataLimit(userPlan, patchedData)
+				if err != nil {
+					panic(err)
+				} else if exceeded {
+					c.JSON(413, gin.H{"error": "The data exceeds the maximum size allowed"})
+					return
+				}
+
+				exceeded, err = config.exceedsStorageLimitWithData(userPlan, userID, patchedData)
+				if err != nil {
+					panic(err)
+				} else if exceeded {
+					c.JSON(413, gin.H{"error": "The storage allowed by your plan is exhausted"})
+					return
+				}
+			}
+		}
+
 		// apply the update query to the authenticated user
-		objID, _ := primitive.ObjectIDFromHex(parsedToken.UserID)
+		objID, _ := primitive.ObjectIDFromHex(userID)
 		filter := bson.M{"_id": objID}
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
@@ -341,9 +572,8 @@ func SetupUserRoute(r *gin.Engine, client *mongo.Client) {
 		userCollection := config.UserCollection
 
 		// Check authorization
-		parsedToken, err := parseBearer(c.Request.Header["Authorization"])
-		if err != nil {
-			c.JSON(500, gin.H{"error": "Failed to parse authorization token"})
+		userID, userPlan, ok := config.authenticateUser(c, true)
+		if !ok {
 			return
 		}
 
@@ -351,12 +581,38 @@ func SetupUserRoute(r *gin.Engine, client *mongo.Client) {
 		jsonData, err := ioutil.ReadAll(c.Request.Body)
 		if err != nil {
 			c.JSON(500, gin.H{"error": "Failed to read body"})
+			return
+		}
+		if !config.checkDataFeatures(c, userPlan, dataFields(jsonData)) {
+			return
+		}
+		jsonData, valid := config.normalizeUserData(c, jsonData)
+		if !valid {
+			return
 		}
 		var updateQuery interface{}
 		err = bson.UnmarshalExtJSON(jsonData, true, &updateQuery)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Failed to parse body"})
+			return
+		}
+		exceeded, err := config.exceedsDataLimit(userPlan, updateQuery)
+		if err != nil {
+			panic(err)
+		} else if exceeded {
+			c.JSON(413, gin.H{"error": "The data exceeds the maximum size allowed"})
+			return
+		}
+		exceeded, err = config.exceedsStorageLimitWithData(userPlan, userID, updateQuery)
+		if err != nil {
+			panic(err)
+		} else if exceeded {
+			c.JSON(413, gin.H{"error": "The storage allowed by your plan is exhausted"})
+			return
+		}
 
 		// update database
-		objID, _ := primitive.ObjectIDFromHex(parsedToken.UserID)
+		objID, _ := primitive.ObjectIDFromHex(userID)
 		filter := bson.M{"_id": objID}
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
@@ -382,22 +638,130 @@ func SetupUserRoute(r *gin.Engine, client *mongo.Client) {
 		userCollection := config.UserCollection
 
 		// check authentication
-		parsedToken, err := parseBearer(c.Request.Header["Authorization"])
-		if err != nil {
-			c.JSON(500, gin.H{"error": "Failed to parse authorization token"})
+		userID, _, ok := config.authenticateUser(c, false)
+		if !ok {
 			return
 		}
 
 		// update database
-		objID, _ := primitive.ObjectIDFromHex(parsedToken.UserID)
+		objID, _ := primitive.ObjectIDFromHex(userID)
 		filter := bson.M{"_id": objID}
 		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 
-		err = userCollection.FindOneAndDelete(ctx, filter).Err()
+		var deletedUser User
+		err = userCollection.FindOneAndDelete(ctx, filter).Decode(&deletedUser)
 		if err != nil {
 			panic(err)
 		}
+		err = config.releaseUser()
+		if err != nil {
+			panic(err)
+		}
+
+		c.String(200, "")
+		config.sendSecurityNotification("accountDeleted", c, deletedUser.Email, deletedUser.Locale, nil)
+	})
+
+	r.GET("/user/apiKeys", func(c *gin.Context) {
+		config, err := GetServerConfig(c, client)
+		if err != nil {
+			c.JSON(400, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		// check authentication
+		userID, _, ok := config.authenticateUser(c, false)
+		if !ok {
+			return
+		}
+
+		userFound := loadUserByID(userID, config.UserCollection, bson.M{"apiKeys": 1})
+		apiKeys := userFound.ApiKeys
+		if apiKeys == nil {
+			apiKeys = []ApiKey{}
+		}
+
+		c.JSON(200, apiKeys)
+	})
+
+	r.POST("/user/apiKeys", func(c *gin.Context) {
+		config, err := GetServerConfig(c, client)
+		if err != nil {
+			c.JSON(400, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		// check authentication
+		userID, userPlan, ok := config.authenticateUser(c, false)
+		if !ok {
+			return
+		}
+
+		name := c.Request.URL.Query().Get("name")
+		if name == "" {
+			c.JSON(400, gin.H{"error": "You need to pass the name of the API key in the query"})
+			return
+		}
+
+		key, apiKey, err := config.createApiKey(userID, userPlan, name)
+		switch err.(type) {
+		case nil:
+		case errApiKeyLimit:
+			c.JSON(403, gin.H{"error": err.Error()})
+			return
+		case errStorageLimit:
+			c.JSON(413, gin.H{"error": err.Error()})
+			return
+		default:
+			panic(err)
+		}
+
+		c.JSON(200, gin.H{
+			"id":        apiKey.ID,
+			"name":      apiKey.Name,
+			"createdAt": apiKey.CreatedAt,
+			"key":       key,
+		})
+
+		userFound := loadUserByID(userID, config.UserCollection, bson.M{"email": 1, "locale": 1})
+		config.sendSecurityNotification("apiKeyCreated", c, userFound.Email, userFound.Locale, map[string]string{
+			"KeyName": apiKey.Name,
+		})
+	})
+
+	r.DELETE("/user/apiKeys/:keyId", func(c *gin.Context) {
+		config, err := GetServerConfig(c, client)
+		if err != nil {
+			c.JSON(400, gin.H{
+				"error": err.Error(),
+			})
+			return
+		}
+
+		// check authentication
+		userID, _, ok := config.authenticateUser(c, false)
+		if !ok {
+			return
+		}
+
+		keyID, err := primitive.ObjectIDFromHex(c.Param("keyId"))
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Passed an invalid API key id"})
+			return
+		}
+
+		deleted, err := config.deleteApiKey(userID, keyID)
+		if err != nil {
+			panic(err)
+		} else if !deleted {
+			c.JSON(404, gin.H{"error": "The API key doesn't exist"})
+			return
+		}
 
 		c.String(200, "")
 	})
@@ -0,0 +1,72 @@
+package billing
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// EventType is the kind of a billing event, independent of the provider
+type EventType string
+
+const (
+	SubscriptionCreated   EventType = "subscription_created"
+	SubscriptionUpdated   EventType = "subscription_updated"
+	SubscriptionCancelled EventType = "subscription_cancelled"
+	PaymentFailed         EventType = "payment_failed"
+	PaymentSucceeded      EventType = "payment_succeeded"
+)
+
+// Event is a billing event received from a payment provider
+type Event struct {
+	// ID is unique for each event and is used as idempotency key
+	ID        string
+	Type      EventType
+	UserID    string
+	Email     string
+	PriceID   string
+	CreatedAt time.Time
+}
+
+// Provider verifies and parses the webhooks sent by a payment provider
+type Provider interface {
+	Name() string
+	// VerifySignature checks that the payload was signed with the secret
+	VerifySignature(payload []byte, header http.Header, secret string) error
+	// ParseEvent returns nil if the payload is a valid event that isn't
+	// relevant for billing
+	ParseEvent(payload []byte) (*Event, error)
+}
+
+var (
+	providersMutex sync.RWMutex
+	providers      = map[string]Provider{}
+)
+
+func init() {
+	Register(&StripeProvider{Tolerance: 5 * time.Minute})
+	Register(&GenericProvider{})
+}
+
+// Register makes a provider available under its name, registering a provider
+// with the same name of an existing one replaces it
+func Register(provider Provider) {
+	providersMutex.Lock()
+	defer providersMutex.Unlock()
+
+	providers[provider.Name()] = provider
+}
+
+// GetProvider returns the provider registered with the passed name
+func GetProvider(name string) (Provider, error) {
+	providersMutex.RLock()
+	defer providersMutex.RUnlock()
+
+	provider, ok := providers[name]
+	if !ok {
+		return nil, fmt.Errorf("The billing provider %s is not supported", name)
+	}
+
+	return provider, nil
+}
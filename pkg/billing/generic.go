@@ -0,0 +1,67 @@
+package billing
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// GenericProvider accepts events already in the shipyard format, signed with
+// the hex encoded HMAC-SHA256 of the payload in the X-Signature header
+type GenericProvider struct{}
+
+func (p *GenericProvider) Name() string {
+	return "generic"
+}
+
+func (p *GenericProvider) VerifySignature(payload []byte, header http.Header, secret string) error {
+	signature, err := hex.DecodeString(header.Get("X-Signature"))
+	if err != nil || len(signature) == 0 {
+		return fmt.Errorf("The X-Signature header is missing or malformed")
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return fmt.Errorf("The signature does not match the payload")
+	}
+
+	return nil
+}
+
+func (p *GenericProvider) ParseEvent(payload []byte) (*Event, error) {
+	var body struct {
+		ID        string `json:"id"`
+		Type      string `json:"type"`
+		UserID    string `json:"userId"`
+		Email     string `json:"email"`
+		PriceID   string `json:"priceId"`
+		CreatedAt int64  `json:"createdAt"`
+	}
+	err := json.Unmarshal(payload, &body)
+	if err != nil {
+		return nil, fmt.Errorf("The event is not valid JSON")
+	}
+	if body.ID == "" {
+		return nil, fmt.Errorf("The event is missing the id field")
+	}
+
+	switch EventType(body.Type) {
+	case SubscriptionCreated, SubscriptionUpdated, SubscriptionCancelled, PaymentFailed, PaymentSucceeded:
+	default:
+		return nil, nil
+	}
+
+	return &Event{
+		ID:        body.ID,
+		Type:      EventType(body.Type),
+		UserID:    body.UserID,
+		Email:     body.Email,
+		PriceID:   body.PriceID,
+		CreatedAt: time.Unix(body.CreatedAt, 0),
+	}, nil
+}
@@ -0,0 +1,50 @@
+package billing
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"testing"
+)
+
+func TestGenericProvider(t *testing.T) {
+	payload, err := ioutil.ReadFile("testdata/generic_payment_failed.json")
+	if err != nil {
+		panic(err)
+	}
+
+	provider, err := GetProvider("generic")
+	if err != nil {
+		panic(err)
+	}
+
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write(payload)
+	header := http.Header{}
+	header.Set("X-Signature", hex.EncodeToString(mac.Sum(nil)))
+
+	t.Run("Valid signature", func(t *testing.T) {
+		err := provider.VerifySignature(payload, header, "secret")
+		if err != nil {
+			t.Error(err)
+		}
+	})
+	t.Run("Wrong secret", func(t *testing.T) {
+		err := provider.VerifySignature(payload, header, "another secret")
+		if err == nil {
+			t.Error("A payload signed with another secret was accepted")
+		}
+	})
+	t.Run("Parse event", func(t *testing.T) {
+		event, err := provider.ParseEvent(payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if event.Type != PaymentFailed || event.Email != "andrea@example.com" || event.PriceID != "pro-monthly" {
+			t.Errorf("The event was parsed incorrectly: %+v", event)
+		}
+	})
+}
@@ -0,0 +1,156 @@
+package billing
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StripeProvider handles the webhooks sent by Stripe, the subscriptions
+// should carry the userId or the email of the user in their metadata
+type StripeProvider struct {
+	// Tolerance is the maximum age of a signature, 0 disables the check
+	Tolerance time.Duration
+	// Now returns the current time, if nil time.Now is used
+	Now func() time.Time
+}
+
+func (p *StripeProvider) Name() string {
+	return "stripe"
+}
+
+func (p *StripeProvider) VerifySignature(payload []byte, header http.Header, secret string) error {
+	var timestamp string
+	signatures := [][]byte{}
+	for _, part := range strings.Split(header.Get("Stripe-Signature"), ",") {
+		keyValue := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(keyValue) != 2 {
+			continue
+		}
+
+		switch keyValue[0] {
+		case "t":
+			timestamp = keyValue[1]
+		case "v1":
+			signature, err := hex.DecodeString(keyValue[1])
+			if err == nil {
+				signatures = append(signatures, signature)
+			}
+		}
+	}
+	if timestamp == "" || len(signatures) == 0 {
+		return fmt.Errorf("The Stripe-Signature header is missing or malformed")
+	}
+
+	if p.Tolerance > 0 {
+		seconds, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			return fmt.Errorf("The Stripe-Signature timestamp is malformed")
+		}
+
+		now := time.Now
+		if p.Now != nil {
+			now = p.Now
+		}
+		if now().Sub(time.Unix(seconds, 0)) > p.Tolerance {
+			return fmt.Errorf("The Stripe-Signature timestamp is too old")
+		}
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	expected := mac.Sum(nil)
+	for _, signature := range signatures {
+		if hmac.Equal(signature, expected) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("The signature does not match the payload")
+}
+
+type stripeLines struct {
+	Data []struct {
+		Price struct {
+			ID string `json:"id"`
+		} `json:"price"`
+	} `json:"data"`
+}
+
+func (l stripeLines) firstPrice() string {
+	if len(l.Data) == 0 {
+		return ""
+	}
+
+	return l.Data[0].Price.ID
+}
+
+func (p *StripeProvider) ParseEvent(payload []byte) (*Event, error) {
+	var body struct {
+		ID      string `json:"id"`
+		Type    string `json:"type"`
+		Created int64  `json:"created"`
+		Data    struct {
+			Object struct {
+				Metadata            map[string]string `json:"metadata"`
+				CustomerEmail       string            `json:"customer_email"`
+				Items               stripeLines       `json:"items"`
+				Lines               stripeLines       `json:"lines"`
+				SubscriptionDetails struct {
+					Metadata map[string]string `json:"metadata"`
+				} `json:"subscription_details"`
+			} `json:"object"`
+		} `json:"data"`
+	}
+	err := json.Unmarshal(payload, &body)
+	if err != nil {
+		return nil, fmt.Errorf("The event is not valid JSON")
+	}
+	if body.ID == "" {
+		return nil, fmt.Errorf("The event is missing the id field")
+	}
+
+	object := body.Data.Object
+	event := Event{
+		ID:        body.ID,
+		CreatedAt: time.Unix(body.Created, 0),
+	}
+
+	metadata := object.Metadata
+	switch body.Type {
+	case "customer.subscription.created":
+		event.Type = SubscriptionCreated
+		event.PriceID = object.Items.firstPrice()
+	case "customer.subscription.updated":
+		event.Type = SubscriptionUpdated
+		event.PriceID = object.Items.firstPrice()
+	case "customer.subscription.deleted":
+		event.Type = SubscriptionCancelled
+		event.PriceID = object.Items.firstPrice()
+	case "invoice.payment_failed":
+		event.Type = PaymentFailed
+		event.PriceID = object.Lines.firstPrice()
+		metadata = object.SubscriptionDetails.Metadata
+	case "invoice.payment_succeeded":
+		event.Type = PaymentSucceeded
+		event.PriceID = object.Lines.firstPrice()
+		metadata = object.SubscriptionDetails.Metadata
+	default:
+		return nil, nil
+	}
+
+	event.UserID = metadata["userId"]
+	event.Email = metadata["email"]
+	if event.Email == "" {
+		event.Email = object.CustomerEmail
+	}
+
+	return &event, nil
+}
@@ -0,0 +1,126 @@
+package billing
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+)
+
+const stripeTestSecret = "whsec_test_secret"
+
+func stripeHeader(payload []byte, timestamp int64, secret string) http.Header {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(fmt.Sprintf("%d.", timestamp)))
+	mac.Write(payload)
+
+	header := http.Header{}
+	header.Set("Stripe-Signature", fmt.Sprintf("t=%d,v1=%s", timestamp, hex.EncodeToString(mac.Sum(nil))))
+	return header
+}
+
+func TestStripeSignature(t *testing.T) {
+	payload, err := ioutil.ReadFile("testdata/stripe_subscription_updated.json")
+	if err != nil {
+		panic(err)
+	}
+
+	const created int64 = 1686089970
+	provider := StripeProvider{
+		Tolerance: 5 * time.Minute,
+		Now:       func() time.Time { return time.Unix(created+60, 0) },
+	}
+
+	t.Run("Valid signature", func(t *testing.T) {
+		err := provider.VerifySignature(payload, stripeHeader(payload, created, stripeTestSecret), stripeTestSecret)
+		if err != nil {
+			t.Error(err)
+		}
+	})
+	t.Run("Wrong secret", func(t *testing.T) {
+		err := provider.VerifySignature(payload, stripeHeader(payload, created, "another secret"), stripeTestSecret)
+		if err == nil {
+			t.Error("A payload signed with another secret was accepted")
+		}
+	})
+	t.Run("Tampered payload", func(t *testing.T) {
+		header := stripeHeader(payload, created, stripeTestSecret)
+		err := provider.VerifySignature(append(payload, ' '), header, stripeTestSecret)
+		if err == nil {
+			t.Error("A tampered payload was accepted")
+		}
+	})
+	t.Run("Expired timestamp", func(t *testing.T) {
+		err := provider.VerifySignature(payload, stripeHeader(payload, created-3600, stripeTestSecret), stripeTestSecret)
+		if err == nil {
+			t.Error("A signature older than the tolerance was accepted")
+		}
+	})
+	t.Run("Missing header", func(t *testing.T) {
+		err := provider.VerifySignature(payload, http.Header{}, stripeTestSecret)
+		if err == nil {
+			t.Error("A request without signature was accepted")
+		}
+	})
+}
+
+func TestStripeEvents(t *testing.T) {
+	provider := StripeProvider{}
+	fixtures := []struct {
+		file     string
+		expected *Event
+	}{
+		{"stripe_subscription_updated.json", &Event{
+			ID:      "evt_1NG8Du2eZvKYlo2CUI79vXWy",
+			Type:    SubscriptionUpdated,
+			UserID:  "613b7135a161e522ea5d5575",
+			PriceID: "price_1NG8Dq2eZvKYlo2CKDqExXwv",
+		}},
+		{"stripe_subscription_deleted.json", &Event{
+			ID:      "evt_1NG8Fx2eZvKYlo2CB1sCXkQ8",
+			Type:    SubscriptionCancelled,
+			Email:   "andrea@example.com",
+			PriceID: "price_1NG8Dq2eZvKYlo2CKDqExXwv",
+		}},
+		{"stripe_invoice_payment_failed.json", &Event{
+			ID:      "evt_1NG8Hk2eZvKYlo2CmWdQfTzR",
+			Type:    PaymentFailed,
+			Email:   "andrea@example.com",
+			PriceID: "price_1NG8Dq2eZvKYlo2CKDqExXwv",
+		}},
+		{"stripe_charge_succeeded.json", nil},
+	}
+
+	for _, fixture := range fixtures {
+		t.Run(fixture.file, func(t *testing.T) {
+			payload, err := ioutil.ReadFile("testdata/" + fixture.file)
+			if err != nil {
+				panic(err)
+			}
+
+			event, err := provider.ParseEvent(payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if fixture.expected == nil {
+				if event != nil {
+					t.Errorf("Expected the event to be ignored, got %+v", event)
+				}
+				return
+			}
+			if event == nil {
+				t.Fatal("The event was ignored")
+			}
+
+			event.CreatedAt = time.Time{}
+			if *event != *fixture.expected {
+				t.Errorf("Expected %+v, got %+v", fixture.expected, event)
+			}
+		})
+	}
+}
@@ -0,0 +1,403 @@
+package htmlmail
+
+import (
+	"bytes"
+	"regexp"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// declaration is a CSS property with its value, the value keeps the
+// !important flag
+type declaration struct {
+	property string
+	value    string
+}
+
+func (d declaration) important() bool {
+	return strings.HasSuffix(strings.ToLower(d.value), "!important")
+}
+
+// rule is a stylesheet rule whose selector can be matched against elements
+type rule struct {
+	selector     []compound
+	specificity  [3]int
+	order        int
+	declarations []declaration
+}
+
+// compound is a compound selector with the combinator linking it to the
+// previous one, e.g. "div.header" in "body > div.header"
+type compound struct {
+	combinator byte
+	tag        string
+	id         string
+	classes    []string
+}
+
+var commentPattern = regexp.MustCompile(`(?s)/\*.*?\*/`)
+var whitespacePattern = regexp.MustCompile(`\s+`)
+
+// compoundPattern matches the supported compound selectors, pseudo-classes
+// and attribute selectors cannot be inlined
+var compoundPattern = regexp.MustCompile(`^(\*|[a-zA-Z][a-zA-Z0-9-]*)?((?:[.#][a-zA-Z_-][a-zA-Z0-9_-]*)*)$`)
+var qualifierPattern = regexp.MustCompile(`[.#][^.#]+`)
+
+// splitDeclarations splits the declarations on the semicolons that are not
+// inside parentheses or quotes, like the ones of url(data:image/png;base64,...)
+func splitDeclarations(source string) []string {
+	parts := []string{}
+	depth := 0
+	var quote rune
+	escaped := false
+	start := 0
+	for i, r := range source {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == '(':
+			depth++
+		case r == ')' && depth > 0:
+			depth--
+		case r == ';' && depth == 0:
+			parts = append(parts, source[start:i])
+			start = i + 1
+		}
+	}
+
+	return append(parts, source[start:])
+}
+
+func parseDeclarations(source string) []declaration {
+	declarations := []declaration{}
+	for _, part := range splitDeclarations(source) {
+		separator := strings.Index(part, ":")
+		if separator < 0 {
+			continue
+		}
+
+		property := strings.ToLower(strings.TrimSpace(part[:separator]))
+		value := whitespacePattern.ReplaceAllString(strings.TrimSpace(part[separator+1:]), " ")
+		if property != "" && value != "" {
+			declarations = append(declarations, declaration{property, value})
+		}
+	}
+
+	return declarations
+}
+
+// parseSelector parses a selector made of compound selectors joined by
+// descendant or child combinators, ok is false if it cannot be inlined
+func parseSelector(source string) (selector []compound, specificity [3]int, ok bool) {
+	source = strings.ReplaceAll(source, ">", " > ")
+	combinator := byte(' ')
+	for _, token := range strings.Fields(source) {
+		if token == ">" {
+			if len(selector) == 0 || combinator == '>' {
+				return nil, specificity, false
+			}
+			combinator = '>'
+			continue
+		}
+
+		match := compoundPattern.FindStringSubmatch(token)
+		if match == nil {
+			return nil, specificity, false
+		}
+		part := compound{combinator: combinator, tag: strings.ToLower(match[1])}
+		if part.tag == "*" {
+			part.tag = ""
+		} else if part.tag != "" {
+			specificity[2]++
+		}
+		for _, qualifier := range qualifierPattern.FindAllString(match[2], -1) {
+			if qualifier[0] == '#' {
+				part.id = qualifier[1:]
+				specificity[0]++
+			} else {
+				part.classes = append(part.classes, qualifier[1:])
+				specificity[1]++
+			}
+		}
+
+		selector = append(selector, part)
+		combinator = ' '
+	}
+
+	return selector, specificity, len(selector) > 0 && combinator == ' '
+}
+
+// parseStylesheet splits the stylesheet in the rules that can be inlined and
+// the source of the ones that must stay in the stylesheet, like media
+// queries and pseudo-classes
+func parseStylesheet(source string, order *int) (rules []rule, kept string) {
+	source = commentPattern.ReplaceAllString(source, "")
+	keptBuffer := new(strings.Builder)
+
+	for {
+		open := strings.Index(source, "{")
+		if open < 0 {
+			break
+		}
+		prelude := strings.TrimSpace(source[:open])
+
+		// find the matching brace, at-rules contain nested blocks
+		depth, end := 0, -1
+		for i := open; i < len(source); i++ {
+			if source[i] == '{' {
+				depth++
+			} else if source[i] == '}' {
+				depth--
+				if depth == 0 {
+					end = i
+					break
+				}
+			}
+		}
+		if end < 0 {
+			break
+		}
+		body := source[open+1 : end]
+		source = source[end+1:]
+
+		if strings.HasPrefix(prelude, "@") {
+			keptBuffer.WriteString(prelude + " {" + body + "}\n")
+			continue
+		}
+
+		declarations := parseDeclarations(body)
+		for _, selectorSource := range strings.Split(prelude, ",") {
+			selector, specificity, ok := parseSelector(selectorSource)
+			if !ok {
+				keptBuffer.WriteString(strings.TrimSpace(selectorSource) + " {" + body + "}\n")
+				continue
+			}
+
+			*order++
+			rules = append(rules, rule{selector, specificity, *order, declarations})
+		}
+	}
+
+	return rules, keptBuffer.String()
+}
+
+func attribute(node *html.Node, name string) (string, bool) {
+	for _, attr := range node.Attr {
+		if attr.Key == name {
+			return attr.Val, true
+		}
+	}
+
+	return "", false
+}
+
+func setAttribute(node *html.Node, name string, value string) {
+	for i := range node.Attr {
+		if node.Attr[i].Key == name {
+			node.Attr[i].Val = value
+			return
+		}
+	}
+
+	node.Attr = append(node.Attr, html.Attribute{Key: name, Val: value})
+}
+
+func (part compound) matches(node *html.Node) bool {
+	if node.Type != html.ElementNode || (part.tag != "" && node.Data != part.tag) {
+		return false
+	}
+	if part.id != "" {
+		if id, _ := attribute(node, "id"); id != part.id {
+			return false
+		}
+	}
+
+	classAttribute, _ := attribute(node, "class")
+	classes := strings.Fields(classAttribute)
+	for _, class := range part.classes {
+		found := false
+		for _, candidate := range classes {
+			if candidate == class {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// matchSelector checks the selector from the last compound, walking up the
+// ancestors of the node for the combinators
+func matchSelector(selector []compound, node *html.Node) bool {
+	last := len(selector) - 1
+	if !selector[last].matches(node) {
+		return false
+	} else if last == 0 {
+		return true
+	}
+
+	rest := selector[:last]
+	if selector[last].combinator == '>' {
+		return node.Parent != nil && matchSelector(rest, node.Parent)
+	}
+	for ancestor := node.Parent; ancestor != nil; ancestor = ancestor.Parent {
+		if matchSelector(rest, ancestor) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// mergeDeclarations applies the declarations in order, a later declaration
+// overrides an earlier one unless only the earlier one is important
+func mergeDeclarations(merged []declaration, declarations []declaration) []declaration {
+	for _, current := range declarations {
+		replaced := false
+		for i, previous := range merged {
+			if previous.property != current.property {
+				continue
+			}
+			if !previous.important() || current.important() {
+				merged[i] = current
+			}
+			replaced = true
+			break
+		}
+		if !replaced {
+			merged = append(merged, current)
+		}
+	}
+
+	return merged
+}
+
+func formatDeclarations(declarations []declaration) string {
+	parts := make([]string, len(declarations))
+	for i, d := range declarations {
+		parts[i] = d.property + ": " + d.value
+	}
+
+	return strings.Join(parts, "; ") + ";"
+}
+
+func walk(node *html.Node, visit func(*html.Node)) {
+	visit(node)
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		walk(child, visit)
+	}
+}
+
+// InlineCSS moves the rules of the <style> elements of the document to the
+// style attributes of the elements they match, because many email clients
+// ignore stylesheets. Rules that cannot be inlined, like media queries and
+// pseudo-classes, are kept in their <style> element. Fragments without an
+// <html> element are returned as fragments
+func InlineCSS(source string) (string, error) {
+	isDocument := strings.Contains(strings.ToLower(source), "<html")
+
+	var root *html.Node
+	if isDocument {
+		document, err := html.Parse(strings.NewReader(source))
+		if err != nil {
+			return "", err
+		}
+		root = document
+	} else {
+		body := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+		nodes, err := html.ParseFragment(strings.NewReader(source), body)
+		if err != nil {
+			return "", err
+		}
+		for _, node := range nodes {
+			body.AppendChild(node)
+		}
+		root = body
+	}
+
+	// collect the rules and empty the stylesheets
+	var rules []rule
+	var styles []*html.Node
+	order := 0
+	walk(root, func(node *html.Node) {
+		if node.Type == html.ElementNode && node.Data == "style" {
+			styles = append(styles, node)
+		}
+	})
+	for _, style := range styles {
+		source := new(strings.Builder)
+		for child := style.FirstChild; child != nil; child = child.NextSibling {
+			source.WriteString(child.Data)
+		}
+
+		styleRules, kept := parseStylesheet(source.String(), &order)
+		rules = append(rules, styleRules...)
+		if strings.TrimSpace(kept) == "" {
+			style.Parent.RemoveChild(style)
+		} else {
+			for style.FirstChild != nil {
+				style.RemoveChild(style.FirstChild)
+			}
+			style.AppendChild(&html.Node{Type: html.TextNode, Data: kept})
+		}
+	}
+	sort.SliceStable(rules, func(i, j int) bool {
+		a, b := rules[i].specificity, rules[j].specificity
+		if a != b {
+			return a[0] < b[0] || (a[0] == b[0] && (a[1] < b[1] || (a[1] == b[1] && a[2] < b[2])))
+		}
+		return rules[i].order < rules[j].order
+	})
+
+	if len(rules) > 0 {
+		walk(root, func(node *html.Node) {
+			if node.Type != html.ElementNode {
+				return
+			}
+
+			var merged []declaration
+			for _, r := range rules {
+				if matchSelector(r.selector, node) {
+					merged = mergeDeclarations(merged, r.declarations)
+				}
+			}
+			if merged == nil {
+				return
+			}
+
+			// the declarations of the element win over the stylesheet
+			inline, _ := attribute(node, "style")
+			merged = mergeDeclarations(merged, parseDeclarations(inline))
+			setAttribute(node, "style", formatDeclarations(merged))
+		})
+	}
+
+	buffer := new(bytes.Buffer)
+	if isDocument {
+		err := html.Render(buffer, root)
+		return buffer.String(), err
+	}
+	for child := root.FirstChild; child != nil; child = child.NextSibling {
+		err := html.Render(buffer, child)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return buffer.String(), nil
+}
@@ -0,0 +1,99 @@
+package htmlmail
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestInlineCSS(t *testing.T) {
+	source := `<html><head><style>
+		/* the comments are removed */
+		p { color: red; margin: 0 }
+		.note { color: blue }
+		div > p.note { font-weight: bold }
+		a:hover { color: green }
+		@media (max-width: 600px) { p { margin: 4px } }
+	</style></head><body><div><p class="note" style="margin: 2px">Hi</p><p>There</p></div><a href="#">link</a></body></html>`
+
+	inlined, err := InlineCSS(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		// the more specific rules win and the inline style wins over the stylesheet
+		`<p class="note" style="color: blue; margin: 2px; font-weight: bold;">Hi</p>`,
+		`<p style="color: red; margin: 0;">There</p>`,
+		// the rules that cannot be inlined stay in the stylesheet
+		`a:hover { color: green }`,
+		`@media (max-width: 600px) { p { margin: 4px } }`,
+	}
+	for _, fragment := range expected {
+		if !strings.Contains(inlined, fragment) {
+			t.Errorf("The inlined document doesn't contain %s:\n%s", fragment, inlined)
+		}
+	}
+	if strings.Contains(inlined, "comments") {
+		t.Error("The CSS comments were not removed")
+	}
+}
+
+func TestInlineCSSRemovesEmptyStylesheets(t *testing.T) {
+	inlined, err := InlineCSS(`<style>b { color: red }</style><b>Hi</b>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if inlined != `<b style="color: red;">Hi</b>` {
+		t.Errorf("Unexpected fragment %s", inlined)
+	}
+}
+
+func TestInlineCSSImportant(t *testing.T) {
+	inlined, err := InlineCSS(`<style>b { color: red !important }</style><b style="color: blue">Hi</b>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(inlined, `color: red !important`) {
+		t.Errorf("The important declaration was overridden: %s", inlined)
+	}
+}
+
+func TestHTMLToText(t *testing.T) {
+	source := `<html><head><title>Title</title><style>p { color: red }</style></head><body>
+		<!--[if mso]><table><![endif]-->
+		<h1>Welcome</h1>
+		<p>Hello   <b>Jane</b>,<br/>your account is ready.</p>
+		<ul><li>First</li><li>Second</li></ul>
+		<p>Open <a href="https://example.com/start">the dashboard</a> or visit <a href="https://example.com">https://example.com</a></p>
+	</body></html>`
+
+	text, err := HTMLToText(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "Welcome\n\nHello Jane,\nyour account is ready.\n\n- First\n- Second\n\n" +
+		"Open the dashboard (https://example.com/start) or visit https://example.com"
+	if text != expected {
+		t.Errorf("Unexpected text:\n%q\nexpected:\n%q", text, expected)
+	}
+}
+
+func TestInlineCSSSemicolonsInValues(t *testing.T) {
+	inlined, err := InlineCSS(`<style>b { background: url(data:image/png;base64,AAAA) no-repeat; font-family: 'A;B', serif }</style><b>Hi</b>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		`background: url(data:image/png;base64,AAAA) no-repeat;`,
+		`font-family: &#39;A;B&#39;, serif;`,
+	}
+	for _, fragment := range expected {
+		if !strings.Contains(inlined, fragment) {
+			t.Errorf("The inlined document doesn't contain %s:\n%s", fragment, inlined)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package htmlmail
+
+import (
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// blockElements start on a new line in the plain text
+var blockElements = map[string]bool{
+	"address": true, "article": true, "div": true, "footer": true, "header": true,
+	"hr": true, "section": true, "tr": true,
+}
+
+// paragraphElements are separated from the surrounding text by an empty line
+var paragraphElements = map[string]bool{
+	"p": true, "blockquote": true, "pre": true, "table": true, "ul": true, "ol": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+}
+
+// skippedElements have no visible text
+var skippedElements = map[string]bool{
+	"head": true, "script": true, "style": true, "title": true,
+}
+
+var spacesPattern = regexp.MustCompile(`[ \t\r\f]+`)
+var blankLinesPattern = regexp.MustCompile(`\n{3,}`)
+
+func writeText(builder *strings.Builder, node *html.Node) {
+	switch node.Type {
+	case html.TextNode:
+		builder.WriteString(whitespacePattern.ReplaceAllString(node.Data, " "))
+		return
+	case html.CommentNode, html.DoctypeNode:
+		return
+	case html.ElementNode:
+		if skippedElements[node.Data] {
+			return
+		}
+	}
+
+	switch {
+	case node.Type != html.ElementNode:
+	case node.Data == "br":
+		builder.WriteString("\n")
+	case node.Data == "li":
+		builder.WriteString("\n- ")
+	case paragraphElements[node.Data]:
+		builder.WriteString("\n\n")
+	case blockElements[node.Data]:
+		builder.WriteString("\n")
+	}
+
+	start := builder.Len()
+	for child := node.FirstChild; child != nil; child = child.NextSibling {
+		writeText(builder, child)
+	}
+
+	if node.Type != html.ElementNode {
+		return
+	}
+	switch {
+	case node.Data == "a":
+		// links show their destination unless the text already does
+		href, _ := attribute(node, "href")
+		text := strings.TrimSpace(builder.String()[start:])
+		if href != "" && !strings.HasPrefix(href, "#") && text != href && text != strings.TrimPrefix(href, "mailto:") {
+			builder.WriteString(" (" + href + ")")
+		}
+	case node.Data == "td" || node.Data == "th":
+		builder.WriteString(" ")
+	case paragraphElements[node.Data]:
+		builder.WriteString("\n\n")
+	case blockElements[node.Data]:
+		builder.WriteString("\n")
+	}
+}
+
+// HTMLToText generates the plain text alternative of an HTML email: the
+// text of the elements is kept with blocks on separate lines, links are
+// followed by their destination and list items are prefixed by a dash
+func HTMLToText(source string) (string, error) {
+	body := &html.Node{Type: html.ElementNode, Data: "body", DataAtom: atom.Body}
+	var nodes []*html.Node
+	var err error
+	if strings.Contains(strings.ToLower(source), "<html") {
+		var document *html.Node
+		document, err = html.Parse(strings.NewReader(source))
+		nodes = []*html.Node{document}
+	} else {
+		nodes, err = html.ParseFragment(strings.NewReader(source), body)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	builder := new(strings.Builder)
+	for _, node := range nodes {
+		writeText(builder, node)
+	}
+
+	lines := strings.Split(builder.String(), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(spacesPattern.ReplaceAllString(line, " "))
+	}
+	text := blankLinesPattern.ReplaceAllString(strings.Join(lines, "\n"), "\n\n")
+
+	return strings.TrimSpace(text), nil
+}
@@ -0,0 +1,159 @@
+package mailer
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CheckStep is the result of a step of the SMTP connectivity check
+type CheckStep struct {
+	Name     string
+	Ok       bool
+	Skipped  bool
+	Detail   string
+	Duration time.Duration
+}
+
+// SMTPDiagnostic is the result of the SMTP connectivity check, the steps
+// after the first failing one are not executed
+type SMTPDiagnostic struct {
+	Ok    bool
+	Steps []CheckStep
+}
+
+func (d *SMTPDiagnostic) run(name string, step func() (string, error)) bool {
+	start := time.Now()
+	detail, err := step()
+	result := CheckStep{Name: name, Ok: err == nil, Detail: detail, Duration: time.Since(start)}
+	if err != nil {
+		result.Detail = err.Error()
+		d.Ok = false
+	}
+	d.Steps = append(d.Steps, result)
+
+	return err == nil
+}
+
+func (d *SMTPDiagnostic) skip(name string, detail string) {
+	d.Steps = append(d.Steps, CheckStep{Name: name, Ok: true, Skipped: true, Detail: detail})
+}
+
+// loginAuth implements the LOGIN authentication mechanism, which is not
+// provided by net/smtp
+type loginAuth struct {
+	username string
+	password string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS {
+		return "", nil, errors.New("unencrypted connection")
+	}
+
+	return "LOGIN", []byte{}, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+
+	switch strings.ToLower(strings.TrimSpace(string(fromServer))) {
+	case "username:":
+		return []byte(a.username), nil
+	case "password:":
+		return []byte(a.password), nil
+	}
+
+	return nil, fmt.Errorf("unexpected server challenge: %s", fromServer)
+}
+
+// CheckSMTP connects to the SMTP server and checks that the connection can be
+// secured and that the credentials are accepted, without sending any email
+func CheckSMTP(settings SMTPSettings, timeout time.Duration) SMTPDiagnostic {
+	diagnostic := SMTPDiagnostic{Ok: true}
+	address := net.JoinHostPort(settings.Server, strconv.Itoa(settings.Port))
+	tlsConfig := &tls.Config{ServerName: settings.Server}
+	implicitTLS := settings.Port == 465
+
+	var conn net.Conn
+	ok := diagnostic.run("connect", func() (string, error) {
+		var err error
+		if implicitTLS {
+			conn, err = tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", address, tlsConfig)
+		} else {
+			conn, err = net.DialTimeout("tcp", address, timeout)
+		}
+		if err != nil {
+			return "", err
+		}
+
+		return "Connected to " + address, conn.SetDeadline(time.Now().Add(timeout))
+	})
+	if !ok {
+		return diagnostic
+	}
+	defer conn.Close()
+
+	var client *smtp.Client
+	ok = diagnostic.run("ehlo", func() (string, error) {
+		var err error
+		client, err = smtp.NewClient(conn, settings.Server)
+		if err != nil {
+			return "", err
+		}
+
+		return "The server greeted the client", client.Hello("localhost")
+	})
+	if !ok {
+		return diagnostic
+	}
+	defer client.Quit()
+
+	if implicitTLS {
+		diagnostic.skip("starttls", "The connection uses implicit TLS")
+	} else if supported, _ := client.Extension("STARTTLS"); !supported {
+		diagnostic.skip("starttls", "The server does not support STARTTLS, the connection is not encrypted")
+	} else {
+		ok = diagnostic.run("starttls", func() (string, error) {
+			return "The connection is encrypted", client.StartTLS(tlsConfig)
+		})
+		if !ok {
+			return diagnostic
+		}
+	}
+
+	if settings.Username == "" {
+		diagnostic.skip("auth", "No username is configured")
+		return diagnostic
+	}
+	diagnostic.run("auth", func() (string, error) {
+		supported, mechanisms := client.Extension("AUTH")
+		if !supported {
+			return "", fmt.Errorf("The server does not support authentication")
+		}
+
+		var auth smtp.Auth
+		var mechanism string
+		switch {
+		case strings.Contains(mechanisms, "CRAM-MD5"):
+			auth, mechanism = smtp.CRAMMD5Auth(settings.Username, settings.Password), "CRAM-MD5"
+		case strings.Contains(mechanisms, "PLAIN"):
+			auth, mechanism = smtp.PlainAuth("", settings.Username, settings.Password, settings.Server), "PLAIN"
+		case strings.Contains(mechanisms, "LOGIN"):
+			auth, mechanism = &loginAuth{settings.Username, settings.Password}, "LOGIN"
+		default:
+			return "", fmt.Errorf("The server offers no supported authentication mechanism: %s", mechanisms)
+		}
+
+		return "Authenticated with " + mechanism, client.Auth(auth)
+	})
+
+	return diagnostic
+}
@@ -0,0 +1,59 @@
+package mailer
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func stepNames(diagnostic SMTPDiagnostic) map[string]CheckStep {
+	steps := map[string]CheckStep{}
+	for _, step := range diagnostic.Steps {
+		steps[step.Name] = step
+	}
+
+	return steps
+}
+
+func TestCheckSMTP(t *testing.T) {
+	server := newFakeSMTPServer(t)
+	server.Username = "user"
+	server.Password = "secret"
+
+	t.Run("Valid settings", func(t *testing.T) {
+		diagnostic := CheckSMTP(SMTPSettings{"127.0.0.1", server.Port, "user", "secret"}, time.Second)
+		if !diagnostic.Ok {
+			t.Fatalf("The check failed: %+v", diagnostic.Steps)
+		}
+
+		steps := stepNames(diagnostic)
+		if !steps["starttls"].Skipped {
+			t.Error("STARTTLS should be skipped when the server doesn't support it")
+		}
+		if !steps["auth"].Ok || steps["auth"].Skipped {
+			t.Errorf("The authentication step should succeed: %+v", steps["auth"])
+		}
+	})
+	t.Run("Wrong credentials", func(t *testing.T) {
+		diagnostic := CheckSMTP(SMTPSettings{"127.0.0.1", server.Port, "user", "wrong"}, time.Second)
+		if diagnostic.Ok {
+			t.Fatal("The check succeeded with wrong credentials")
+		}
+		if stepNames(diagnostic)["auth"].Ok {
+			t.Error("The authentication step should fail")
+		}
+	})
+	t.Run("Unreachable server", func(t *testing.T) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			panic(err)
+		}
+		port := listener.Addr().(*net.TCPAddr).Port
+		listener.Close()
+
+		diagnostic := CheckSMTP(SMTPSettings{Server: "127.0.0.1", Port: port}, time.Second)
+		if diagnostic.Ok || len(diagnostic.Steps) != 1 || diagnostic.Steps[0].Name != "connect" {
+			t.Errorf("Only the connect step should run and fail: %+v", diagnostic.Steps)
+		}
+	})
+}
@@ -0,0 +1,126 @@
+package mailer
+
+import (
+	"bufio"
+	"encoding/base64"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type receivedMessage struct {
+	From string
+	To   []string
+	Data string
+}
+
+// fakeSMTPServer is a minimal SMTP server used to test the delivery of emails
+// without a real mail server
+type fakeSMTPServer struct {
+	listener net.Listener
+	Port     int
+
+	mutex    sync.Mutex
+	messages []receivedMessage
+
+	// RcptReply is the reply sent to the RCPT command
+	RcptReply string
+	// Username and Password enable the PLAIN authentication if not empty
+	Username string
+	Password string
+}
+
+func newFakeSMTPServer(t *testing.T) *fakeSMTPServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &fakeSMTPServer{
+		listener:  listener,
+		Port:      listener.Addr().(*net.TCPAddr).Port,
+		RcptReply: "250 OK",
+	}
+	go server.serve()
+	t.Cleanup(func() { listener.Close() })
+
+	return server
+}
+
+func (s *fakeSMTPServer) Messages() []receivedMessage {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return append([]receivedMessage{}, s.messages...)
+}
+
+func (s *fakeSMTPServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *fakeSMTPServer) handle(conn net.Conn) {
+	defer conn.Close()
+	reader := textproto.NewReader(bufio.NewReader(conn))
+	writer := textproto.NewWriter(bufio.NewWriter(conn))
+
+	writer.PrintfLine("220 fake ESMTP ready")
+	message := receivedMessage{}
+	for {
+		line, err := reader.ReadLine()
+		if err != nil {
+			return
+		}
+		command := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+
+		switch command {
+		case "EHLO":
+			if s.Username != "" {
+				writer.PrintfLine("250-fake")
+				writer.PrintfLine("250 AUTH PLAIN")
+			} else {
+				writer.PrintfLine("250 fake")
+			}
+		case "HELO", "NOOP", "RSET":
+			writer.PrintfLine("250 OK")
+		case "AUTH":
+			parts := strings.Fields(line)
+			credentials, _ := base64.StdEncoding.DecodeString(parts[len(parts)-1])
+			if string(credentials) == "\x00"+s.Username+"\x00"+s.Password {
+				writer.PrintfLine("235 Authentication successful")
+			} else {
+				writer.PrintfLine("535 Authentication failed")
+			}
+		case "MAIL":
+			message = receivedMessage{From: strings.Trim(line[strings.Index(line, ":")+1:], "<> ")}
+			writer.PrintfLine("250 OK")
+		case "RCPT":
+			message.To = append(message.To, strings.Trim(line[strings.Index(line, ":")+1:], "<> "))
+			writer.PrintfLine("%s", s.RcptReply)
+		case "DATA":
+			writer.PrintfLine("354 Send the message")
+			data, err := reader.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			message.Data = string(data)
+
+			s.mutex.Lock()
+			s.messages = append(s.messages, message)
+			s.mutex.Unlock()
+			writer.PrintfLine("250 Queued")
+		case "QUIT":
+			writer.PrintfLine("221 Bye")
+			return
+		default:
+			writer.PrintfLine("502 Command not implemented")
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package mailer
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FileSender writes each message as an .eml file in a directory, it is meant
+// for development and tests
+type FileSender struct {
+	Directory string
+}
+
+func (s *FileSender) Send(message Message) error {
+	if _, _, err := message.envelope(); err != nil {
+		return err
+	}
+
+	err := os.MkdirAll(s.Directory, 0755)
+	if err != nil {
+		return err
+	}
+
+	suffix := make([]byte, 4)
+	_, err = rand.Read(suffix)
+	if err != nil {
+		return err
+	}
+	fileName := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405.000000000"), hex.EncodeToString(suffix))
+
+	file, err := os.OpenFile(filepath.Join(s.Directory, fileName), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = message.toGomail().WriteTo(file)
+	return err
+}
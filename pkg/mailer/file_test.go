@@ -0,0 +1,45 @@
+package mailer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileSender(t *testing.T) {
+	directory, err := ioutil.TempDir("", "mailer")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(directory)
+
+	sender := FileSender{Directory: filepath.Join(directory, "tenant")}
+	err = sender.Send(testMessage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sender.Send(testMessage())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(directory, "tenant", "*.eml"))
+	if err != nil {
+		panic(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("Expected 2 .eml files, found %d", len(files))
+	}
+
+	content, err := ioutil.ReadFile(files[0])
+	if err != nil {
+		panic(err)
+	}
+	for _, expected := range []string{"To: andrea@example.com", "Subject: Password successfully changed", "text/html", "text/plain"} {
+		if !strings.Contains(string(content), expected) {
+			t.Errorf("The .eml file doesn't contain %q", expected)
+		}
+	}
+}
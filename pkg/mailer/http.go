@@ -0,0 +1,79 @@
+package mailer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// HTTPSender delivers the messages by posting them as JSON to the API of an
+// email provider
+type HTTPSender struct {
+	URL string
+	// Headers are added to each request, e.g. the Authorization header
+	Headers map[string]string
+	// Client is used to send the requests, if nil a client with a 10 seconds
+	// timeout is used
+	Client *http.Client
+}
+
+type httpMessage struct {
+	From    string `json:"from"`
+	ReplyTo string `json:"replyTo,omitempty"`
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+	HTML    string `json:"html,omitempty"`
+	Text    string `json:"text,omitempty"`
+}
+
+func (s *HTTPSender) Send(message Message) error {
+	body, err := json.Marshal(httpMessage{
+		From:    message.From,
+		ReplyTo: message.ReplyTo,
+		To:      message.To,
+		Subject: message.Subject,
+		HTML:    message.HTML,
+		Text:    message.Text,
+	})
+	if err != nil {
+		return PermanentError{err}
+	}
+
+	request, err := http.NewRequest("POST", s.URL, bytes.NewReader(body))
+	if err != nil {
+		return PermanentError{err}
+	}
+	request.Header.Set("Content-Type", "application/json")
+	for key, value := range s.Headers {
+		request.Header.Set(key, value)
+	}
+
+	client := s.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		return nil
+	}
+
+	responseBody, _ := ioutil.ReadAll(io.LimitReader(response.Body, 512))
+	err = fmt.Errorf("The email API replied with status %d: %s", response.StatusCode, string(responseBody))
+
+	// client errors won't change on retry, except timeouts and rate limits
+	if response.StatusCode >= 400 && response.StatusCode < 500 &&
+		response.StatusCode != http.StatusRequestTimeout && response.StatusCode != http.StatusTooManyRequests {
+		return PermanentError{err}
+	}
+
+	return err
+}
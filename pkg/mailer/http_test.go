@@ -0,0 +1,57 @@
+package mailer
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHTTPSender(t *testing.T) {
+	var received httpMessage
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		json.NewDecoder(r.Body).Decode(&received)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	sender := HTTPSender{URL: server.URL, Headers: map[string]string{"Authorization": "Bearer key"}}
+
+	t.Run("Delivered", func(t *testing.T) {
+		err := sender.Send(testMessage())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if received.To != "andrea@example.com" || received.Subject != "Password successfully changed" {
+			t.Errorf("The API received a wrong message %+v", received)
+		}
+	})
+	t.Run("Server error", func(t *testing.T) {
+		status = http.StatusBadGateway
+		err := sender.Send(testMessage())
+		if err == nil || IsPermanent(err) {
+			t.Errorf("A 502 reply should be a temporary error, got %v", err)
+		}
+	})
+	t.Run("Rate limited", func(t *testing.T) {
+		status = http.StatusTooManyRequests
+		err := sender.Send(testMessage())
+		if err == nil || IsPermanent(err) {
+			t.Errorf("A 429 reply should be a temporary error, got %v", err)
+		}
+	})
+	t.Run("Unauthorized", func(t *testing.T) {
+		status = http.StatusOK
+		unauthorized := HTTPSender{URL: server.URL}
+		err := unauthorized.Send(testMessage())
+		if err == nil || !IsPermanent(err) {
+			t.Errorf("A 401 reply should be a permanent error, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,106 @@
+package mailer
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/textproto"
+	"time"
+
+	gomail "gopkg.in/gomail.v2"
+)
+
+// Message is a transactional email with an HTML body and its plain text
+// alternative
+type Message struct {
+	From    string
+	ReplyTo string
+	To      string
+	Subject string
+	HTML    string
+	Text    string
+}
+
+// toGomail converts the message to a MIME message
+func (m Message) toGomail() *gomail.Message {
+	message := gomail.NewMessage()
+	message.SetHeader("From", m.From)
+	message.SetHeader("To", m.To)
+	if m.ReplyTo != "" {
+		message.SetHeader("Reply-To", m.ReplyTo)
+	}
+	message.SetHeader("Subject", m.Subject)
+
+	if m.HTML != "" {
+		message.SetBody("text/html", m.HTML)
+		if m.Text != "" {
+			message.AddAlternative("text/plain", m.Text)
+		}
+	} else {
+		message.SetBody("text/plain", m.Text)
+	}
+
+	return message
+}
+
+// envelope returns the bare addresses of the sender and of the recipient
+func (m Message) envelope() (string, string, error) {
+	from, err := mail.ParseAddress(m.From)
+	if err != nil {
+		return "", "", PermanentError{fmt.Errorf("The sender address %q is invalid", m.From)}
+	}
+	to, err := mail.ParseAddress(m.To)
+	if err != nil {
+		return "", "", PermanentError{fmt.Errorf("The recipient address %q is invalid", m.To)}
+	}
+
+	return from.Address, to.Address, nil
+}
+
+// PermanentError marks a failure that will not be solved by retrying
+type PermanentError struct {
+	Err error
+}
+
+func (e PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+func (e PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// IsPermanent checks whether the error returned when sending an email is
+// permanent, i.e. an SMTP 5xx reply or an explicit PermanentError
+func IsPermanent(err error) bool {
+	var permanentError PermanentError
+	if errors.As(err, &permanentError) {
+		return true
+	}
+
+	var protocolError *textproto.Error
+	if errors.As(err, &protocolError) {
+		return protocolError.Code >= 500 && protocolError.Code < 600
+	}
+
+	return false
+}
+
+// Backoff returns how long to wait before retrying a delivery that failed
+// for the passed number of times, the delay doubles at each attempt
+func Backoff(attempts int) time.Duration {
+	const (
+		base    = 30 * time.Second
+		maximum = 6 * time.Hour
+	)
+
+	delay := base
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= maximum {
+			return maximum
+		}
+	}
+
+	return delay
+}
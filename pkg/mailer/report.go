@@ -0,0 +1,168 @@
+package mailer
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"net/textproto"
+	"strings"
+)
+
+// Kinds of delivery notifications
+const (
+	HardBounce = "hard-bounce"
+	SoftBounce = "soft-bounce"
+	Complaint  = "complaint"
+)
+
+// Notification is a bounce or a complaint about an email sent to Recipient
+type Notification struct {
+	Kind      string
+	Recipient string
+	// Status is the RFC 3463 status code of a bounce, e.g. 5.1.1
+	Status     string
+	Diagnostic string
+}
+
+// ParseReport parses a delivery status notification (RFC 3464) or an abuse
+// feedback report (RFC 5965) and returns a notification for each recipient.
+// Delivery notifications of successful or delayed deliveries are ignored
+func ParseReport(r io.Reader) ([]Notification, error) {
+	message, err := mail.ReadMessage(r)
+	if err != nil {
+		return nil, err
+	}
+
+	mediaType, params, err := mime.ParseMediaType(message.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, err
+	} else if mediaType != "multipart/report" {
+		return nil, fmt.Errorf("The message is not a report but %s", mediaType)
+	}
+
+	parts := multipart.NewReader(message.Body, params["boundary"])
+	var notifications []Notification
+	var feedback textproto.MIMEHeader
+	var originalRecipient string
+	for {
+		part, err := parts.NextPart()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		switch partType {
+		case "message/delivery-status":
+			notifications, err = parseDeliveryStatus(part)
+			if err != nil {
+				return nil, err
+			}
+		case "message/feedback-report":
+			feedback, err = textproto.NewReader(bufio.NewReader(part)).ReadMIMEHeader()
+			if err != nil && err != io.EOF {
+				return nil, err
+			}
+		case "message/rfc822", "text/rfc822-headers":
+			original, err := textproto.NewReader(bufio.NewReader(part)).ReadMIMEHeader()
+			if err != nil && err != io.EOF {
+				return nil, err
+			}
+			originalRecipient = original.Get("To")
+		}
+	}
+
+	if params["report-type"] == "feedback-report" {
+		if feedback == nil {
+			return nil, fmt.Errorf("The feedback report is missing")
+		}
+
+		recipient := feedback.Get("Original-Rcpt-To")
+		if recipient == "" {
+			recipient = originalRecipient
+		}
+		address, err := mail.ParseAddress(recipient)
+		if err != nil {
+			return nil, fmt.Errorf("The feedback report doesn't contain a valid recipient")
+		}
+
+		return []Notification{{
+			Kind:       Complaint,
+			Recipient:  address.Address,
+			Diagnostic: feedback.Get("Feedback-Type"),
+		}}, nil
+	}
+
+	return notifications, nil
+}
+
+// parseDeliveryStatus parses the per-message fields and the per-recipient
+// fields of a delivery status, each group is separated by an empty line
+func parseDeliveryStatus(r io.Reader) ([]Notification, error) {
+	reader := textproto.NewReader(bufio.NewReader(r))
+	// the per-message fields are not needed
+	_, err := reader.ReadMIMEHeader()
+	if err == io.EOF {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	notifications := []Notification{}
+	for {
+		fields, err := reader.ReadMIMEHeader()
+		if len(fields) > 0 {
+			notification, ok := deliveryNotification(fields)
+			if ok {
+				notifications = append(notifications, notification)
+			}
+		}
+
+		if err == io.EOF {
+			return notifications, nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func deliveryNotification(fields textproto.MIMEHeader) (Notification, bool) {
+	if strings.ToLower(strings.TrimSpace(fields.Get("Action"))) != "failed" {
+		return Notification{}, false
+	}
+
+	recipient := fields.Get("Original-Recipient")
+	if final := fields.Get("Final-Recipient"); final != "" {
+		recipient = final
+	}
+	// the address is prefixed by its type, e.g. "rfc822; jane@example.com"
+	if separator := strings.Index(recipient, ";"); separator >= 0 {
+		recipient = recipient[separator+1:]
+	}
+	recipient = strings.TrimSpace(recipient)
+	if recipient == "" {
+		return Notification{}, false
+	}
+
+	status := strings.TrimSpace(fields.Get("Status"))
+	kind := HardBounce
+	if strings.HasPrefix(status, "4") {
+		kind = SoftBounce
+	}
+
+	diagnostic := fields.Get("Diagnostic-Code")
+	if separator := strings.Index(diagnostic, ";"); separator >= 0 {
+		diagnostic = strings.TrimSpace(diagnostic[separator+1:])
+	}
+
+	return Notification{
+		Kind:       kind,
+		Recipient:  recipient,
+		Status:     status,
+		Diagnostic: diagnostic,
+	}, true
+}
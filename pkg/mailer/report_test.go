@@ -0,0 +1,63 @@
+package mailer
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+func parseReportFile(t *testing.T, path string) []Notification {
+	file, err := os.Open(path)
+	if err != nil {
+		panic(err)
+	}
+	defer file.Close()
+
+	notifications, err := ParseReport(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return notifications
+}
+
+func TestParseDeliveryStatus(t *testing.T) {
+	notifications := parseReportFile(t, "testdata/bounce.eml")
+	if len(notifications) != 2 {
+		t.Fatalf("Expected 2 notifications, the delayed delivery should be ignored: %+v", notifications)
+	}
+
+	hard := notifications[0]
+	if hard.Kind != HardBounce || hard.Recipient != "missing@example.org" || hard.Status != "5.1.1" {
+		t.Errorf("Wrong hard bounce: %+v", hard)
+	}
+	if !strings.Contains(hard.Diagnostic, "User unknown") {
+		t.Errorf("The folded diagnostic code was not parsed: %q", hard.Diagnostic)
+	}
+
+	soft := notifications[1]
+	if soft.Kind != SoftBounce || soft.Recipient != "full@example.org" {
+		t.Errorf("Wrong soft bounce: %+v", soft)
+	}
+}
+
+func TestParseFeedbackReport(t *testing.T) {
+	notifications := parseReportFile(t, "testdata/complaint.eml")
+	if len(notifications) != 1 {
+		t.Fatalf("Expected 1 notification, got %+v", notifications)
+	}
+
+	// without Original-Rcpt-To the recipient is taken from the original message
+	complaint := notifications[0]
+	if complaint.Kind != Complaint || complaint.Recipient != "jane@example.net" || complaint.Diagnostic != "abuse" {
+		t.Errorf("Wrong complaint: %+v", complaint)
+	}
+}
+
+func TestParseReportRejectsOtherMessages(t *testing.T) {
+	message := "From: jane@example.net\r\nContent-Type: text/plain\r\n\r\nHello"
+	_, err := ParseReport(strings.NewReader(message))
+	if err == nil {
+		t.Error("A plain message was parsed as a report")
+	}
+}
@@ -0,0 +1,16 @@
+package mailer
+
+// Sender delivers email messages, the errors that will not be solved by
+// retrying should satisfy IsPermanent
+type Sender interface {
+	Send(message Message) error
+}
+
+// SMTPSender delivers the messages through an SMTP server
+type SMTPSender struct {
+	Settings SMTPSettings
+}
+
+func (s *SMTPSender) Send(message Message) error {
+	return SendSMTP(s.Settings, message)
+}
@@ -0,0 +1,32 @@
+package mailer
+
+import (
+	gomail "gopkg.in/gomail.v2"
+)
+
+// SMTPSettings are the parameters used to connect to an SMTP server
+type SMTPSettings struct {
+	Server   string
+	Port     int
+	Username string
+	Password string
+}
+
+// SendSMTP delivers the message through the SMTP server, unlike
+// gomail.DialAndSend the errors of the server are returned unwrapped so that
+// they can be classified with IsPermanent
+func SendSMTP(settings SMTPSettings, message Message) error {
+	from, to, err := message.envelope()
+	if err != nil {
+		return err
+	}
+
+	dialer := gomail.NewDialer(settings.Server, settings.Port, settings.Username, settings.Password)
+	sender, err := dialer.Dial()
+	if err != nil {
+		return err
+	}
+	defer sender.Close()
+
+	return sender.Send(from, []string{to}, message.toGomail())
+}
@@ -0,0 +1,90 @@
+package mailer
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func testMessage() Message {
+	return Message{
+		From:    "Shipyard <noreply@example.com>",
+		To:      "andrea@example.com",
+		Subject: "Password successfully changed",
+		HTML:    "<p>You just changed your password</p>",
+		Text:    "You just changed your password",
+	}
+}
+
+func TestSendSMTP(t *testing.T) {
+	server := newFakeSMTPServer(t)
+	server.Username = "user"
+	server.Password = "secret"
+
+	t.Run("Delivered", func(t *testing.T) {
+		err := SendSMTP(SMTPSettings{"127.0.0.1", server.Port, "user", "secret"}, testMessage())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		messages := server.Messages()
+		if len(messages) != 1 {
+			t.Fatalf("Expected 1 message, the server received %d", len(messages))
+		}
+		if messages[0].From != "noreply@example.com" || messages[0].To[0] != "andrea@example.com" {
+			t.Errorf("Wrong envelope %+v", messages[0])
+		}
+		if !strings.Contains(messages[0].Data, "Subject: Password successfully changed") {
+			t.Error("The subject is missing from the delivered message")
+		}
+	})
+	t.Run("Wrong credentials", func(t *testing.T) {
+		err := SendSMTP(SMTPSettings{"127.0.0.1", server.Port, "user", "wrong"}, testMessage())
+		if err == nil {
+			t.Fatal("The delivery succeeded with wrong credentials")
+		}
+		if !IsPermanent(err) {
+			t.Errorf("A 535 reply should be a permanent error: %s", err)
+		}
+	})
+}
+
+func TestSendSMTPRejected(t *testing.T) {
+	server := newFakeSMTPServer(t)
+
+	t.Run("Permanent rejection", func(t *testing.T) {
+		server.RcptReply = "550 No such user"
+		err := SendSMTP(SMTPSettings{Server: "127.0.0.1", Port: server.Port}, testMessage())
+		if err == nil || !IsPermanent(err) {
+			t.Errorf("A 550 reply should be a permanent error, got %v", err)
+		}
+	})
+	t.Run("Temporary rejection", func(t *testing.T) {
+		server.RcptReply = "451 Try again later"
+		err := SendSMTP(SMTPSettings{Server: "127.0.0.1", Port: server.Port}, testMessage())
+		if err == nil || IsPermanent(err) {
+			t.Errorf("A 451 reply should be a temporary error, got %v", err)
+		}
+	})
+	t.Run("Invalid recipient", func(t *testing.T) {
+		message := testMessage()
+		message.To = "not an address"
+		err := SendSMTP(SMTPSettings{Server: "127.0.0.1", Port: server.Port}, message)
+		if err == nil || !IsPermanent(err) {
+			t.Errorf("An invalid address should be a permanent error, got %v", err)
+		}
+	})
+}
+
+func TestBackoff(t *testing.T) {
+	expected := []time.Duration{30 * time.Second, time.Minute, 2 * time.Minute, 4 * time.Minute}
+	for i, delay := range expected {
+		if Backoff(i+1) != delay {
+			t.Errorf("Expected a delay of %s after %d attempts, got %s", delay, i+1, Backoff(i+1))
+		}
+	}
+
+	if Backoff(100) != 6*time.Hour {
+		t.Errorf("The delay should be capped at 6 hours, got %s", Backoff(100))
+	}
+}
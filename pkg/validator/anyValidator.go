@@ -1,7 +1,10 @@
 package validator
 
+import "encoding/json"
+
 type AnyValidator struct {
 	Required bool
+	Default  json.RawMessage // materialized when the field is missing
 }
 
 func (v *AnyValidator) Type() string {
@@ -21,7 +24,7 @@ func (v *AnyValidator) ValidatePatch(patch Patch, position string) error {
 		switch patch.op {
 		case "remove":
 			if v.IsRequired() {
-				return ValidationError{"Cannot remove a required field", position}
+				return ValidationError{Path: position, Code: "required", Message: "Cannot remove a required field"}
 			}
 
 			return nil
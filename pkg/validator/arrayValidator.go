@@ -10,6 +10,8 @@ type ArrayValidator struct {
 	elementsValidator FieldValidator
 	Elements          json.RawMessage
 	Required          bool
+	Nullable          bool
+	Default           json.RawMessage // materialized when the field is missing
 	MaxElements       *int
 	MinElements       *int
 }
@@ -23,10 +25,14 @@ func (v *ArrayValidator) IsRequired() bool {
 }
 
 func (v *ArrayValidator) Validate(json interface{}, position string) error {
+	if json == nil && v.Nullable {
+		return nil
+	}
+
 	jsonArray, ok := json.([]interface{})
 
 	if !ok {
-		return ValidationError{"This field is not an array", position}
+		return ValidationError{Path: position, Code: "type", Params: map[string]interface{}{"type": "array"}, Message: "This field is not an array"}
 	}
 
 	compositeError := CompositeValidationError{}
@@ -36,22 +42,26 @@ func (v *ArrayValidator) Validate(json interface{}, position string) error {
 		fieldError := v.elementsValidator.Validate(value, fieldPosition)
 
 		if fieldError != nil {
-			compositeError.errors = append(compositeError.errors, fieldError)
+			compositeError.add(fieldError)
 			returnError = true
 		}
 	}
 
 	if v.MaxElements != nil && len(jsonArray) > *v.MaxElements {
-		compositeError.errors = append(compositeError.errors, ValidationError{
-			fmt.Sprintf("This array can contain at most %d elements", *v.MaxElements),
-			position,
+		compositeError.add(ValidationError{
+			Path:    position,
+			Code:    "maxElements",
+			Params:  map[string]interface{}{"maxElements": *v.MaxElements},
+			Message: fmt.Sprintf("This array can contain at most %d elements", *v.MaxElements),
 		})
 		returnError = true
 	}
 	if v.MinElements != nil && len(jsonArray) < *v.MinElements {
-		compositeError.errors = append(compositeError.errors, ValidationError{
-			fmt.Sprintf("This array must contain at least %d elements", *v.MinElements),
-			position,
+		compositeError.add(ValidationError{
+			Path:    position,
+			Code:    "minElements",
+			Params:  map[string]interface{}{"minElements": *v.MinElements},
+			Message: fmt.Sprintf("This array must contain at least %d elements", *v.MinElements),
 		})
 		returnError = true
 	}
@@ -68,7 +78,7 @@ func (v *ArrayValidator) ValidatePatch(patch Patch, position string) error {
 		switch patch.op {
 		case "remove":
 			if v.IsRequired() {
-				return ValidationError{"Cannot remove a required field", position}
+				return ValidationError{Path: position, Code: "required", Message: "Cannot remove a required field"}
 			}
 
 			return nil
@@ -77,21 +87,51 @@ func (v *ArrayValidator) ValidatePatch(patch Patch, position string) error {
 		}
 	}
 
+	current, hasCurrent := patch.document.([]interface{})
+	hasCurrent = hasCurrent && patch.hasDocument
+
 	field, err := patch.UnshiftPosition()
 	if err != nil {
-		return ValidationError{err.Error(), position}
+		return ValidationError{Path: position, Code: "invalidPath", Message: err.Error()}
 	}
 
 	// Check that the path specifies an array element
-	if field != "-" {
-		value, err := strconv.Atoi(field)
-		if err != nil {
-			return ValidationError{"Array position is invalid, it should be either - or a number", position}
+	index := -1
+	if field == "-" {
+		if patch.op != "add" || !patch.IsRootPosition() {
+			return ValidationError{Path: position, Code: "invalidPath", Message: "The position - can only be used to append elements"}
+		}
+	} else {
+		index, err = strconv.Atoi(field)
+		if err != nil || index < 0 {
+			return ValidationError{Path: position, Code: "invalidPath", Message: "Array position is invalid, it should be either - or a number"}
+		}
+
+		if v.MaxElements != nil && *v.MaxElements <= index {
+			return ValidationError{Path: position + "/" + field, Code: "maxElements", Params: map[string]interface{}{"maxElements": *v.MaxElements}, Message: fmt.Sprintf("This array can contain at most %d elements", *v.MaxElements)}
+		}
+	}
+
+	// The length of the array after appends, inserts and removals can be
+	// checked only if the current array is known
+	if hasCurrent && patch.IsRootPosition() {
+		length := len(current)
+		if index > length || (index == length && patch.op != "add") {
+			return ValidationError{Path: position, Code: "invalidPath", Message: fmt.Sprintf("The position %s is outside the array", field)}
+		}
+
+		switch patch.op {
+		case "add":
+			length++
+		case "remove":
+			length--
 		}
 
-		// FIXME: the length limit can be bypassed by using the position -
-		if v.MaxElements != nil && *v.MaxElements <= value {
-			return ValidationError{fmt.Sprintf("This array can contain at most %d elements", *v.MaxElements), position + "/" + field}
+		if v.MaxElements != nil && length > *v.MaxElements {
+			return ValidationError{Path: position, Code: "maxElements", Params: map[string]interface{}{"maxElements": *v.MaxElements}, Message: fmt.Sprintf("This array can contain at most %d elements", *v.MaxElements)}
+		}
+		if v.MinElements != nil && length < *v.MinElements {
+			return ValidationError{Path: position, Code: "minElements", Params: map[string]interface{}{"minElements": *v.MinElements}, Message: fmt.Sprintf("This array must contain at least %d elements", *v.MinElements)}
 		}
 	}
 
@@ -0,0 +1,96 @@
+package validator
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const benchmarkValidator = `{
+	"type": "object",
+	"fields": {
+		"name": {"type": "string", "required": true},
+		"decks": {
+			"type": "array",
+			"elements": {
+				"type": "object",
+				"fields": {
+					"id": {"type": "string", "regexMatch": "^[a-f0-9]{24}$", "required": true},
+					"name": {"type": "string", "maxChars": 50, "noRegexMatch": "^\\s"},
+					"language": {"type": "string", "allowedValues": ["en", "it", "de", "fr", "es"]},
+					"repetitionCount": {"type": "integer", "min": 0},
+					"score": {"type": "float", "min": 0, "max": 1},
+					"cards": {
+						"type": "map",
+						"keys": {"type": "string", "regexMatch": "^c[0-9]+$"},
+						"values": {"type": "boolean"}
+					}
+				}
+			}
+		}
+	}
+}`
+
+// largeDocument returns a document with the passed number of decks
+func largeDocument(decks int) []byte {
+	var builder strings.Builder
+	builder.WriteString(`{"name": "Andrea", "decks": [`)
+	for i := 0; i < decks; i++ {
+		if i > 0 {
+			builder.WriteString(",")
+		}
+		fmt.Fprintf(&builder, `{
+			"id": "%024x",
+			"name": "Deck %d",
+			"language": "it",
+			"repetitionCount": %d,
+			"score": 0.5,
+			"cards": {"c1": true, "c2": false, "c3": true}
+		}`, i, i, i)
+	}
+	builder.WriteString("]}")
+
+	return []byte(builder.String())
+}
+
+func loadBenchmarkValidator(b *testing.B) *Validator {
+	var v Validator
+	err := json.Unmarshal([]byte(benchmarkValidator), &v)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	return &v
+}
+
+func BenchmarkValidateLargeDocument(b *testing.B) {
+	v := loadBenchmarkValidator(b)
+	document := largeDocument(10000)
+
+	b.SetBytes(int64(len(document)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := v.Validate(document); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkValidatePatchesLargeDocument(b *testing.B) {
+	v := loadBenchmarkValidator(b)
+	document := largeDocument(10000)
+	patches := []byte(`[
+		{"op": "replace", "path": "/decks/5000/name", "value": "Renamed"},
+		{"op": "add", "path": "/decks/-", "value": {"id": "ffffffffffffffffffffffff"}},
+		{"op": "add", "path": "/decks/0/cards/c4", "value": true}
+	]`)
+
+	b.SetBytes(int64(len(document)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := v.ValidatePatchesWithDocument(document, patches); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
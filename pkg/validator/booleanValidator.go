@@ -0,0 +1,51 @@
+package validator
+
+import "encoding/json"
+
+type BooleanValidator struct {
+	Required bool
+	Nullable bool
+	Default  json.RawMessage // materialized when the field is missing
+	Coerce   bool            // converts the strings "true" and "false" to booleans when normalizing
+}
+
+func (v *BooleanValidator) Type() string {
+	return "boolean"
+}
+
+func (v *BooleanValidator) IsRequired() bool {
+	return v.Required
+}
+
+func (v *BooleanValidator) Validate(json interface{}, position string) error {
+	if json == nil && v.Nullable {
+		return nil
+	}
+
+	if _, ok := json.(bool); !ok {
+		return ValidationError{Path: position, Code: "type", Params: map[string]interface{}{"type": "boolean"}, Message: "This field is not a boolean"}
+	}
+
+	return nil
+}
+
+func (v *BooleanValidator) ValidatePatch(patch Patch, position string) error {
+	if patch.IsRootPosition() {
+		switch patch.op {
+		case "remove":
+			if v.IsRequired() {
+				return ValidationError{Path: position, Code: "required", Message: "Cannot remove a required field"}
+			}
+
+			return nil
+		default:
+			return v.Validate(patch.value, position)
+		}
+	} else {
+		return ValidationError{Path: position, Code: "invalidPath", Message: "Cannot access a field inside a boolean"}
+	}
+}
+
+func (v *BooleanValidator) InitializeAfterUnmarshaling(customTypes map[string]bool, rootValidator *Validator) error {
+	return nil
+}
@@ -0,0 +1,134 @@
+package validator
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestBooleanValidation(t *testing.T) {
+	const jsonValidator = `{
+		"type": "boolean"
+	}`
+
+	var v Validator
+	err := json.Unmarshal([]byte(jsonValidator), &v)
+	if err != nil {
+		panic(err)
+	}
+
+	t.Run("Valid Boolean", func(t *testing.T) {
+		err = v.Validate([]byte("false"))
+		if err != nil {
+			t.Error(err)
+		}
+	})
+	t.Run("Invalid Boolean (number)", func(t *testing.T) {
+		err = v.Validate([]byte("0"))
+		if err == nil {
+			t.Error("The value 0 should not be recognized as a valid boolean")
+		}
+	})
+	t.Run("Invalid Boolean (string)", func(t *testing.T) {
+		err = v.Validate([]byte(`"true"`))
+		if err == nil {
+			t.Error("The string 'true' should not be recognized as a valid boolean")
+		}
+	})
+}
+
+func TestNullValidation(t *testing.T) {
+	var v Validator
+	err := json.Unmarshal([]byte(`{"type": "null"}`), &v)
+	if err != nil {
+		panic(err)
+	}
+
+	t.Run("Valid Null", func(t *testing.T) {
+		err = v.Validate([]byte("null"))
+		if err != nil {
+			t.Error(err)
+		}
+	})
+	t.Run("Invalid Null", func(t *testing.T) {
+		err = v.Validate([]byte(`""`))
+		if err == nil {
+			t.Error("The empty string should not be recognized as null")
+		}
+	})
+}
+
+func TestNullableValidation(t *testing.T) {
+	const jsonValidator = `{
+		"type": "object",
+		"customTypes": {
+			"tag": {
+				"type": "string"
+			}
+		},
+		"fields": {
+			"name": {
+				"type": "string",
+				"nullable": true
+			},
+			"age": {
+				"type": "integer",
+				"nullable": true
+			},
+			"active": {
+				"type": "boolean",
+				"nullable": true
+			},
+			"tags": {
+				"type": "array",
+				"nullable": true,
+				"elements": {
+					"type": "tag",
+					"nullable": true
+				}
+			},
+			"email": {
+				"type": "string"
+			}
+		}
+	}`
+
+	var v Validator
+	err := json.Unmarshal([]byte(jsonValidator), &v)
+	if err != nil {
+		panic(err)
+	}
+
+	t.Run("Valid Nulls", func(t *testing.T) {
+		err = v.Validate([]byte(`{"name": null, "age": null, "active": null, "tags": null}`))
+		if err != nil {
+			t.Error(err)
+		}
+	})
+	t.Run("Valid Null Custom Type", func(t *testing.T) {
+		err = v.Validate([]byte(`{"tags": ["a", null]}`))
+		if err != nil {
+			t.Error(err)
+		}
+	})
+	t.Run("Invalid Null", func(t *testing.T) {
+		err = v.Validate([]byte(`{"email": null}`))
+		if err == nil {
+			t.Error("null was accepted by a field that is not nullable")
+		}
+	})
+	t.Run("Valid Null Patch", func(t *testing.T) {
+		err = v.ValidatePatches([]byte(`[
+			{ "op": "replace", "path": "/age", "value": null },
+			{ "op": "add", "path": "/tags/0", "value": null }
+		]`))
+		if err != nil {
+			t.Error(err)
+		}
+	})
+	t.Run("Invalid Null Patch", func(t *testing.T) {
+		err = v.ValidatePatches([]byte(`[{ "op": "replace", "path": "/email", "value": null }]`))
+		if err == nil {
+			t.Error("A patch setting a field that is not nullable to null was accepted")
+		}
+	})
+}
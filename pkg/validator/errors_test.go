@@ -0,0 +1,105 @@
+package validator
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestStructuredErrors(t *testing.T) {
+	const jsonValidator = `{
+		"type": "object",
+		"fields": {
+			"name": {"type": "string", "required": true, "maxChars": 5},
+			"tags": {"type": "array", "elements": {"type": "string"}},
+			"a/b": {"type": "integer"}
+		}
+	}`
+
+	var v Validator
+	err := json.Unmarshal([]byte(jsonValidator), &v)
+	if err != nil {
+		panic(err)
+	}
+
+	t.Run("Document errors", func(t *testing.T) {
+		err = v.Validate([]byte(`{"name": "Andrea", "tags": ["a", 3], "a/b": "x"}`))
+		errors := ValidationErrors(err)
+
+		expected := map[string]string{
+			"/name":   "maxChars",
+			"/tags/1": "type",
+			"/a~1b":   "type",
+		}
+		if len(errors) != len(expected) {
+			t.Fatalf("Expected %d errors, got %v", len(expected), errors)
+		}
+		for _, e := range errors {
+			if expected[e.Path] != e.Code {
+				t.Errorf("Unexpected error %s at %s", e.Code, e.Path)
+			}
+			if e.Code == "maxChars" && e.Params["maxChars"] != 5 {
+				t.Errorf("The maxChars error should report the limit, got %v", e.Params)
+			}
+		}
+	})
+	t.Run("Missing field", func(t *testing.T) {
+		errors := ValidationErrors(v.Validate([]byte(`{}`)))
+		if len(errors) != 1 || errors[0].Path != "/name" || errors[0].Code != "required" {
+			t.Errorf("Expected a required error at /name, got %v", errors)
+		}
+	})
+	t.Run("Patch errors", func(t *testing.T) {
+		err = v.ValidatePatches([]byte(`[
+			{"op": "replace", "path": "/name", "value": "Ann"},
+			{"op": "remove", "path": "/name"}
+		]`))
+		errors := ValidationErrors(err)
+		if len(errors) != 1 || errors[0].Patch == nil || *errors[0].Patch != 1 || errors[0].Path != "/name" {
+			t.Errorf("Expected an error on the patch 1 at /name, got %v", errors)
+		}
+	})
+	t.Run("Patch paths of escaped fields", func(t *testing.T) {
+		const jsonValidator = `{
+			"type": "object",
+			"fields": {
+				"a/b": {"type": "integer"},
+				"m~n": {"type": "integer"},
+				"shape": {
+					"type": "union",
+					"tag": "k/ind",
+					"variants": {"circle": {"type": "object", "fields": {"radius": {"type": "float"}}}}
+				}
+			}
+		}`
+
+		var v Validator
+		if err := json.Unmarshal([]byte(jsonValidator), &v); err != nil {
+			t.Fatal(err)
+		}
+
+		patches := map[string]string{
+			`{"op": "add", "path": "/a~1b", "value": "x"}`:           "/a~1b",
+			`{"op": "add", "path": "/m~0n", "value": "x"}`:           "/m~0n",
+			`{"op": "add", "path": "/x~1y", "value": 1}`:             "/x~1y",
+			`{"op": "replace", "path": "/shape/k~1ind", "value": 3}`: "/shape/k~1ind",
+		}
+		for patch, path := range patches {
+			errors := ValidationErrors(v.ValidatePatches([]byte("[" + patch + "]")))
+			if len(errors) != 1 || errors[0].Path != path {
+				t.Errorf("Expected an error at %s for %s, got %v", path, patch, errors)
+			}
+		}
+	})
+	t.Run("JSON serialization", func(t *testing.T) {
+		err = v.Validate([]byte(`{"name": "Andrea"}`))
+		serialized, err := json.Marshal(err)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		const expected = `{"errors":[{"path":"/name","code":"maxChars","params":{"maxChars":5},"message":"This string is longer than maxChars (5)"}]}`
+		if string(serialized) != expected {
+			t.Errorf("Expected %s, got %s", expected, serialized)
+		}
+	})
+}
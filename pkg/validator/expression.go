@@ -0,0 +1,492 @@
+package validator
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// expression is a compiled expression of the object rules. The expressions
+// can read the fields of the object, compare them and do arithmetic, but
+// cannot call arbitrary code, so they are safe to load from tenant schemas.
+//
+// The grammar is:
+//
+//	or      := and ("||" and)*
+//	and     := compare ("&&" compare)*
+//	compare := sum (("==" | "!=" | "<" | "<=" | ">" | ">=") sum)?
+//	sum     := product (("+" | "-") product)*
+//	product := unary (("*" | "/") unary)*
+//	unary   := ("!" | "-") unary | primary
+//	primary := number | string | "true" | "false" | "null" | field | "len(" or ")" | "(" or ")"
+//
+// Fields are referenced by name, nested fields with dots (address.city), and
+// missing fields are null. Comparisons involving null other than == and !=
+// are unknown (null): a rule fails only if it evaluates to false. Strings
+// that are both RFC 3339 dates or date-times are compared as instants
+type expression interface {
+	evaluate(object map[string]interface{}) (interface{}, error)
+	// fields returns the fields referenced by the expression
+	fields() []string
+}
+
+type literalExpression struct {
+	value interface{}
+}
+
+func (e literalExpression) evaluate(object map[string]interface{}) (interface{}, error) {
+	return e.value, nil
+}
+
+func (e literalExpression) fields() []string {
+	return nil
+}
+
+type fieldExpression struct {
+	path []string
+}
+
+func (e fieldExpression) evaluate(object map[string]interface{}) (interface{}, error) {
+	var value interface{} = object
+	for _, key := range e.path {
+		nested, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, nil
+		}
+		value = nested[key]
+	}
+
+	return value, nil
+}
+
+func (e fieldExpression) fields() []string {
+	return []string{e.path[0]}
+}
+
+type unaryExpression struct {
+	operator string
+	operand  expression
+}
+
+func (e unaryExpression) evaluate(object map[string]interface{}) (interface{}, error) {
+	value, err := e.operand.evaluate(object)
+	if err != nil || value == nil {
+		return nil, err
+	}
+
+	switch e.operator {
+	case "!":
+		b, ok := value.(bool)
+		if !ok {
+			return nil, fmt.Errorf("The operator ! requires a boolean")
+		}
+		return !b, nil
+	default:
+		n, ok := value.(float64)
+		if !ok {
+			return nil, fmt.Errorf("The operator - requires a number")
+		}
+		return -n, nil
+	}
+}
+
+func (e unaryExpression) fields() []string {
+	return e.operand.fields()
+}
+
+type lenExpression struct {
+	operand expression
+}
+
+func (e lenExpression) evaluate(object map[string]interface{}) (interface{}, error) {
+	value, err := e.operand.evaluate(object)
+	if err != nil {
+		return nil, err
+	}
+
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return float64(len([]rune(v))), nil
+	case []interface{}:
+		return float64(len(v)), nil
+	case map[string]interface{}:
+		return float64(len(v)), nil
+	default:
+		return nil, fmt.Errorf("The function len requires a string, an array or an object")
+	}
+}
+
+func (e lenExpression) fields() []string {
+	return e.operand.fields()
+}
+
+type binaryExpression struct {
+	operator    string
+	left, right expression
+}
+
+func (e binaryExpression) fields() []string {
+	return append(e.left.fields(), e.right.fields()...)
+}
+
+func (e binaryExpression) evaluate(object map[string]interface{}) (interface{}, error) {
+	left, err := e.left.evaluate(object)
+	if err != nil {
+		return nil, err
+	}
+
+	// the logical operators use three-valued logic, null is unknown
+	switch e.operator {
+	case "&&", "||":
+		if left != nil {
+			if _, ok := left.(bool); !ok {
+				return nil, fmt.Errorf("The operator %s requires booleans", e.operator)
+			}
+		}
+		if left == (e.operator == "||") {
+			return left, nil
+		}
+
+		right, err := e.right.evaluate(object)
+		if err != nil {
+			return nil, err
+		}
+		if right != nil {
+			if _, ok := right.(bool); !ok {
+				return nil, fmt.Errorf("The operator %s requires booleans", e.operator)
+			}
+		}
+		if right == (e.operator == "||") {
+			return right, nil
+		} else if left == nil || right == nil {
+			return nil, nil
+		}
+		return right, nil
+	}
+
+	right, err := e.right.evaluate(object)
+	if err != nil {
+		return nil, err
+	}
+
+	switch e.operator {
+	case "==":
+		return reflect.DeepEqual(left, right), nil
+	case "!=":
+		return !reflect.DeepEqual(left, right), nil
+	}
+
+	if left == nil || right == nil {
+		return nil, nil
+	}
+
+	switch e.operator {
+	case "<", "<=", ">", ">=":
+		comparison, err := compareValues(left, right)
+		if err != nil {
+			return nil, err
+		}
+
+		switch e.operator {
+		case "<":
+			return comparison < 0, nil
+		case "<=":
+			return comparison <= 0, nil
+		case ">":
+			return comparison > 0, nil
+		default:
+			return comparison >= 0, nil
+		}
+	}
+
+	if e.operator == "+" {
+		leftString, leftOk := left.(string)
+		rightString, rightOk := right.(string)
+		if leftOk && rightOk {
+			return leftString + rightString, nil
+		}
+	}
+
+	leftNumber, leftOk := left.(float64)
+	rightNumber, rightOk := right.(float64)
+	if !leftOk || !rightOk {
+		return nil, fmt.Errorf("The operator %s requires numbers", e.operator)
+	}
+
+	switch e.operator {
+	case "+":
+		return leftNumber + rightNumber, nil
+	case "-":
+		return leftNumber - rightNumber, nil
+	case "*":
+		return leftNumber * rightNumber, nil
+	default:
+		if rightNumber == 0 {
+			return nil, fmt.Errorf("Division by zero")
+		}
+		return leftNumber / rightNumber, nil
+	}
+}
+
+// parseInstant parses RFC 3339 dates and date-times
+func parseInstant(s string) (time.Time, bool) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, true
+	}
+	if t, err := time.Parse("2006-01-02", s); err == nil {
+		return t, true
+	}
+
+	return time.Time{}, false
+}
+
+// compareValues returns a negative number if left < right, zero if they are
+// equal and a positive number if left > right
+func compareValues(left interface{}, right interface{}) (int, error) {
+	switch l := left.(type) {
+	case float64:
+		if r, ok := right.(float64); ok {
+			switch {
+			case l < r:
+				return -1, nil
+			case l > r:
+				return 1, nil
+			default:
+				return 0, nil
+			}
+		}
+	case string:
+		if r, ok := right.(string); ok {
+			leftInstant, leftOk := parseInstant(l)
+			rightInstant, rightOk := parseInstant(r)
+			if leftOk && rightOk {
+				switch {
+				case leftInstant.Before(rightInstant):
+					return -1, nil
+				case leftInstant.After(rightInstant):
+					return 1, nil
+				default:
+					return 0, nil
+				}
+			}
+
+			return strings.Compare(l, r), nil
+		}
+	}
+
+	return 0, fmt.Errorf("Only numbers and strings can be compared")
+}
+
+type expressionParser struct {
+	tokens   []string
+	position int
+}
+
+// compileExpression parses the source of an expression
+func compileExpression(source string) (expression, error) {
+	tokens, err := tokenizeExpression(source)
+	if err != nil {
+		return nil, err
+	}
+
+	parser := expressionParser{tokens: tokens}
+	expr, err := parser.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if parser.position < len(parser.tokens) {
+		return nil, fmt.Errorf("Unexpected %s in the expression %s", parser.tokens[parser.position], source)
+	}
+
+	return expr, nil
+}
+
+func tokenizeExpression(source string) ([]string, error) {
+	tokens := []string{}
+	runes := []rune(source)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '\'' || r == '"':
+			end := i + 1
+			for end < len(runes) && runes[end] != r {
+				if runes[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(runes) {
+				return nil, fmt.Errorf("Unterminated string in the expression %s", source)
+			}
+			tokens = append(tokens, string(runes[i:end+1]))
+			i = end + 1
+		case unicode.IsDigit(r):
+			end := i
+			for end < len(runes) && (unicode.IsDigit(runes[end]) || runes[end] == '.') {
+				end++
+			}
+			tokens = append(tokens, string(runes[i:end]))
+			i = end
+		case unicode.IsLetter(r) || r == '_':
+			end := i
+			for end < len(runes) && (unicode.IsLetter(runes[end]) || unicode.IsDigit(runes[end]) || runes[end] == '_' || runes[end] == '.') {
+				end++
+			}
+			tokens = append(tokens, string(runes[i:end]))
+			i = end
+		default:
+			if i+1 < len(runes) {
+				switch operator := string(runes[i : i+2]); operator {
+				case "==", "!=", "<=", ">=", "&&", "||":
+					tokens = append(tokens, operator)
+					i += 2
+					continue
+				}
+			}
+			if !strings.ContainsRune("<>!+-*/()", r) {
+				return nil, fmt.Errorf("Unexpected character %c in the expression %s", r, source)
+			}
+			tokens = append(tokens, string(r))
+			i++
+		}
+	}
+
+	return tokens, nil
+}
+
+func (p *expressionParser) peek() string {
+	if p.position < len(p.tokens) {
+		return p.tokens[p.position]
+	}
+	return ""
+}
+
+func (p *expressionParser) next() string {
+	token := p.peek()
+	p.position++
+	return token
+}
+
+func (p *expressionParser) expect(token string) error {
+	if next := p.next(); next != token {
+		return fmt.Errorf("Expected %s in the expression but found %q", token, next)
+	}
+	return nil
+}
+
+func (p *expressionParser) parseBinary(operators []string, operand func() (expression, error), chained bool) (expression, error) {
+	left, err := operand()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		operator := p.peek()
+		found := false
+		for _, candidate := range operators {
+			if operator == candidate {
+				found = true
+			}
+		}
+		if !found {
+			return left, nil
+		}
+
+		p.next()
+		right, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryExpression{operator, left, right}
+
+		if !chained {
+			return left, nil
+		}
+	}
+}
+
+func (p *expressionParser) parseOr() (expression, error) {
+	return p.parseBinary([]string{"||"}, p.parseAnd, true)
+}
+
+func (p *expressionParser) parseAnd() (expression, error) {
+	return p.parseBinary([]string{"&&"}, p.parseCompare, true)
+}
+
+func (p *expressionParser) parseCompare() (expression, error) {
+	return p.parseBinary([]string{"==", "!=", "<", "<=", ">", ">="}, p.parseSum, false)
+}
+
+func (p *expressionParser) parseSum() (expression, error) {
+	return p.parseBinary([]string{"+", "-"}, p.parseProduct, true)
+}
+
+func (p *expressionParser) parseProduct() (expression, error) {
+	return p.parseBinary([]string{"*", "/"}, p.parseUnary, true)
+}
+
+func (p *expressionParser) parseUnary() (expression, error) {
+	if operator := p.peek(); operator == "!" || operator == "-" {
+		p.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return unaryExpression{operator, operand}, nil
+	}
+
+	return p.parsePrimary()
+}
+
+func (p *expressionParser) parsePrimary() (expression, error) {
+	token := p.next()
+
+	switch {
+	case token == "":
+		return nil, fmt.Errorf("Unexpected end of the expression")
+	case token == "(":
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return expr, p.expect(")")
+	case token == "true" || token == "false":
+		return literalExpression{token == "true"}, nil
+	case token == "null":
+		return literalExpression{nil}, nil
+	case token[0] == '\'' || token[0] == '"':
+		value := strings.ReplaceAll(token[1:len(token)-1], "\\"+token[:1], token[:1])
+		return literalExpression{strings.ReplaceAll(value, "\\\\", "\\")}, nil
+	case unicode.IsDigit(rune(token[0])):
+		value, err := strconv.ParseFloat(token, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid number %s in the expression", token)
+		}
+		return literalExpression{value}, nil
+	case token == "len" && p.peek() == "(":
+		p.next()
+		operand, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return lenExpression{operand}, p.expect(")")
+	case unicode.IsLetter(rune(token[0])) || token[0] == '_':
+		path := strings.Split(token, ".")
+		for _, key := range path {
+			if key == "" {
+				return nil, fmt.Errorf("Invalid field %s in the expression", token)
+			}
+		}
+		return fieldExpression{path}, nil
+	default:
+		return nil, fmt.Errorf("Unexpected %s in the expression", token)
+	}
+}
@@ -1,11 +1,19 @@
 package validator
 
+import (
+	"encoding/json"
+	"math"
+)
+
 type FloatValidator struct {
 	Required  bool
-	Min       *float64 // Enforces a >= constraint
-	StrictMin *float64 // Enforces a > constraint
-	Max       *float64 // Enforces a <= constraint
-	StrictMax *float64 // Enforces a <
+	Nullable  bool
+	Default   json.RawMessage // materialized when the field is missing
+	Coerce    bool            // converts numeric strings to numbers when normalizing
+	Min       *float64        // Enforces a >= constraint
+	StrictMin *float64        // Enforces a > constraint
+	Max       *float64        // Enforces a <= constraint
+	StrictMax *float64        // Enforces a <
 }
 
 func (v *FloatValidator) Type() string {
@@ -17,23 +25,28 @@ func (v *FloatValidator) IsRequired() bool {
 }
 
 func (v *FloatValidator) Validate(json interface{}, position string) error {
+	if json == nil && v.Nullable {
+		return nil
+	}
+
 	value, ok := json.(float64)
 
-	if !ok {
-		return ValidationError{"This field is not a float", position}
+	// NaN and the infinities cannot be represented in JSON
+	if !ok || math.IsNaN(value) || math.IsInf(value, 0) {
+		return ValidationError{Path: position, Code: "type", Params: map[string]interface{}{"type": "float"}, Message: "This field is not a float"}
 	}
 
 	if v.Min != nil && value < *v.Min {
-		return ValidationError{"The value is below the Min", position}
+		return ValidationError{Path: position, Code: "min", Params: map[string]interface{}{"min": *v.Min}, Message: "The value is below the Min"}
 	}
 	if v.Max != nil && value > *v.Max {
-		return ValidationError{"The value is above the Max", position}
+		return ValidationError{Path: position, Code: "max", Params: map[string]interface{}{"max": *v.Max}, Message: "The value is above the Max"}
 	}
 	if v.StrictMin != nil && value <= *v.StrictMin {
-		return ValidationError{"The value is below or equal to the StrictMin", position}
+		return ValidationError{Path: position, Code: "strictMin", Params: map[string]interface{}{"strictMin": *v.StrictMin}, Message: "The value is below or equal to the StrictMin"}
 	}
-	if v.StrictMax != nil && value > *v.StrictMax {
-		return ValidationError{"The value is above or equal to the StrictMax", position}
+	if v.StrictMax != nil && value >= *v.StrictMax {
+		return ValidationError{Path: position, Code: "strictMax", Params: map[string]interface{}{"strictMax": *v.StrictMax}, Message: "The value is above or equal to the StrictMax"}
 	}
 
 	return nil
@@ -44,7 +57,7 @@ func (v *FloatValidator) ValidatePatch(patch Patch, position string) error {
 		switch patch.op {
 		case "remove":
 			if v.IsRequired() {
-				return ValidationError{"Cannot remove a required field", position}
+				return ValidationError{Path: position, Code: "required", Message: "Cannot remove a required field"}
 			}
 
 			return nil
@@ -52,7 +65,7 @@ func (v *FloatValidator) ValidatePatch(patch Patch, position string) error {
 			return v.Validate(patch.value, position)
 		}
 	} else {
-		return ValidationError{"Cannot access a field inside a float", position}
+		return ValidationError{Path: position, Code: "invalidPath", Message: "Cannot access a field inside a float"}
 	}
 }
 
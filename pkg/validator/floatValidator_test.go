@@ -2,6 +2,7 @@ package validator
 
 import (
 	"encoding/json"
+	"math"
 	"testing"
 )
 
@@ -69,3 +70,12 @@ func TestMinMaxFloatValidation(t *testing.T) {
 	})
 
 }
+
+func TestNonFiniteFloatValidation(t *testing.T) {
+	var v FloatValidator
+	for _, value := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+		if err := v.Validate(value, ""); err == nil {
+			t.Errorf("%v should not be recognized as a valid float", value)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package validator
+
+import (
+	"net"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FormatChecker reports whether a string has the format
+type FormatChecker func(string) bool
+
+var (
+	uuidRegex     = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	objectIDRegex = regexp.MustCompile(`^[0-9a-fA-F]{24}$`)
+	hexColorRegex = regexp.MustCompile(`^#([0-9a-fA-F]{3,4}|[0-9a-fA-F]{6}|[0-9a-fA-F]{8})$`)
+)
+
+var formatsMutex sync.RWMutex
+var formats = map[string]FormatChecker{
+	"email": func(s string) bool {
+		address, err := mail.ParseAddress(s)
+		return err == nil && address.Address == s
+	},
+	"uri": func(s string) bool {
+		u, err := url.Parse(s)
+		return err == nil && u.Scheme != ""
+	},
+	"uuid":     uuidRegex.MatchString,
+	"objectId": objectIDRegex.MatchString,
+	"hexColor": hexColorRegex.MatchString,
+	"date": func(s string) bool {
+		_, err := time.Parse("2006-01-02", s)
+		return err == nil
+	},
+	"date-time": func(s string) bool {
+		_, err := time.Parse(time.RFC3339, s)
+		return err == nil
+	},
+	"ipv4": func(s string) bool {
+		ip := net.ParseIP(s)
+		return ip != nil && ip.To4() != nil && !strings.Contains(s, ":")
+	},
+	"ipv6": func(s string) bool {
+		return net.ParseIP(s) != nil && strings.Contains(s, ":")
+	},
+}
+
+// RegisterFormat adds a format that can be used in the format option of the
+// string validators, or replaces a builtin one. The schemas using the format
+// must be loaded after registering it
+func RegisterFormat(name string, checker FormatChecker) {
+	formatsMutex.Lock()
+	defer formatsMutex.Unlock()
+
+	formats[name] = checker
+}
+
+func lookupFormat(name string) (FormatChecker, bool) {
+	formatsMutex.RLock()
+	defer formatsMutex.RUnlock()
+
+	checker, ok := formats[name]
+	return checker, ok
+}
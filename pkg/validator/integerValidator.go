@@ -0,0 +1,77 @@
+package validator
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+)
+
+type IntegerValidator struct {
+	Required   bool
+	Nullable   bool
+	Default    json.RawMessage // materialized when the field is missing
+	Coerce     bool            // converts numeric strings to numbers when normalizing
+	Min        *int64          // Enforces a >= constraint
+	Max        *int64          // Enforces a <= constraint
+	MultipleOf *int64
+}
+
+func (v *IntegerValidator) Type() string {
+	return "integer"
+}
+
+func (v *IntegerValidator) IsRequired() bool {
+	return v.Required
+}
+
+func (v *IntegerValidator) Validate(json interface{}, position string) error {
+	if json == nil && v.Nullable {
+		return nil
+	}
+
+	number, ok := json.(float64)
+	if !ok || number != math.Trunc(number) || math.IsInf(number, 0) {
+		return ValidationError{Path: position, Code: "type", Params: map[string]interface{}{"type": "integer"}, Message: "This field is not an integer"}
+	}
+	if math.Abs(number) > 1<<53 {
+		return ValidationError{Path: position, Code: "integerPrecision", Message: "This integer is too large to be represented exactly"}
+	}
+	value := int64(number)
+
+	if v.Min != nil && value < *v.Min {
+		return ValidationError{Path: position, Code: "min", Params: map[string]interface{}{"min": *v.Min}, Message: fmt.Sprintf("The value is below the Min (%d)", *v.Min)}
+	}
+	if v.Max != nil && value > *v.Max {
+		return ValidationError{Path: position, Code: "max", Params: map[string]interface{}{"max": *v.Max}, Message: fmt.Sprintf("The value is above the Max (%d)", *v.Max)}
+	}
+	if v.MultipleOf != nil && value%*v.MultipleOf != 0 {
+		return ValidationError{Path: position, Code: "multipleOf", Params: map[string]interface{}{"multipleOf": *v.MultipleOf}, Message: fmt.Sprintf("The value is not a multiple of %d", *v.MultipleOf)}
+	}
+
+	return nil
+}
+
+func (v *IntegerValidator) ValidatePatch(patch Patch, position string) error {
+	if patch.IsRootPosition() {
+		switch patch.op {
+		case "remove":
+			if v.IsRequired() {
+				return ValidationError{Path: position, Code: "required", Message: "Cannot remove a required field"}
+			}
+
+			return nil
+		default:
+			return v.Validate(patch.value, position)
+		}
+	} else {
+		return ValidationError{Path: position, Code: "invalidPath", Message: "Cannot access a field inside an integer"}
+	}
+}
+
+func (v *IntegerValidator) InitializeAfterUnmarshaling(customTypes map[string]bool, rootValidator *Validator) error {
+	if v.MultipleOf != nil && *v.MultipleOf <= 0 {
+		return fmt.Errorf("The multipleOf of an integer validator must be positive")
+	}
+
+	return nil
+}
@@ -0,0 +1,97 @@
+package validator
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestEmptyIntegerValidation(t *testing.T) {
+	const jsonValidator = `{
+		"type": "integer"
+	}`
+
+	var v Validator
+	err := json.Unmarshal([]byte(jsonValidator), &v)
+	if err != nil {
+		panic(err)
+	}
+
+	t.Run("Valid Integer", func(t *testing.T) {
+		err = v.Validate([]byte("5"))
+		if err != nil {
+			t.Error(err)
+		}
+	})
+	t.Run("Valid Integer (exponent notation)", func(t *testing.T) {
+		err = v.Validate([]byte("1e3"))
+		if err != nil {
+			t.Error(err)
+		}
+	})
+	t.Run("Invalid Integer (fractional)", func(t *testing.T) {
+		err = v.Validate([]byte("5.5"))
+		if err == nil {
+			t.Error("The value 5.5 should not be recognized as a valid integer")
+		}
+	})
+	t.Run("Invalid Integer (string)", func(t *testing.T) {
+		err = v.Validate([]byte(`"5"`))
+		if err == nil {
+			t.Error("The string '5' should not be recognized as a valid integer")
+		}
+	})
+	t.Run("Invalid Integer (null)", func(t *testing.T) {
+		err = v.Validate([]byte("null"))
+		if err == nil {
+			t.Error("null should be rejected if the validator is not nullable")
+		}
+	})
+}
+
+func TestMinMaxMultipleOfIntegerValidation(t *testing.T) {
+	const jsonValidator = `{
+		"type": "integer",
+		"min": 0,
+		"max": 100,
+		"multipleOf": 5
+	}`
+
+	var v Validator
+	err := json.Unmarshal([]byte(jsonValidator), &v)
+	if err != nil {
+		panic(err)
+	}
+
+	t.Run("Valid Integer", func(t *testing.T) {
+		err = v.Validate([]byte("35"))
+		if err != nil {
+			t.Error(err)
+		}
+	})
+	t.Run("Invalid Integer (under min)", func(t *testing.T) {
+		err = v.Validate([]byte("-5"))
+		if err == nil {
+			t.Error("The value -5 is below the min but wasn't rejected")
+		}
+	})
+	t.Run("Invalid Integer (above max)", func(t *testing.T) {
+		err = v.Validate([]byte("105"))
+		if err == nil {
+			t.Error("The value 105 is above the max but wasn't rejected")
+		}
+	})
+	t.Run("Invalid Integer (not a multiple)", func(t *testing.T) {
+		err = v.Validate([]byte("12"))
+		if err == nil {
+			t.Error("The value 12 is not a multiple of 5 but wasn't rejected")
+		}
+	})
+}
+
+func TestInvalidMultipleOfIntegerValidator(t *testing.T) {
+	var v Validator
+	err := json.Unmarshal([]byte(`{"type": "integer", "multipleOf": 0}`), &v)
+	if err == nil {
+		t.Error("A multipleOf of 0 should be rejected when loading the schema")
+	}
+}
@@ -0,0 +1,657 @@
+package validator
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const jsonSchemaDialect = "https://json-schema.org/draft/2020-12/schema"
+
+// CompatibilityReport lists the parts of a schema that could not be converted
+// between JSON Schema and the validator schemas
+type CompatibilityReport struct {
+	Unsupported []UnsupportedKeyword `json:"unsupported"`
+}
+
+// UnsupportedKeyword is a keyword that was ignored during the conversion
+type UnsupportedKeyword struct {
+	Path    string `json:"path"` // JSON pointer of the schema containing the keyword
+	Keyword string `json:"keyword"`
+	Reason  string `json:"reason"`
+}
+
+// Compatible returns true if the schema was converted without losing anything
+func (r CompatibilityReport) Compatible() bool {
+	return len(r.Unsupported) == 0
+}
+
+func (r *CompatibilityReport) add(path string, keyword string, reason string) {
+	r.Unsupported = append(r.Unsupported, UnsupportedKeyword{path, keyword, reason})
+}
+
+// jsonSchemaAnnotations are the keywords that don't affect validation
+var jsonSchemaAnnotations = map[string]bool{
+	"$schema":     true,
+	"$id":         true,
+	"$comment":    true,
+	"title":       true,
+	"description": true,
+	"examples":    true,
+	"default":     true,
+	"deprecated":  true,
+	"readOnly":    true,
+	"writeOnly":   true,
+}
+
+// builtinTypes are the types that cannot be used as names of custom types
+var builtinTypes = map[string]bool{
+	"object": true, "array": true, "string": true, "float": true, "integer": true,
+	"boolean": true, "null": true, "oneOf": true, "anyOf": true, "union": true,
+	"map": true, "any": true,
+}
+
+func escapePointer(token string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(token, "~", "~0"), "/", "~1")
+}
+
+// unescapePointer decodes a reference token of a JSON Pointer
+func unescapePointer(token string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+}
+
+// ConvertJSONSchema converts a JSON Schema (draft 2020-12) to a validator
+// schema. The supported keywords are type, properties, required,
+// additionalProperties, items, enum, pattern, the known formats, minimum,
+// maximum and their variants, $defs and local $ref; the other keywords are
+// listed in the report
+func ConvertJSONSchema(schema []byte) ([]byte, CompatibilityReport, error) {
+	var decoded interface{}
+	err := json.Unmarshal(schema, &decoded)
+	if err != nil {
+		return nil, CompatibilityReport{}, fmt.Errorf("Passed json schema is invalid: \n" + err.Error())
+	}
+
+	importer := schemaImporter{defs: make(map[string]bool)}
+
+	root, _ := decoded.(map[string]interface{})
+	var defs map[string]interface{}
+	if rawDefs, ok := root["$defs"]; ok {
+		defs, ok = rawDefs.(map[string]interface{})
+		if !ok {
+			return nil, importer.report, fmt.Errorf("The $defs keyword must be an object")
+		}
+	}
+	for name := range defs {
+		if builtinTypes[name] {
+			return nil, importer.report, fmt.Errorf("The definition %s has the same name of a builtin type", name)
+		}
+		importer.defs[name] = true
+	}
+
+	converted := importer.convert(decoded, "")
+	if len(defs) > 0 {
+		customTypes := make(map[string]interface{}, len(defs))
+		for name, def := range defs {
+			customTypes[name] = importer.convert(def, "/$defs/"+escapePointer(name))
+		}
+		converted["customTypes"] = customTypes
+	}
+
+	result, err := json.Marshal(converted)
+	return result, importer.report, err
+}
+
+// ImportJSONSchema builds a Validator from a JSON Schema, see ConvertJSONSchema
+func ImportJSONSchema(schema []byte) (*Validator, CompatibilityReport, error) {
+	converted, report, err := ConvertJSONSchema(schema)
+	if err != nil {
+		return nil, report, err
+	}
+
+	var v Validator
+	err = json.Unmarshal(converted, &v)
+	if err != nil {
+		return nil, report, err
+	}
+
+	return &v, report, nil
+}
+
+type schemaImporter struct {
+	defs   map[string]bool
+	report CompatibilityReport
+}
+
+// schemaKeywords tracks the keywords of a schema that have been converted
+type schemaKeywords struct {
+	schema map[string]interface{}
+	used   map[string]bool
+}
+
+func (k schemaKeywords) get(keyword string) (interface{}, bool) {
+	k.used[keyword] = true
+	value, ok := k.schema[keyword]
+	return value, ok
+}
+
+func (k schemaKeywords) number(keyword string) (float64, bool) {
+	value, ok := k.schema[keyword].(float64)
+	if ok {
+		k.used[keyword] = true
+	}
+	return value, ok
+}
+
+func (k schemaKeywords) has(keywords ...string) bool {
+	for _, keyword := range keywords {
+		if _, ok := k.schema[keyword]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (im *schemaImporter) convert(schema interface{}, path string) map[string]interface{} {
+	switch s := schema.(type) {
+	case bool:
+		if !s {
+			im.report.add(path, "false", "A schema rejecting every value is not supported")
+		}
+		return map[string]interface{}{"type": "any"}
+	case map[string]interface{}:
+		keywords := schemaKeywords{s, map[string]bool{"$defs": path == ""}}
+		converted := im.convertKeywords(keywords, path)
+		if value, ok := s["default"]; ok {
+			converted["default"] = value
+		}
+
+		names := make([]string, 0, len(s))
+		for keyword := range s {
+			names = append(names, keyword)
+		}
+		sort.Strings(names)
+		for _, keyword := range names {
+			if !keywords.used[keyword] && !jsonSchemaAnnotations[keyword] {
+				im.report.add(path, keyword, "The keyword is not supported")
+			}
+		}
+
+		return converted
+	default:
+		im.report.add(path, "", "The schema is not an object or a boolean")
+		return map[string]interface{}{"type": "any"}
+	}
+}
+
+func (im *schemaImporter) convertKeywords(keywords schemaKeywords, path string) map[string]interface{} {
+	if ref, ok := keywords.get("$ref"); ok {
+		refString, _ := ref.(string)
+		name := unescapePointer(strings.TrimPrefix(refString, "#/$defs/"))
+		if !strings.HasPrefix(refString, "#/$defs/") || !im.defs[name] {
+			im.report.add(path, "$ref", "Only references to the root $defs are supported")
+			return map[string]interface{}{"type": "any"}
+		}
+
+		return map[string]interface{}{"type": name}
+	}
+
+	for _, keyword := range []string{"oneOf", "anyOf"} {
+		if keywords.has(keyword) && !keywords.has("type") {
+			value, _ := keywords.get(keyword)
+			options, _ := value.([]interface{})
+			converted := make([]interface{}, len(options))
+			for i, option := range options {
+				converted[i] = im.convert(option, fmt.Sprintf("%s/%s/%d", path, keyword, i))
+			}
+
+			return map[string]interface{}{"type": keyword, "options": converted}
+		}
+	}
+
+	types := im.schemaTypes(keywords, path)
+	nullable := false
+	if len(types) > 1 {
+		for i, t := range types {
+			if t == "null" {
+				nullable = true
+				types = append(types[:i], types[i+1:]...)
+				break
+			}
+		}
+	}
+
+	var converted map[string]interface{}
+	if len(types) == 1 {
+		converted = im.convertType(keywords, types[0], path)
+	} else {
+		options := make([]interface{}, len(types))
+		for i, t := range types {
+			options[i] = im.convertType(keywords, t, path)
+		}
+		converted = map[string]interface{}{"type": "anyOf", "options": options}
+	}
+
+	if nullable {
+		converted["nullable"] = true
+	}
+	return converted
+}
+
+// schemaTypes returns the JSON Schema types of the schema, inferring them from
+// the keywords if the type is not specified
+func (im *schemaImporter) schemaTypes(keywords schemaKeywords, path string) []string {
+	if value, ok := keywords.get("type"); ok {
+		switch t := value.(type) {
+		case string:
+			return []string{t}
+		case []interface{}:
+			types := []string{}
+			for _, item := range t {
+				if name, ok := item.(string); ok {
+					types = append(types, name)
+				}
+			}
+			if len(types) > 0 {
+				return types
+			}
+		}
+
+		im.report.add(path, "type", "The type must be a string or an array of strings")
+		return []string{"any"}
+	}
+
+	switch {
+	case keywords.has("properties", "additionalProperties", "required", "propertyNames", "minProperties", "maxProperties"):
+		return []string{"object"}
+	case keywords.has("items", "minItems", "maxItems"):
+		return []string{"array"}
+	case keywords.has("pattern", "minLength", "maxLength", "format"):
+		return []string{"string"}
+	case keywords.has("minimum", "maximum", "exclusiveMinimum", "exclusiveMaximum"):
+		return []string{"number"}
+	}
+
+	if enum, ok := keywords.schema["enum"].([]interface{}); ok {
+		for _, value := range enum {
+			if _, ok := value.(string); !ok {
+				return []string{"any"}
+			}
+		}
+		return []string{"string"}
+	}
+
+	return []string{"any"}
+}
+
+func (im *schemaImporter) convertType(keywords schemaKeywords, schemaType string, path string) map[string]interface{} {
+	converted := map[string]interface{}{}
+
+	switch schemaType {
+	case "string":
+		converted["type"] = "string"
+		if value, ok := keywords.number("maxLength"); ok {
+			converted["maxChars"] = int(value)
+		}
+		if value, ok := keywords.number("minLength"); ok {
+			converted["minChars"] = int(value)
+		}
+		if value, ok := keywords.get("pattern"); ok {
+			pattern, _ := value.(string)
+			if _, err := regexp.Compile(pattern); err != nil {
+				im.report.add(path, "pattern", "The pattern is not a valid RE2 regular expression")
+			} else {
+				converted["regexMatch"] = pattern
+			}
+		}
+		if format, ok := keywords.schema["format"].(string); ok {
+			if _, ok := lookupFormat(format); ok {
+				keywords.used["format"] = true
+				converted["format"] = format
+			}
+		}
+		if value, ok := keywords.schema["enum"].([]interface{}); ok {
+			allowedValues := []string{}
+			for _, item := range value {
+				if itemString, ok := item.(string); ok {
+					allowedValues = append(allowedValues, itemString)
+				}
+			}
+			if len(allowedValues) == len(value) {
+				keywords.used["enum"] = true
+				converted["allowedValues"] = allowedValues
+			}
+		}
+	case "number":
+		converted["type"] = "float"
+		for keyword, field := range map[string]string{"minimum": "min", "maximum": "max", "exclusiveMinimum": "strictMin", "exclusiveMaximum": "strictMax"} {
+			if value, ok := keywords.number(keyword); ok {
+				converted[field] = value
+			}
+		}
+	case "integer":
+		converted["type"] = "integer"
+		// the inclusive and exclusive bounds map to the same field, the
+		// stricter one is kept
+		var minimums, maximums []int64
+		if value, ok := keywords.number("minimum"); ok {
+			minimums = append(minimums, int64(math.Ceil(value)))
+		}
+		if value, ok := keywords.number("exclusiveMinimum"); ok {
+			minimums = append(minimums, int64(math.Floor(value))+1)
+		}
+		if value, ok := keywords.number("maximum"); ok {
+			maximums = append(maximums, int64(math.Floor(value)))
+		}
+		if value, ok := keywords.number("exclusiveMaximum"); ok {
+			maximums = append(maximums, int64(math.Ceil(value))-1)
+		}
+		for _, min := range minimums {
+			if current, ok := converted["min"].(int64); !ok || min > current {
+				converted["min"] = min
+			}
+		}
+		for _, max := range maximums {
+			if current, ok := converted["max"].(int64); !ok || max < current {
+				converted["max"] = max
+			}
+		}
+		if value, ok := keywords.schema["multipleOf"].(float64); ok && value >= 1 && value == math.Trunc(value) {
+			keywords.used["multipleOf"] = true
+			converted["multipleOf"] = int64(value)
+		}
+	case "boolean", "null":
+		converted["type"] = schemaType
+	case "object":
+		im.convertObject(keywords, converted, path)
+	case "array":
+		converted["type"] = "array"
+		converted["elements"] = map[string]interface{}{"type": "any"}
+		if items, ok := keywords.get("items"); ok {
+			converted["elements"] = im.convert(items, path+"/items")
+		}
+		if value, ok := keywords.number("maxItems"); ok {
+			converted["maxElements"] = int(value)
+		}
+		if value, ok := keywords.number("minItems"); ok {
+			converted["minElements"] = int(value)
+		}
+	case "any":
+		converted["type"] = "any"
+	default:
+		im.report.add(path, "type", "The type "+schemaType+" is not supported")
+		converted["type"] = "any"
+	}
+
+	return converted
+}
+
+func (im *schemaImporter) convertObject(keywords schemaKeywords, converted map[string]interface{}, path string) {
+	properties, hasProperties := keywords.schema["properties"].(map[string]interface{})
+	additional, hasAdditional := keywords.schema["additionalProperties"]
+
+	// objects without properties are maps of the additional properties
+	if !hasProperties && additional != false {
+		keywords.used["additionalProperties"] = true
+		converted["type"] = "map"
+		converted["values"] = map[string]interface{}{"type": "any"}
+		if hasAdditional {
+			converted["values"] = im.convert(additional, path+"/additionalProperties")
+		}
+		if names, ok := keywords.get("propertyNames"); ok {
+			converted["keys"] = im.convert(names, path+"/propertyNames")
+		}
+		if value, ok := keywords.number("maxProperties"); ok {
+			converted["maxEntries"] = int(value)
+		}
+		if value, ok := keywords.number("minProperties"); ok {
+			converted["minEntries"] = int(value)
+		}
+		return
+	}
+
+	keywords.used["properties"] = true
+	keywords.used["additionalProperties"] = true
+	if additional != false {
+		im.report.add(path, "additionalProperties", "The properties not listed in properties are always rejected")
+	}
+
+	fields := make(map[string]interface{}, len(properties))
+	for name, property := range properties {
+		fields[name] = im.convert(property, path+"/properties/"+escapePointer(name))
+	}
+
+	if value, ok := keywords.get("required"); ok {
+		required, _ := value.([]interface{})
+		for _, item := range required {
+			name, _ := item.(string)
+			field, ok := fields[name].(map[string]interface{})
+			if !ok {
+				im.report.add(path, "required", "The required property "+name+" is not listed in properties")
+				continue
+			}
+			field["required"] = true
+		}
+	}
+
+	if value, ok := keywords.get("dependentRequired"); ok {
+		dependencies, _ := value.(map[string]interface{})
+		converted["dependentRequired"] = dependencies
+	}
+
+	converted["type"] = "object"
+	converted["fields"] = fields
+}
+
+// ExportJSONSchema converts the validator to a JSON Schema (draft 2020-12),
+// the constraints that cannot be expressed are listed in the report
+func (v *Validator) ExportJSONSchema() ([]byte, CompatibilityReport, error) {
+	exporter := schemaExporter{}
+
+	schema := exporter.export(v.fieldValidator, "")
+	schema["$schema"] = jsonSchemaDialect
+	if len(v.customTypes) > 0 {
+		defs := make(map[string]interface{}, len(v.customTypes))
+		for name, validator := range v.customTypes {
+			defs[name] = exporter.export(validator, "/$defs/"+escapePointer(name))
+		}
+		schema["$defs"] = defs
+	}
+
+	result, err := json.Marshal(schema)
+	return result, exporter.report, err
+}
+
+type schemaExporter struct {
+	report CompatibilityReport
+}
+
+// withNull makes the exported schema accept null
+func withNull(schema map[string]interface{}, nullable bool) map[string]interface{} {
+	if !nullable {
+		return schema
+	}
+
+	if schemaType, ok := schema["type"].(string); ok {
+		schema["type"] = []string{schemaType, "null"}
+		return schema
+	}
+
+	return map[string]interface{}{"anyOf": []interface{}{schema, map[string]interface{}{"type": "null"}}}
+}
+
+func (ex *schemaExporter) export(validator FieldValidator, path string) map[string]interface{} {
+	schema := ex.exportConstraints(validator, path)
+
+	if value, ok := defaultValue(validator); ok {
+		schema["default"] = value
+	}
+
+	switch v := validator.(type) {
+	case *StringValidator:
+		if v.Trim {
+			ex.report.add(path, "trim", "Coercions cannot be expressed in JSON Schema")
+		}
+	case *FloatValidator:
+		if v.Coerce {
+			ex.report.add(path, "coerce", "Coercions cannot be expressed in JSON Schema")
+		}
+	case *IntegerValidator:
+		if v.Coerce {
+			ex.report.add(path, "coerce", "Coercions cannot be expressed in JSON Schema")
+		}
+	case *BooleanValidator:
+		if v.Coerce {
+			ex.report.add(path, "coerce", "Coercions cannot be expressed in JSON Schema")
+		}
+	}
+
+	return schema
+}
+
+func (ex *schemaExporter) exportConstraints(validator FieldValidator, path string) map[string]interface{} {
+	schema := map[string]interface{}{}
+
+	switch v := validator.(type) {
+	case *ObjectValidator:
+		properties := make(map[string]interface{}, len(v.keyValidators))
+		required := []string{}
+		for name, field := range v.keyValidators {
+			properties[name] = ex.export(field, path+"/properties/"+escapePointer(name))
+			if field.IsRequired() {
+				required = append(required, name)
+			}
+		}
+		sort.Strings(required)
+
+		schema["type"] = "object"
+		schema["properties"] = properties
+		schema["additionalProperties"] = false
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+		if len(v.DependentRequired) > 0 {
+			schema["dependentRequired"] = v.DependentRequired
+		}
+		if len(v.Rules) > 0 {
+			ex.report.add(path, "rules", "Expression rules cannot be expressed in JSON Schema")
+		}
+		if len(v.Conditions) > 0 {
+			ex.report.add(path, "conditions", "Expression conditions cannot be expressed in JSON Schema")
+		}
+		return withNull(schema, v.Nullable)
+	case *MapValidator:
+		schema["type"] = "object"
+		schema["additionalProperties"] = ex.export(v.valuesValidator, path+"/additionalProperties")
+		if v.keysValidator != nil {
+			schema["propertyNames"] = ex.export(v.keysValidator, path+"/propertyNames")
+		}
+		if v.MaxEntries != nil {
+			schema["maxProperties"] = *v.MaxEntries
+		}
+		if v.MinEntries != nil {
+			schema["minProperties"] = *v.MinEntries
+		}
+		return withNull(schema, v.Nullable)
+	case *ArrayValidator:
+		schema["type"] = "array"
+		schema["items"] = ex.export(v.elementsValidator, path+"/items")
+		if v.MaxElements != nil {
+			schema["maxItems"] = *v.MaxElements
+		}
+		if v.MinElements != nil {
+			schema["minItems"] = *v.MinElements
+		}
+		return withNull(schema, v.Nullable)
+	case *StringValidator:
+		schema["type"] = "string"
+		if v.MaxChars != nil {
+			schema["maxLength"] = *v.MaxChars
+		}
+		if v.MinChars != nil {
+			schema["minLength"] = *v.MinChars
+		}
+		if v.RegexMatch != nil {
+			schema["pattern"] = *v.RegexMatch
+		}
+		if v.NoRegexMatch != nil {
+			schema["not"] = map[string]interface{}{"pattern": *v.NoRegexMatch}
+		}
+		if v.Format != nil {
+			schema["format"] = *v.Format
+		}
+		if v.AllowedValues != nil {
+			schema["enum"] = *v.AllowedValues
+		}
+		return withNull(schema, v.Nullable)
+	case *FloatValidator:
+		schema["type"] = "number"
+		if v.Min != nil {
+			schema["minimum"] = *v.Min
+		}
+		if v.StrictMin != nil {
+			schema["exclusiveMinimum"] = *v.StrictMin
+		}
+		if v.Max != nil {
+			schema["maximum"] = *v.Max
+		}
+		if v.StrictMax != nil {
+			schema["exclusiveMaximum"] = *v.StrictMax
+		}
+		return withNull(schema, v.Nullable)
+	case *IntegerValidator:
+		schema["type"] = "integer"
+		if v.Min != nil {
+			schema["minimum"] = *v.Min
+		}
+		if v.Max != nil {
+			schema["maximum"] = *v.Max
+		}
+		if v.MultipleOf != nil {
+			schema["multipleOf"] = *v.MultipleOf
+		}
+		return withNull(schema, v.Nullable)
+	case *BooleanValidator:
+		schema["type"] = "boolean"
+		return withNull(schema, v.Nullable)
+	case *NullValidator:
+		schema["type"] = "null"
+		return schema
+	case *AnyValidator:
+		return schema
+	case *OptionsValidator:
+		options := make([]interface{}, len(v.optionValidators))
+		for i, option := range v.optionValidators {
+			options[i] = ex.export(option, fmt.Sprintf("%s/%s/%d", path, v.Type(), i))
+		}
+		schema[v.Type()] = options
+		return withNull(schema, v.Nullable)
+	case *UnionValidator:
+		variants := []interface{}{}
+		for i, name := range v.variantNames() {
+			variantPath := fmt.Sprintf("%s/oneOf/%d", path, i)
+			variant := ex.export(v.variantValidators[name], variantPath)
+			properties, ok := variant["properties"].(map[string]interface{})
+			if !ok {
+				ex.report.add(variantPath, "tag", "The variant "+name+" is not an object, so the tag cannot be added to it")
+				continue
+			}
+
+			properties[v.Tag] = map[string]interface{}{"const": name}
+			required, _ := variant["required"].([]string)
+			variant["required"] = append([]string{v.Tag}, required...)
+			variants = append(variants, variant)
+		}
+		schema["oneOf"] = variants
+		return withNull(schema, v.Nullable)
+	case *CustomValidator:
+		schema["$ref"] = "#/$defs/" + escapePointer(v.fieldName)
+		return withNull(schema, v.nullable)
+	default:
+		ex.report.add(path, validator.Type(), "The type "+validator.Type()+" cannot be exported")
+		return schema
+	}
+}
@@ -0,0 +1,188 @@
+package validator
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestImportJSONSchema(t *testing.T) {
+	const jsonSchema = `{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"type": "object",
+		"properties": {
+			"name": {"type": "string", "maxLength": 20, "pattern": "^[A-Z]"},
+			"age": {"type": "integer", "minimum": 0},
+			"score": {"type": ["number", "null"], "exclusiveMaximum": 10},
+			"role": {"enum": ["admin", "user"]},
+			"address": {"$ref": "#/$defs/address"},
+			"tags": {"type": "array", "items": {"type": "string"}, "maxItems": 2},
+			"settings": {"type": "object", "additionalProperties": {"type": "boolean"}}
+		},
+		"required": ["name", "address"],
+		"additionalProperties": false,
+		"$defs": {
+			"address": {
+				"type": "object",
+				"properties": {
+					"city": {"type": "string", "description": "The city"}
+				},
+				"required": ["city"],
+				"additionalProperties": false
+			}
+		}
+	}`
+
+	v, report, err := ImportJSONSchema([]byte(jsonSchema))
+	if err != nil {
+		panic(err)
+	}
+	if !report.Compatible() {
+		t.Errorf("The schema should be fully supported: %v", report.Unsupported)
+	}
+
+	t.Run("Valid document", func(t *testing.T) {
+		err = v.Validate([]byte(`{
+			"name": "Andrea",
+			"age": 30,
+			"score": null,
+			"role": "admin",
+			"address": {"city": "Trento"},
+			"tags": ["a", "b"],
+			"settings": {"dark": true}
+		}`))
+		if err != nil {
+			t.Error(err)
+		}
+	})
+	t.Run("Invalid documents", func(t *testing.T) {
+		invalid := []string{
+			`{"name": "andrea", "address": {"city": "Trento"}}`,
+			`{"name": "Andrea", "address": {}}`,
+			`{"name": "Andrea"}`,
+			`{"name": "Andrea", "address": {"city": "Trento"}, "age": -1}`,
+			`{"name": "Andrea", "address": {"city": "Trento"}, "score": 10}`,
+			`{"name": "Andrea", "address": {"city": "Trento"}, "role": "owner"}`,
+			`{"name": "Andrea", "address": {"city": "Trento"}, "tags": ["a", "b", "c"]}`,
+			`{"name": "Andrea", "address": {"city": "Trento"}, "settings": {"dark": 1}}`,
+			`{"name": "Andrea", "address": {"city": "Trento"}, "surname": "Zanin"}`,
+		}
+		for _, document := range invalid {
+			if v.Validate([]byte(document)) == nil {
+				t.Errorf("The document %s should not be valid", document)
+			}
+		}
+	})
+	t.Run("Inclusive and exclusive integer bounds", func(t *testing.T) {
+		v, report, err := ImportJSONSchema([]byte(`{"type": "integer", "minimum": 10, "exclusiveMinimum": 0, "maximum": 20, "exclusiveMaximum": 15}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !report.Compatible() {
+			t.Errorf("The bounds should be supported: %v", report.Unsupported)
+		}
+
+		for _, document := range []string{"10", "14"} {
+			if err := v.Validate([]byte(document)); err != nil {
+				t.Errorf("The value %s should be valid: %s", document, err)
+			}
+		}
+		for _, document := range []string{"9", "15"} {
+			if v.Validate([]byte(document)) == nil {
+				t.Errorf("The value %s is outside the stricter bounds", document)
+			}
+		}
+	})
+	t.Run("Compatibility report", func(t *testing.T) {
+		_, report, err := ImportJSONSchema([]byte(`{
+			"type": "object",
+			"properties": {
+				"a": {"type": "string", "format": "hostname"},
+				"b": {"allOf": [{"type": "string"}]}
+			}
+		}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := map[string]string{
+			"":              "additionalProperties",
+			"/properties/a": "format",
+			"/properties/b": "allOf",
+		}
+		if len(report.Unsupported) != len(expected) {
+			t.Fatalf("Expected %d unsupported keywords, got %v", len(expected), report.Unsupported)
+		}
+		for _, unsupported := range report.Unsupported {
+			if expected[unsupported.Path] != unsupported.Keyword {
+				t.Errorf("Unexpected unsupported keyword %s at %s", unsupported.Keyword, unsupported.Path)
+			}
+		}
+	})
+}
+
+func TestExportJSONSchema(t *testing.T) {
+	const jsonValidator = `{
+		"type": "object",
+		"fields": {
+			"name": {"type": "string", "required": true, "maxChars": 20},
+			"age": {"type": "integer", "nullable": true, "min": 0},
+			"shape": {
+				"type": "union",
+				"tag": "kind",
+				"variants": {
+					"circle": {"type": "object", "fields": {"radius": {"type": "float", "required": true}}}
+				}
+			},
+			"friend": {"type": "person"},
+			"home": {"type": "places/home"}
+		},
+		"customTypes": {
+			"person": {"type": "object", "fields": {"name": {"type": "string"}}},
+			"places/home": {"type": "object", "fields": {"city": {"type": "string"}}}
+		}
+	}`
+
+	var v Validator
+	err := json.Unmarshal([]byte(jsonValidator), &v)
+	if err != nil {
+		panic(err)
+	}
+
+	schema, report, err := v.ExportJSONSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Compatible() {
+		t.Errorf("The validator should be fully exported: %v", report.Unsupported)
+	}
+
+	// the exported schema is imported back to check that it is equivalent
+	imported, report, err := ImportJSONSchema(schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valid := []string{
+		`{"name": "Andrea", "age": null}`,
+		`{"name": "Andrea", "shape": {"kind": "circle", "radius": 2}, "friend": {"name": "Giorgio"}}`,
+		`{"name": "Andrea", "home": {"city": "Trento"}}`,
+	}
+	for _, document := range valid {
+		if err := imported.Validate([]byte(document)); err != nil {
+			t.Errorf("The document %s should be valid: %s", document, err)
+		}
+	}
+
+	invalid := []string{
+		`{"age": 3}`,
+		`{"name": "Andrea", "age": -1}`,
+		`{"name": "Andrea", "shape": {"kind": "circle"}}`,
+		`{"name": "Andrea", "friend": {"surname": "Zanin"}}`,
+		`{"name": "Andrea", "home": {"city": 3}}`,
+	}
+	for _, document := range invalid {
+		if imported.Validate([]byte(document)) == nil {
+			t.Errorf("The document %s should not be valid", document)
+		}
+	}
+}
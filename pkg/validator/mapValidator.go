@@ -0,0 +1,160 @@
+package validator
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// MapValidator validates objects with arbitrary keys, the keys are validated
+// as strings by the Keys validator and the values by the Values validator
+type MapValidator struct {
+	keysValidator   FieldValidator
+	valuesValidator FieldValidator
+	Keys            json.RawMessage
+	Values          json.RawMessage
+	Required        bool
+	Nullable        bool
+	Default         json.RawMessage // materialized when the field is missing
+	MaxEntries      *int
+	MinEntries      *int
+}
+
+func (v *MapValidator) Type() string {
+	return "map"
+}
+
+func (v *MapValidator) IsRequired() bool {
+	return v.Required
+}
+
+func (v *MapValidator) Validate(json interface{}, position string) error {
+	if json == nil && v.Nullable {
+		return nil
+	}
+
+	jsonObject, ok := json.(map[string]interface{})
+
+	if !ok {
+		return ValidationError{Path: position, Code: "type", Params: map[string]interface{}{"type": "object"}, Message: "This field is not an object"}
+	}
+
+	compositeError := CompositeValidationError{}
+	for key, value := range jsonObject {
+		fieldPosition := position + "/" + escapePointer(key)
+
+		if err := v.validateKey(key, fieldPosition); err != nil {
+			compositeError.add(err)
+			continue
+		}
+
+		fieldError := v.valuesValidator.Validate(value, fieldPosition)
+		if fieldError != nil {
+			compositeError.add(fieldError)
+		}
+	}
+
+	if err := v.validateEntries(len(jsonObject), position); err != nil {
+		compositeError.add(err)
+	}
+
+	if len(compositeError.Errors) > 0 {
+		return compositeError
+	}
+
+	return nil
+}
+
+func (v *MapValidator) validateKey(key string, position string) error {
+	if v.keysValidator == nil {
+		return nil
+	}
+
+	if err := v.keysValidator.Validate(key, position); err != nil {
+		return ValidationError{Path: position, Code: "invalidKey", Params: map[string]interface{}{"key": key}, Message: "The key " + key + " is not valid: " + err.Error()}
+	}
+
+	return nil
+}
+
+func (v *MapValidator) validateEntries(entries int, position string) error {
+	if v.MaxEntries != nil && entries > *v.MaxEntries {
+		return ValidationError{Path: position, Code: "maxEntries", Params: map[string]interface{}{"maxEntries": *v.MaxEntries}, Message: fmt.Sprintf("This map can contain at most %d entries", *v.MaxEntries)}
+	}
+	if v.MinEntries != nil && entries < *v.MinEntries {
+		return ValidationError{Path: position, Code: "minEntries", Params: map[string]interface{}{"minEntries": *v.MinEntries}, Message: fmt.Sprintf("This map must contain at least %d entries", *v.MinEntries)}
+	}
+
+	return nil
+}
+
+func (v *MapValidator) ValidatePatch(patch Patch, position string) error {
+	if patch.IsRootPosition() {
+		switch patch.op {
+		case "remove":
+			if v.IsRequired() {
+				return ValidationError{Path: position, Code: "required", Message: "Cannot remove a required field"}
+			}
+
+			return nil
+		default:
+			return v.Validate(patch.value, position)
+		}
+	}
+
+	current, hasCurrent := patch.document.(map[string]interface{})
+	hasCurrent = hasCurrent && patch.hasDocument
+
+	key, err := patch.UnshiftPosition()
+	if err != nil {
+		return ValidationError{Path: position, Code: "invalidPath", Message: err.Error()}
+	}
+	fieldPosition := position + "/" + escapePointer(key)
+
+	if err := v.validateKey(key, fieldPosition); err != nil {
+		return err
+	}
+
+	// adding or removing a key changes the number of entries, it can be
+	// checked only if the current map is known
+	if hasCurrent && patch.IsRootPosition() {
+		_, exists := current[key]
+		entries := len(current)
+		if patch.op == "add" && !exists {
+			entries++
+		} else if patch.op == "remove" && exists {
+			entries--
+		}
+
+		if err := v.validateEntries(entries, position); err != nil {
+			return err
+		}
+	}
+
+	if patch.IsRootPosition() && patch.op == "remove" {
+		return nil
+	}
+
+	return v.valuesValidator.ValidatePatch(patch, fieldPosition)
+}
+
+func (v *MapValidator) InitializeAfterUnmarshaling(customTypes map[string]bool, rootValidator *Validator) error {
+	if len(v.Values) == 0 {
+		return fmt.Errorf("A map validator must specify the values validator")
+	}
+
+	validator, err := UnmarshalValidator(v.Values, customTypes, rootValidator)
+	if err != nil {
+		return err
+	}
+	v.valuesValidator = validator
+
+	if len(v.Keys) > 0 {
+		validator, err := UnmarshalValidator(v.Keys, customTypes, rootValidator)
+		if err != nil {
+			return err
+		}
+		v.keysValidator = validator
+	}
+
+	return nil
+}
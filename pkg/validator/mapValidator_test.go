@@ -0,0 +1,139 @@
+package validator
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestMapValidation(t *testing.T) {
+	const jsonValidator = `{
+		"type": "map",
+		"keys": {"type": "string", "regexMatch": "^[a-f0-9]+$", "maxChars": 8},
+		"values": {
+			"type": "object",
+			"fields": {
+				"score": {"type": "integer", "required": true}
+			}
+		},
+		"minEntries": 1,
+		"maxEntries": 2
+	}`
+
+	var v Validator
+	err := json.Unmarshal([]byte(jsonValidator), &v)
+	if err != nil {
+		panic(err)
+	}
+
+	t.Run("Valid map", func(t *testing.T) {
+		err = v.Validate([]byte(`{"a1": {"score": 3}, "b2": {"score": 4}}`))
+		if err != nil {
+			t.Error(err)
+		}
+	})
+	t.Run("Invalid key", func(t *testing.T) {
+		err = v.Validate([]byte(`{"xyz": {"score": 3}}`))
+		if err == nil {
+			t.Error("The key xyz doesn't match the keys regex")
+		}
+	})
+	t.Run("Invalid value", func(t *testing.T) {
+		err = v.Validate([]byte(`{"a1": {"score": "high"}}`))
+		if err == nil {
+			t.Error("The score should be an integer")
+		}
+	})
+	t.Run("Entries limits", func(t *testing.T) {
+		err = v.Validate([]byte(`{}`))
+		if err == nil {
+			t.Error("The map should contain at least 1 entry")
+		}
+
+		err = v.Validate([]byte(`{"a": {"score": 1}, "b": {"score": 2}, "c": {"score": 3}}`))
+		if err == nil {
+			t.Error("The map should contain at most 2 entries")
+		}
+	})
+	t.Run("Patch arbitrary keys", func(t *testing.T) {
+		err = v.ValidatePatches([]byte(`[
+			{"op": "add", "path": "/c3", "value": {"score": 1}},
+			{"op": "replace", "path": "/c3/score", "value": 2},
+			{"op": "remove", "path": "/c3"}
+		]`))
+		if err != nil {
+			t.Error(err)
+		}
+
+		err = v.ValidatePatches([]byte(`[{"op": "add", "path": "/zz", "value": {"score": 1}}]`))
+		if err == nil {
+			t.Error("The key zz doesn't match the keys regex")
+		}
+
+		err = v.ValidatePatches([]byte(`[{"op": "add", "path": "/c3", "value": {}}]`))
+		if err == nil {
+			t.Error("The score is required")
+		}
+	})
+	t.Run("Patch entries limits", func(t *testing.T) {
+		const full = `{"a": {"score": 1}, "b": {"score": 2}}`
+
+		err = v.ValidatePatchesWithDocument([]byte(full), []byte(`[{"op": "add", "path": "/c", "value": {"score": 3}}]`))
+		if err == nil {
+			t.Error("The map should contain at most 2 entries")
+		}
+
+		err = v.ValidatePatchesWithDocument([]byte(full), []byte(`[{"op": "add", "path": "/a", "value": {"score": 3}}]`))
+		if err != nil {
+			t.Error(err)
+		}
+
+		err = v.ValidatePatchesWithDocument([]byte(`{"a": {"score": 1}}`), []byte(`[{"op": "remove", "path": "/a"}]`))
+		if err == nil {
+			t.Error("The map should contain at least 1 entry")
+		}
+	})
+}
+
+func TestMapEscapedKeysValidation(t *testing.T) {
+	const jsonValidator = `{
+		"type": "object",
+		"fields": {
+			"m": {
+				"type": "map",
+				"keys": {"type": "string", "regexMatch": "^[a-z/~]+$"},
+				"values": {"type": "integer"},
+				"maxEntries": 2
+			}
+		}
+	}`
+
+	var v Validator
+	err := json.Unmarshal([]byte(jsonValidator), &v)
+	if err != nil {
+		panic(err)
+	}
+
+	document := `{"m": {"a/b": 1, "c~d": 2}}`
+	t.Run("Replace escaped keys", func(t *testing.T) {
+		err = v.ValidatePatchesWithDocument([]byte(document), []byte(`[
+			{"op": "replace", "path": "/m/a~1b", "value": 3},
+			{"op": "replace", "path": "/m/c~0d", "value": 4}
+		]`))
+		if err != nil {
+			t.Error(err)
+		}
+	})
+	t.Run("Invalid value at an escaped key", func(t *testing.T) {
+		err = v.ValidatePatchesWithDocument([]byte(document), []byte(`[{"op": "replace", "path": "/m/a~1b", "value": "x"}]`))
+		errors := ValidationErrors(err)
+		if len(errors) != 1 || errors[0].Path != "/m/a~1b" {
+			t.Error("The value should be an integer at /m/a~1b, got", err)
+		}
+	})
+	t.Run("Add escaped key", func(t *testing.T) {
+		err = v.ValidatePatchesWithDocument([]byte(document), []byte(`[{"op": "add", "path": "/m/e~1f", "value": 5}]`))
+		if err == nil {
+			t.Error("The map should contain at most 2 entries")
+		}
+	})
+}
@@ -0,0 +1,170 @@
+package validator
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// rawDefault returns the default value set on the validator itself
+func rawDefault(validator FieldValidator) json.RawMessage {
+	var raw json.RawMessage
+	switch v := validator.(type) {
+	case *ObjectValidator:
+		raw = v.Default
+	case *MapValidator:
+		raw = v.Default
+	case *ArrayValidator:
+		raw = v.Default
+	case *StringValidator:
+		raw = v.Default
+	case *FloatValidator:
+		raw = v.Default
+	case *IntegerValidator:
+		raw = v.Default
+	case *BooleanValidator:
+		raw = v.Default
+	case *NullValidator:
+		raw = v.Default
+	case *AnyValidator:
+		raw = v.Default
+	case *OptionsValidator:
+		raw = v.Default
+	case *UnionValidator:
+		raw = v.Default
+	case *CustomValidator:
+		raw = v.defaultValue
+	}
+
+	return raw
+}
+
+// defaultValue returns a new copy of the default value of the validator
+func defaultValue(validator FieldValidator) (interface{}, bool) {
+	raw := rawDefault(validator)
+	if custom, ok := validator.(*CustomValidator); ok && len(raw) == 0 {
+		return defaultValue(custom.sourceValidator.customTypes[custom.fieldName])
+	}
+
+	if len(raw) == 0 {
+		return nil, false
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return nil, false
+	}
+
+	return value, true
+}
+
+// checkDefaults validates the default values of the schema once all the
+// custom types are loaded, an invalid default would make every value missing
+// the field invalid
+func (v *Validator) checkDefaults() error {
+	for _, validator := range v.defaultValidators {
+		value, _ := defaultValue(validator)
+		if err := validator.Validate(normalize(validator, value), ""); err != nil {
+			return fmt.Errorf("The default value %s is not valid: %s", rawDefault(validator), err.Error())
+		}
+	}
+	v.defaultValidators = nil
+
+	return nil
+}
+
+// normalize materializes the default values of the missing fields and applies
+// the coercions of the validator to the value, returning the updated value.
+// Values that don't match the validator are returned unchanged
+func normalize(validator FieldValidator, value interface{}) interface{} {
+	switch v := validator.(type) {
+	case *ObjectValidator:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return value
+		}
+
+		for key, field := range v.keyValidators {
+			if child, ok := object[key]; ok {
+				object[key] = normalize(field, child)
+			} else if child, ok := defaultValue(field); ok {
+				object[key] = normalize(field, child)
+			}
+		}
+	case *MapValidator:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return value
+		}
+
+		for key, child := range object {
+			object[key] = normalize(v.valuesValidator, child)
+		}
+	case *ArrayValidator:
+		array, ok := value.([]interface{})
+		if !ok {
+			return value
+		}
+
+		for i, child := range array {
+			array[i] = normalize(v.elementsValidator, child)
+		}
+	case *StringValidator:
+		if s, ok := value.(string); ok && v.Trim {
+			return strings.TrimSpace(s)
+		}
+	case *FloatValidator:
+		if s, ok := value.(string); ok && v.Coerce {
+			number, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+			if err == nil && !math.IsNaN(number) && !math.IsInf(number, 0) {
+				return number
+			}
+		}
+	case *IntegerValidator:
+		if s, ok := value.(string); ok && v.Coerce {
+			if number, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64); err == nil {
+				return float64(number)
+			}
+		}
+	case *BooleanValidator:
+		if s, ok := value.(string); ok && v.Coerce {
+			switch strings.TrimSpace(s) {
+			case "true":
+				return true
+			case "false":
+				return false
+			}
+		}
+	case *OptionsValidator:
+		// the value is normalized by the first option it matches
+		for _, option := range v.optionValidators {
+			normalized := normalize(option, deepCopy(value))
+			if option.Validate(normalized, "") == nil {
+				return normalized
+			}
+		}
+	case *UnionValidator:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return value
+		}
+
+		variant, fields, err := v.variant(object, "")
+		if err != nil {
+			return value
+		}
+
+		normalized, ok := normalize(variant, fields).(map[string]interface{})
+		if !ok {
+			return value
+		}
+		normalized[v.Tag] = object[v.Tag]
+		return normalized
+	case *CustomValidator:
+		return normalize(v.sourceValidator.customTypes[v.fieldName], value)
+	}
+
+	return value
+}
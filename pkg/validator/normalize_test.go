@@ -0,0 +1,114 @@
+package validator
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	const jsonValidator = `{
+		"type": "object",
+		"fields": {
+			"name": {"type": "string", "required": true, "trim": true},
+			"theme": {"type": "string", "default": "light"},
+			"age": {"type": "integer", "coerce": true},
+			"score": {"type": "float", "coerce": true},
+			"newsletter": {"type": "boolean", "coerce": true, "default": false},
+			"settings": {
+				"type": "object",
+				"default": {},
+				"fields": {
+					"language": {"type": "string", "default": "en"}
+				}
+			},
+			"address": {"type": "address"}
+		},
+		"customTypes": {
+			"address": {
+				"type": "object",
+				"default": {"city": "Trento"},
+				"fields": {
+					"city": {"type": "string"}
+				}
+			}
+		}
+	}`
+
+	var v Validator
+	err := json.Unmarshal([]byte(jsonValidator), &v)
+	if err != nil {
+		panic(err)
+	}
+
+	assertNormalized := func(t *testing.T, source string, expected string) {
+		normalized, err := v.Normalize([]byte(source))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got, want interface{}
+		json.Unmarshal(normalized, &got)
+		json.Unmarshal([]byte(expected), &want)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Expected %s, got %s", expected, normalized)
+		}
+	}
+
+	t.Run("Defaults", func(t *testing.T) {
+		assertNormalized(t,
+			`{"name": "Andrea"}`,
+			`{"name": "Andrea", "theme": "light", "newsletter": false, "settings": {"language": "en"}, "address": {"city": "Trento"}}`,
+		)
+	})
+	t.Run("Present fields are kept", func(t *testing.T) {
+		assertNormalized(t,
+			`{"name": "Andrea", "theme": "dark", "newsletter": true, "settings": {"language": "it"}, "address": {"city": "Roma"}}`,
+			`{"name": "Andrea", "theme": "dark", "newsletter": true, "settings": {"language": "it"}, "address": {"city": "Roma"}}`,
+		)
+	})
+	t.Run("Coercion", func(t *testing.T) {
+		assertNormalized(t,
+			`{"name": "  Andrea ", "age": "30", "score": " 4.5", "newsletter": "true"}`,
+			`{"name": "Andrea", "age": 30, "score": 4.5, "newsletter": true, "theme": "light", "settings": {"language": "en"}, "address": {"city": "Trento"}}`,
+		)
+	})
+	t.Run("Invalid values", func(t *testing.T) {
+		_, err := v.Normalize([]byte(`{"name": "Andrea", "age": "thirty"}`))
+		if err == nil {
+			t.Error("thirty cannot be coerced to an integer")
+		}
+
+		_, err = v.Normalize([]byte(`{"theme": "dark"}`))
+		if err == nil {
+			t.Error("The name is required")
+		}
+	})
+	t.Run("Non finite numbers", func(t *testing.T) {
+		for _, score := range []string{"NaN", "Inf", "-Infinity"} {
+			_, err := v.Normalize([]byte(`{"name": "Andrea", "score": "` + score + `"}`))
+			errors := ValidationErrors(err)
+			if len(errors) != 1 || errors[0].Code != "type" {
+				t.Errorf("%s cannot be coerced to a float, got %v", score, err)
+			}
+		}
+	})
+}
+
+func TestInvalidDefaults(t *testing.T) {
+	invalidValidators := map[string]string{
+		"Wrong type":                `{"type": "object", "fields": {"age": {"type": "integer", "default": "ten"}}}`,
+		"Not an allowed value":      `{"type": "string", "allowedValues": ["light", "dark"], "default": "blue"}`,
+		"Missing required field":    `{"type": "object", "default": {}, "fields": {"city": {"type": "string", "required": true}}}`,
+		"Invalid custom type value": `{"type": "object", "fields": {"a": {"type": "address", "default": {"city": 3}}}, "customTypes": {"address": {"type": "object", "fields": {"city": {"type": "string"}}}}}`,
+	}
+
+	for name, source := range invalidValidators {
+		t.Run(name, func(t *testing.T) {
+			var v Validator
+			if err := json.Unmarshal([]byte(source), &v); err == nil {
+				t.Error("The schema should be rejected")
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package validator
+
+import "encoding/json"
+
+type NullValidator struct {
+	Required bool
+	Default  json.RawMessage // materialized when the field is missing
+}
+
+func (v *NullValidator) Type() string {
+	return "null"
+}
+
+func (v *NullValidator) IsRequired() bool {
+	return v.Required
+}
+
+func (v *NullValidator) Validate(json interface{}, position string) error {
+	if json != nil {
+		return ValidationError{Path: position, Code: "type", Params: map[string]interface{}{"type": "null"}, Message: "This field is not null"}
+	}
+
+	return nil
+}
+
+func (v *NullValidator) ValidatePatch(patch Patch, position string) error {
+	if patch.IsRootPosition() {
+		switch patch.op {
+		case "remove":
+			if v.IsRequired() {
+				return ValidationError{Path: position, Code: "required", Message: "Cannot remove a required field"}
+			}
+
+			return nil
+		default:
+			return v.Validate(patch.value, position)
+		}
+	} else {
+		return ValidationError{Path: position, Code: "invalidPath", Message: "Cannot access a field inside null"}
+	}
+}
+
+func (v *NullValidator) InitializeAfterUnmarshaling(customTypes map[string]bool, rootValidator *Validator) error {
+	return nil
+}
@@ -0,0 +1,287 @@
+package validator
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// ObjectRule is a cross-field constraint of an object, the Expression must
+// not evaluate to false (see expression for the syntax)
+type ObjectRule struct {
+	expression expression
+	Expression string
+	Message    string // reported when the rule fails, defaults to the expression
+}
+
+// ObjectConstraints are constraints applied to an object by a condition
+type ObjectConstraints struct {
+	fieldValidators map[string]FieldValidator
+	Required        []string
+	Rules           []ObjectRule
+	// Fields are additional validators of the fields, applied if the field
+	// is present or required by the validator
+	Fields map[string]json.RawMessage
+}
+
+// ConditionalConstraint applies the Then constraints to the object when the
+// If expression is true and the Else constraints when it is false
+type ConditionalConstraint struct {
+	condition expression
+	If        string
+	Then      *ObjectConstraints
+	Else      *ObjectConstraints
+}
+
+func (r *ObjectRule) initialize(fields map[string]json.RawMessage) error {
+	expr, err := compileExpression(r.Expression)
+	if err != nil {
+		return err
+	}
+	if err := checkExpressionFields(expr, fields); err != nil {
+		return err
+	}
+
+	r.expression = expr
+	return nil
+}
+
+// check returns an error if the rule evaluates to false on the object
+func (r *ObjectRule) check(object map[string]interface{}, position string) error {
+	message := r.Message
+	if message == "" {
+		message = "The rule " + r.Expression + " is not satisfied"
+	}
+
+	result, err := r.expression.evaluate(object)
+	if err != nil {
+		return ValidationError{Path: position, Code: "rule", Params: map[string]interface{}{"expression": r.Expression}, Message: message + ": " + err.Error()}
+	} else if result == false {
+		return ValidationError{Path: position, Code: "rule", Params: map[string]interface{}{"expression": r.Expression}, Message: message}
+	} else if _, ok := result.(bool); !ok && result != nil {
+		return ValidationError{Path: position, Code: "rule", Params: map[string]interface{}{"expression": r.Expression}, Message: message + ": the expression is not a boolean"}
+	}
+
+	return nil
+}
+
+// checkExpressionFields checks that the expression only references fields of
+// the object
+func checkExpressionFields(expr expression, fields map[string]json.RawMessage) error {
+	for _, field := range expr.fields() {
+		if _, ok := fields[field]; !ok {
+			return fmt.Errorf("The expression references the field %s that is not specified in the schema", field)
+		}
+	}
+
+	return nil
+}
+
+func (c *ObjectConstraints) initialize(fields map[string]json.RawMessage, customTypes map[string]bool, rootValidator *Validator) error {
+	for _, name := range c.Required {
+		if _, ok := fields[name]; !ok {
+			return fmt.Errorf("The required field %s is not specified in the schema", name)
+		}
+	}
+	for i := range c.Rules {
+		if err := c.Rules[i].initialize(fields); err != nil {
+			return err
+		}
+	}
+
+	c.fieldValidators = make(map[string]FieldValidator, len(c.Fields))
+	for name, source := range c.Fields {
+		if _, ok := fields[name]; !ok {
+			return fmt.Errorf("The field %s is not specified in the schema", name)
+		}
+
+		validator, err := UnmarshalValidator(source, customTypes, rootValidator)
+		if err != nil {
+			return err
+		}
+		c.fieldValidators[name] = validator
+	}
+
+	return nil
+}
+
+func (c *ObjectConstraints) check(object map[string]interface{}, position string, condition string, compositeError *CompositeValidationError) {
+	for _, name := range c.Required {
+		if _, ok := object[name]; !ok {
+			compositeError.add(ValidationError{
+				Path:    position + "/" + escapePointer(name),
+				Code:    "required",
+				Params:  map[string]interface{}{"if": condition},
+				Message: "Field " + name + " is required when " + condition,
+			})
+		}
+	}
+
+	for name, validator := range c.fieldValidators {
+		if value, ok := object[name]; ok {
+			if err := validator.Validate(value, position+"/"+escapePointer(name)); err != nil {
+				compositeError.add(err)
+			}
+		} else if validator.IsRequired() {
+			compositeError.add(ValidationError{
+				Path:    position + "/" + escapePointer(name),
+				Code:    "required",
+				Params:  map[string]interface{}{"if": condition},
+				Message: "Field " + name + " is required when " + condition,
+			})
+		}
+	}
+
+	for i := range c.Rules {
+		if err := c.Rules[i].check(object, position); err != nil {
+			compositeError.add(err)
+		}
+	}
+}
+
+// initializeConstraints compiles the cross-field constraints of the object
+func (v *ObjectValidator) initializeConstraints(customTypes map[string]bool, rootValidator *Validator) error {
+	v.dependentFields = make([]string, 0, len(v.DependentRequired))
+	for field := range v.DependentRequired {
+		v.dependentFields = append(v.dependentFields, field)
+	}
+	sort.Strings(v.dependentFields)
+
+	for _, field := range v.dependentFields {
+		dependencies := v.DependentRequired[field]
+		if _, ok := v.Fields[field]; !ok {
+			return fmt.Errorf("The field %s of dependentRequired is not specified in the schema", field)
+		}
+		for _, dependency := range dependencies {
+			if _, ok := v.Fields[dependency]; !ok {
+				return fmt.Errorf("The field %s of dependentRequired is not specified in the schema", dependency)
+			}
+		}
+	}
+
+	for i := range v.Rules {
+		if err := v.Rules[i].initialize(v.Fields); err != nil {
+			return err
+		}
+	}
+
+	for i := range v.Conditions {
+		condition := &v.Conditions[i]
+		expr, err := compileExpression(condition.If)
+		if err != nil {
+			return err
+		}
+		if err := checkExpressionFields(expr, v.Fields); err != nil {
+			return err
+		}
+		condition.condition = expr
+
+		for _, constraints := range []*ObjectConstraints{condition.Then, condition.Else} {
+			if constraints != nil {
+				if err := constraints.initialize(v.Fields, customTypes, rootValidator); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkConstraints validates the cross-field constraints of the object
+func (v *ObjectValidator) checkConstraints(object map[string]interface{}, position string, compositeError *CompositeValidationError) {
+	for _, field := range v.dependentFields {
+		if _, ok := object[field]; !ok {
+			continue
+		}
+
+		for _, dependency := range v.DependentRequired[field] {
+			if _, ok := object[dependency]; !ok {
+				compositeError.add(ValidationError{
+					Path:    position + "/" + escapePointer(dependency),
+					Code:    "dependentRequired",
+					Params:  map[string]interface{}{"field": field},
+					Message: "Field " + dependency + " is required when " + field + " is present",
+				})
+			}
+		}
+	}
+
+	for i := range v.Rules {
+		if err := v.Rules[i].check(object, position); err != nil {
+			compositeError.add(err)
+		}
+	}
+
+	for _, condition := range v.Conditions {
+		result, err := condition.condition.evaluate(object)
+		if err != nil {
+			compositeError.add(ValidationError{
+				Path:    position,
+				Code:    "rule",
+				Params:  map[string]interface{}{"expression": condition.If},
+				Message: "The condition " + condition.If + " cannot be evaluated: " + err.Error(),
+			})
+			continue
+		}
+
+		if result == true && condition.Then != nil {
+			condition.Then.check(object, position, condition.If, compositeError)
+		} else if result == false && condition.Else != nil {
+			condition.Else.check(object, position, "!("+condition.If+")", compositeError)
+		}
+	}
+}
+
+// crossFieldErrors validates the cross-field constraints of all the objects
+// in the value, it is used to check the document resulting from the patches
+func crossFieldErrors(validator FieldValidator, value interface{}, position string, compositeError *CompositeValidationError) {
+	switch v := validator.(type) {
+	case *ObjectValidator:
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return
+		}
+
+		v.checkConstraints(object, position, compositeError)
+		for key, field := range v.keyValidators {
+			if child, ok := object[key]; ok {
+				crossFieldErrors(field, child, position+"/"+escapePointer(key), compositeError)
+			}
+		}
+	case *MapValidator:
+		if object, ok := value.(map[string]interface{}); ok {
+			for key, child := range object {
+				crossFieldErrors(v.valuesValidator, child, position+"/"+escapePointer(key), compositeError)
+			}
+		}
+	case *ArrayValidator:
+		if array, ok := value.([]interface{}); ok {
+			for i, child := range array {
+				crossFieldErrors(v.elementsValidator, child, fmt.Sprintf("%s/%d", position, i), compositeError)
+			}
+		}
+	case *OptionsValidator:
+		// the patches are checked against the options one at a time, so the
+		// final value could match none of them once the constraints of the
+		// options are taken into account
+		if value == nil && v.Nullable {
+			return
+		}
+
+		option, err := v.option(value, position)
+		if err != nil {
+			compositeError.add(err)
+			return
+		}
+		crossFieldErrors(option, value, position, compositeError)
+	case *UnionValidator:
+		if object, ok := value.(map[string]interface{}); ok {
+			if variant, fields, err := v.variant(object, position); err == nil {
+				crossFieldErrors(variant, fields, position, compositeError)
+			}
+		}
+	case *CustomValidator:
+		crossFieldErrors(v.sourceValidator.customTypes[v.fieldName], value, position, compositeError)
+	}
+}
@@ -0,0 +1,287 @@
+package validator
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestObjectConstraintsValidation(t *testing.T) {
+	const jsonValidator = `{
+		"type": "object",
+		"fields": {
+			"type": {"type": "string", "allowedValues": ["free", "paid"], "required": true},
+			"price": {"type": "float", "min": 0},
+			"currency": {"type": "string"},
+			"startDate": {"type": "string", "format": "date"},
+			"endDate": {"type": "string", "format": "date"}
+		},
+		"dependentRequired": {"price": ["currency"]},
+		"rules": [
+			{"expression": "endDate > startDate", "message": "The end date must follow the start date"}
+		],
+		"conditions": [
+			{
+				"if": "type == 'paid'",
+				"then": {"required": ["price"], "fields": {"price": {"type": "float", "strictMin": 0}}},
+				"else": {"rules": [{"expression": "price == null || price == 0"}]}
+			}
+		]
+	}`
+
+	var v Validator
+	err := json.Unmarshal([]byte(jsonValidator), &v)
+	if err != nil {
+		panic(err)
+	}
+
+	t.Run("Valid object", func(t *testing.T) {
+		err = v.Validate([]byte(`{"type": "paid", "price": 3, "currency": "EUR", "startDate": "2021-03-01", "endDate": "2021-03-02"}`))
+		if err != nil {
+			t.Error(err)
+		}
+	})
+	t.Run("Missing fields of a rule", func(t *testing.T) {
+		err = v.Validate([]byte(`{"type": "free"}`))
+		if err != nil {
+			t.Error("A rule on missing fields is unknown and should pass, got", err)
+		}
+	})
+	t.Run("Broken rule", func(t *testing.T) {
+		err = v.Validate([]byte(`{"type": "free", "startDate": "2021-03-02", "endDate": "2021-03-01"}`))
+		errors := ValidationErrors(err)
+		if len(errors) != 1 || errors[0].Code != "rule" || errors[0].Message != "The end date must follow the start date" {
+			t.Error("The end date precedes the start date, got", err)
+		}
+	})
+	t.Run("Missing dependent field", func(t *testing.T) {
+		err = v.Validate([]byte(`{"type": "paid", "price": 3}`))
+		errors := ValidationErrors(err)
+		if len(errors) != 1 || errors[0].Code != "dependentRequired" || errors[0].Path != "/currency" {
+			t.Error("The currency is required when the price is present, got", err)
+		}
+	})
+	t.Run("Missing field required by a condition", func(t *testing.T) {
+		err = v.Validate([]byte(`{"type": "paid"}`))
+		errors := ValidationErrors(err)
+		if len(errors) != 1 || errors[0].Code != "required" || errors[0].Path != "/price" {
+			t.Error("The price is required when the type is paid, got", err)
+		}
+	})
+	t.Run("Invalid field of a condition", func(t *testing.T) {
+		err = v.Validate([]byte(`{"type": "paid", "price": 0, "currency": "EUR"}`))
+		errors := ValidationErrors(err)
+		if len(errors) != 1 || errors[0].Code != "strictMin" {
+			t.Error("A paid price must be positive, got", err)
+		}
+	})
+	t.Run("Else branch", func(t *testing.T) {
+		err = v.Validate([]byte(`{"type": "free", "price": 3, "currency": "EUR"}`))
+		if err == nil {
+			t.Error("A free object cannot have a price")
+		}
+	})
+	t.Run("Patch breaking a rule", func(t *testing.T) {
+		document := `{"type": "free", "startDate": "2021-03-01", "endDate": "2021-03-02"}`
+		err = v.ValidatePatchesWithDocument([]byte(document), []byte(`[{"op": "replace", "path": "/endDate", "value": "2021-02-01"}]`))
+		if err == nil {
+			t.Error("The patched end date precedes the start date")
+		}
+	})
+	t.Run("Patches fixing a rule together", func(t *testing.T) {
+		document := `{"type": "free", "startDate": "2021-03-01", "endDate": "2021-03-02"}`
+		err = v.ValidatePatchesWithDocument([]byte(document), []byte(`[
+			{"op": "replace", "path": "/startDate", "value": "2021-04-01"},
+			{"op": "replace", "path": "/endDate", "value": "2021-04-02"}
+		]`))
+		if err != nil {
+			t.Error(err)
+		}
+	})
+	t.Run("Patch breaking a condition", func(t *testing.T) {
+		err = v.ValidatePatchesWithDocument([]byte(`{"type": "free"}`), []byte(`[{"op": "replace", "path": "/type", "value": "paid"}]`))
+		if err == nil {
+			t.Error("A paid object requires a price")
+		}
+	})
+}
+
+func TestNestedObjectConstraintsValidation(t *testing.T) {
+	const jsonValidator = `{
+		"type": "object",
+		"fields": {
+			"ranges": {
+				"type": "array",
+				"elements": {
+					"type": "object",
+					"fields": {
+						"min": {"type": "integer", "required": true},
+						"max": {"type": "integer", "required": true}
+					},
+					"rules": [{"expression": "min <= max"}]
+				}
+			}
+		}
+	}`
+
+	var v Validator
+	err := json.Unmarshal([]byte(jsonValidator), &v)
+	if err != nil {
+		panic(err)
+	}
+
+	t.Run("Broken nested rule", func(t *testing.T) {
+		err = v.Validate([]byte(`{"ranges": [{"min": 1, "max": 2}, {"min": 3, "max": 2}]}`))
+		errors := ValidationErrors(err)
+		if len(errors) != 1 || errors[0].Path != "/ranges/1" {
+			t.Error("The second range is empty, got", err)
+		}
+	})
+	t.Run("Patch breaking a nested rule", func(t *testing.T) {
+		err = v.ValidatePatchesWithDocument([]byte(`{"ranges": [{"min": 1, "max": 2}]}`), []byte(`[{"op": "replace", "path": "/ranges/0/min", "value": 5}]`))
+		errors := ValidationErrors(err)
+		if len(errors) != 1 || errors[0].Path != "/ranges/0" {
+			t.Error("The patched range is empty, got", err)
+		}
+	})
+}
+
+func TestDependentRequiredOrder(t *testing.T) {
+	const jsonValidator = `{
+		"type": "object",
+		"fields": {
+			"a": {"type": "integer"}, "b": {"type": "integer"}, "c": {"type": "integer"},
+			"x": {"type": "integer"}, "y": {"type": "integer"}, "z": {"type": "integer"}
+		},
+		"dependentRequired": {"c": ["z"], "a": ["x"], "b": ["y"]}
+	}`
+
+	var v Validator
+	err := json.Unmarshal([]byte(jsonValidator), &v)
+	if err != nil {
+		panic(err)
+	}
+
+	t.Run("Stable errors", func(t *testing.T) {
+		for i := 0; i < 20; i++ {
+			errors := ValidationErrors(v.Validate([]byte(`{"a": 1, "b": 1, "c": 1}`)))
+			if len(errors) != 3 || errors[0].Path != "/x" || errors[1].Path != "/y" || errors[2].Path != "/z" {
+				t.Fatalf("Expected the errors in the order of the fields, got %v", errors)
+			}
+		}
+	})
+	t.Run("Stable load error", func(t *testing.T) {
+		for i := 0; i < 20; i++ {
+			var invalid Validator
+			err := json.Unmarshal([]byte(`{"type": "object", "fields": {"a": {"type": "integer"}}, "dependentRequired": {"d": ["a"], "c": ["a"], "e": ["a"]}}`), &invalid)
+			if err == nil || err.Error() != "The field c of dependentRequired is not specified in the schema" {
+				t.Fatalf("Expected the error of the first field, got %v", err)
+			}
+		}
+	})
+}
+
+func TestOptionsObjectConstraintsValidation(t *testing.T) {
+	for _, optionsType := range []string{"anyOf", "oneOf"} {
+		jsonValidator := `{
+			"type": "object",
+			"fields": {
+				"event": {
+					"type": "` + optionsType + `",
+					"options": [
+						{
+							"type": "object",
+							"fields": {
+								"start": {"type": "integer", "required": true},
+								"end": {"type": "integer", "required": true}
+							},
+							"rules": [{"expression": "end > start"}]
+						},
+						{"type": "string"}
+					]
+				}
+			}
+		}`
+
+		var v Validator
+		err := json.Unmarshal([]byte(jsonValidator), &v)
+		if err != nil {
+			panic(err)
+		}
+
+		t.Run(optionsType+" patch breaking a rule", func(t *testing.T) {
+			err = v.ValidatePatchesWithDocument([]byte(`{"event": {"start": 1, "end": 5}}`), []byte(`[{"op": "replace", "path": "/event/start", "value": 9}]`))
+			if err == nil {
+				t.Error("The patched event ends before it starts")
+			}
+		})
+		t.Run(optionsType+" patch keeping a rule", func(t *testing.T) {
+			err = v.ValidatePatchesWithDocument([]byte(`{"event": {"start": 1, "end": 5}}`), []byte(`[{"op": "replace", "path": "/event/start", "value": 3}]`))
+			if err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestInvalidObjectConstraints(t *testing.T) {
+	invalidValidators := map[string]string{
+		"Unknown field in a rule":               `{"type": "object", "fields": {"a": {"type": "integer"}}, "rules": [{"expression": "b > 0"}]}`,
+		"Malformed rule":                        `{"type": "object", "fields": {"a": {"type": "integer"}}, "rules": [{"expression": "a > "}]}`,
+		"Unterminated string":                   `{"type": "object", "fields": {"a": {"type": "string"}}, "rules": [{"expression": "a == 'x"}]}`,
+		"Unknown field in dependentRequired":    `{"type": "object", "fields": {"a": {"type": "integer"}}, "dependentRequired": {"a": ["b"]}}`,
+		"Unknown field in a condition":          `{"type": "object", "fields": {"a": {"type": "integer"}}, "conditions": [{"if": "b == 1"}]}`,
+		"Unknown required field in a condition": `{"type": "object", "fields": {"a": {"type": "integer"}}, "conditions": [{"if": "a == 1", "then": {"required": ["b"]}}]}`,
+	}
+
+	for name, source := range invalidValidators {
+		t.Run(name, func(t *testing.T) {
+			var v Validator
+			if err := json.Unmarshal([]byte(source), &v); err == nil {
+				t.Error("The schema should be rejected")
+			}
+		})
+	}
+}
+
+func TestExpressionEvaluation(t *testing.T) {
+	object := map[string]interface{}{
+		"a":       float64(2),
+		"name":    "Andrea",
+		"address": map[string]interface{}{"city": "Trento"},
+		"tags":    []interface{}{"x", "y"},
+		"from":    "2021-03-01T10:00:00+02:00",
+		"to":      "2021-03-01T09:00:00Z",
+	}
+
+	expressions := map[string]interface{}{
+		"a * 3 + 1 == 7":            true,
+		"-a < 0 && !(a > 5)":        true,
+		"len(tags) == 2":            true,
+		"len(name) > 10":            false,
+		"address.city == 'Trento'":  true,
+		"name + \"!\" == 'Andrea!'": true,
+		"missing > 1":               nil,
+		"missing > 1 || a == 2":     true,
+		"missing > 1 && a == 3":     false,
+		"missing == null":           true,
+		"to > from":                 true,
+		"'b' > 'a'":                 true,
+	}
+
+	for source, expected := range expressions {
+		t.Run(source, func(t *testing.T) {
+			expr, err := compileExpression(source)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			result, err := expr.evaluate(object)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result != expected {
+				t.Errorf("Expected %v, got %v", expected, result)
+			}
+		})
+	}
+}
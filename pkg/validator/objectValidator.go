@@ -8,6 +8,16 @@ type ObjectValidator struct {
 	keyValidators map[string]FieldValidator
 	Fields        map[string]json.RawMessage
 	Required      bool
+	Nullable      bool
+	Default       json.RawMessage // materialized when the field is missing
+	// DependentRequired lists for each field the fields that are required
+	// when it is present
+	DependentRequired map[string][]string
+	Rules             []ObjectRule
+	Conditions        []ConditionalConstraint
+
+	// dependentFields are the keys of DependentRequired in a stable order
+	dependentFields []string
 }
 
 func (v *ObjectValidator) Type() string {
@@ -19,10 +29,14 @@ func (v *ObjectValidator) IsRequired() bool {
 }
 
 func (v *ObjectValidator) Validate(json interface{}, position string) error {
+	if json == nil && v.Nullable {
+		return nil
+	}
+
 	jsonObject, ok := json.(map[string]interface{})
 
 	if !ok {
-		return ValidationError{"This field is not an object", position}
+		return ValidationError{Path: position, Code: "type", Params: map[string]interface{}{"type": "object"}, Message: "This field is not an object"}
 	}
 
 	compositeError := CompositeValidationError{}
@@ -30,15 +44,15 @@ func (v *ObjectValidator) Validate(json interface{}, position string) error {
 	for key, validator := range v.keyValidators {
 		if value, ok := jsonObject[key]; !ok {
 			if validator.IsRequired() {
-				compositeError.errors = append(compositeError.errors, ValidationError{"Required field " + key + " is missing", position})
+				compositeError.add(ValidationError{Path: position + "/" + escapePointer(key), Code: "required", Message: "Required field " + key + " is missing"})
 				returnError = true
 			}
 		} else {
-			fieldPosition := position + "/" + key
+			fieldPosition := position + "/" + escapePointer(key)
 			fieldError := validator.Validate(value, fieldPosition)
 
 			if fieldError != nil {
-				compositeError.errors = append(compositeError.errors, fieldError)
+				compositeError.add(fieldError)
 				returnError = true
 			}
 		}
@@ -46,11 +60,18 @@ func (v *ObjectValidator) Validate(json interface{}, position string) error {
 
 	for key := range jsonObject {
 		if _, ok := v.keyValidators[key]; !ok {
-			compositeError.errors = append(compositeError.errors, ValidationError{"Field " + key + " is not specified in the schema", position})
+			compositeError.add(ValidationError{Path: position + "/" + escapePointer(key), Code: "unknownField", Message: "Field " + key + " is not specified in the schema"})
 			returnError = true
 		}
 	}
 
+	// the cross-field constraints are checked only on objects whose fields
+	// are valid, so that the errors are not reported twice
+	if !returnError {
+		v.checkConstraints(jsonObject, position, &compositeError)
+		returnError = len(compositeError.Errors) > 0
+	}
+
 	if returnError {
 		return compositeError
 	} else {
@@ -58,13 +79,12 @@ func (v *ObjectValidator) Validate(json interface{}, position string) error {
 	}
 }
 
-// TODO: change validation depending on Patch operation
 func (v *ObjectValidator) ValidatePatch(patch Patch, position string) error {
 	if patch.IsRootPosition() {
 		switch patch.op {
 		case "remove":
 			if v.IsRequired() {
-				return ValidationError{"Cannot remove a required field", position}
+				return ValidationError{Path: position, Code: "required", Message: "Cannot remove a required field"}
 			}
 
 			return nil
@@ -73,16 +93,24 @@ func (v *ObjectValidator) ValidatePatch(patch Patch, position string) error {
 		}
 	}
 
+	current, hasCurrent := patch.document.(map[string]interface{})
+	hasCurrent = hasCurrent && patch.hasDocument
+
 	field, err := patch.UnshiftPosition()
 	if err != nil {
-		return ValidationError{err.Error(), position}
+		return ValidationError{Path: position, Code: "invalidPath", Message: err.Error()}
+	}
+
+	// only add can target a field that is not in the document
+	if _, exists := current[field]; hasCurrent && patch.IsRootPosition() && patch.op != "add" && !exists {
+		return ValidationError{Path: position + "/" + escapePointer(field), Code: "missingField", Message: "Cannot " + patch.op + " the missing field " + field}
 	}
 
 	if validator, ok := v.keyValidators[field]; ok {
-		fieldPosition := position + "/" + field
+		fieldPosition := position + "/" + escapePointer(field)
 		return validator.ValidatePatch(patch, fieldPosition)
 	} else {
-		return ValidationError{"Field " + field + " is not specified in the schema", position}
+		return ValidationError{Path: position + "/" + escapePointer(field), Code: "unknownField", Message: "Field " + field + " is not specified in the schema"}
 	}
 }
 
@@ -99,5 +127,5 @@ func (v *ObjectValidator) InitializeAfterUnmarshaling(customTypes map[string]boo
 		v.keyValidators[key] = validator
 	}
 
-	return nil
+	return v.initializeConstraints(customTypes, rootValidator)
 }
@@ -0,0 +1,144 @@
+package validator
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// OptionsValidator is the validator of the oneOf and anyOf types: the value
+// must match exactly one of the options (oneOf) or at least one (anyOf)
+type OptionsValidator struct {
+	optionValidators []FieldValidator
+	exclusive        bool
+	Options          []json.RawMessage
+	Required         bool
+	Nullable         bool
+	Default          json.RawMessage // materialized when the field is missing
+}
+
+func (v *OptionsValidator) Type() string {
+	if v.exclusive {
+		return "oneOf"
+	}
+
+	return "anyOf"
+}
+
+func (v *OptionsValidator) IsRequired() bool {
+	return v.Required
+}
+
+// branchError is the error of a branch of a oneOf, anyOf or union validator
+type branchError struct {
+	branch string
+	err    error
+}
+
+// branchesError explains why the value didn't match any of the branches
+func branchesError(code string, message string, branchErrors []branchError, position string) error {
+	branches := make(map[string][]ValidationError, len(branchErrors))
+	for _, b := range branchErrors {
+		message += "\n  " + b.branch + ": " + strings.ReplaceAll(b.err.Error(), "\n", "\n    ")
+		branches[b.branch] = ValidationErrors(b.err)
+	}
+
+	return ValidationError{Path: position, Code: code, Message: message, Branches: branches}
+}
+
+func (v *OptionsValidator) Validate(json interface{}, position string) error {
+	if json == nil && v.Nullable {
+		return nil
+	}
+
+	_, err := v.option(json, position)
+	return err
+}
+
+// option returns the validator of the first option matched by the value
+func (v *OptionsValidator) option(json interface{}, position string) (FieldValidator, error) {
+	var branchErrors []branchError
+	var matches []string
+	var matched FieldValidator
+	for i, validator := range v.optionValidators {
+		err := validator.Validate(json, position)
+		if err != nil {
+			branchErrors = append(branchErrors, branchError{fmt.Sprintf("option %d", i), err})
+		} else {
+			if matched == nil {
+				matched = validator
+			}
+			matches = append(matches, fmt.Sprint(i))
+		}
+	}
+
+	if len(matches) == 0 {
+		return nil, branchesError("noMatchingOption", "The value doesn't match any of the options", branchErrors, position)
+	}
+	if v.exclusive && len(matches) > 1 {
+		return nil, ValidationError{Path: position, Code: "multipleOptions", Message: "The value matches more than one option (" + strings.Join(matches, ", ") + ")"}
+	}
+
+	return matched, nil
+}
+
+func (v *OptionsValidator) ValidatePatch(patch Patch, position string) error {
+	if patch.IsRootPosition() {
+		switch patch.op {
+		case "remove":
+			if v.IsRequired() {
+				return ValidationError{Path: position, Code: "required", Message: "Cannot remove a required field"}
+			}
+
+			return nil
+		default:
+			return v.Validate(patch.value, position)
+		}
+	}
+
+	// the patch must be valid for one of the options the current value
+	// belongs to, every option is tried if the value is unknown
+	var candidates []int
+	if patch.hasDocument {
+		for i, validator := range v.optionValidators {
+			if validator.Validate(patch.document, position) == nil {
+				candidates = append(candidates, i)
+			}
+		}
+	}
+	if len(candidates) == 0 {
+		for i := range v.optionValidators {
+			candidates = append(candidates, i)
+		}
+	}
+
+	var branchErrors []branchError
+	for _, i := range candidates {
+		err := v.optionValidators[i].ValidatePatch(patch, position)
+		if err == nil {
+			return nil
+		}
+
+		branchErrors = append(branchErrors, branchError{fmt.Sprintf("option %d", i), err})
+	}
+
+	return branchesError("noMatchingOption", "The patch is not valid for any of the options", branchErrors, position)
+}
+
+func (v *OptionsValidator) InitializeAfterUnmarshaling(customTypes map[string]bool, rootValidator *Validator) error {
+	if len(v.Options) == 0 {
+		return fmt.Errorf("A %s validator must have at least one option", v.Type())
+	}
+
+	v.optionValidators = make([]FieldValidator, len(v.Options))
+	for i, option := range v.Options {
+		validator, err := UnmarshalValidator(option, customTypes, rootValidator)
+		if err != nil {
+			return err
+		}
+
+		v.optionValidators[i] = validator
+	}
+
+	return nil
+}
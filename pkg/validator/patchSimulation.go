@@ -0,0 +1,150 @@
+package validator
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// pointerTokens splits a JSON pointer in its unescaped reference tokens
+func pointerTokens(pointer string) ([]string, error) {
+	if pointer == "" {
+		return []string{}, nil
+	} else if pointer[0] != '/' {
+		return nil, fmt.Errorf("Invalid patch path")
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = unescapePointer(token)
+	}
+
+	return tokens, nil
+}
+
+// resolvePointer returns the value at the passed JSON pointer of the document
+func resolvePointer(document interface{}, pointer string) (interface{}, error) {
+	tokens, err := pointerTokens(pointer)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, token := range tokens {
+		switch node := document.(type) {
+		case map[string]interface{}:
+			child, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("The location %s doesn't exist", pointer)
+			}
+			document = child
+		case []interface{}:
+			index, err := strconv.Atoi(token)
+			if err != nil || index < 0 || index >= len(node) {
+				return nil, fmt.Errorf("The location %s doesn't exist", pointer)
+			}
+			document = node[index]
+		default:
+			return nil, fmt.Errorf("The location %s doesn't exist", pointer)
+		}
+	}
+
+	return document, nil
+}
+
+// deepCopy copies a decoded json value, so that the copy can be modified
+// without affecting the original
+func deepCopy(value interface{}) interface{} {
+	switch node := value.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(node))
+		for key, child := range node {
+			copied[key] = deepCopy(child)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(node))
+		for i, child := range node {
+			copied[i] = deepCopy(child)
+		}
+		return copied
+	default:
+		return value
+	}
+}
+
+// applyOperation applies an add, replace or remove operation to the document
+// and returns the updated document, the document is modified in place
+func applyOperation(document interface{}, op string, pointer string, value interface{}) (interface{}, error) {
+	tokens, err := pointerTokens(pointer)
+	if err != nil {
+		return nil, err
+	}
+
+	return applyOperationTokens(document, op, tokens, value, pointer)
+}
+
+func applyOperationTokens(document interface{}, op string, tokens []string, value interface{}, pointer string) (interface{}, error) {
+	if len(tokens) == 0 {
+		if op == "remove" {
+			return nil, nil
+		}
+
+		return value, nil
+	}
+
+	token := tokens[0]
+	last := len(tokens) == 1
+	switch node := document.(type) {
+	case map[string]interface{}:
+		child, exists := node[token]
+		if !exists && (!last || op != "add") {
+			return nil, fmt.Errorf("The location %s doesn't exist", pointer)
+		}
+
+		if !last {
+			updated, err := applyOperationTokens(child, op, tokens[1:], value, pointer)
+			if err != nil {
+				return nil, err
+			}
+			node[token] = updated
+		} else if op == "remove" {
+			delete(node, token)
+		} else {
+			node[token] = value
+		}
+
+		return node, nil
+	case []interface{}:
+		index := len(node)
+		if token != "-" || !last || op != "add" {
+			var err error
+			index, err = strconv.Atoi(token)
+			if err != nil || index < 0 || index > len(node) || (index == len(node) && (!last || op != "add")) {
+				return nil, fmt.Errorf("The location %s doesn't exist", pointer)
+			}
+		}
+
+		if !last {
+			updated, err := applyOperationTokens(node[index], op, tokens[1:], value, pointer)
+			if err != nil {
+				return nil, err
+			}
+			node[index] = updated
+		} else {
+			switch op {
+			case "add":
+				node = append(node, nil)
+				copy(node[index+1:], node[index:])
+				node[index] = value
+			case "replace":
+				node[index] = value
+			case "remove":
+				node = append(node[:index], node[index+1:]...)
+			}
+		}
+
+		return node, nil
+	default:
+		return nil, fmt.Errorf("The location %s doesn't exist", pointer)
+	}
+}
@@ -0,0 +1,114 @@
+package validator
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestPatchSimulation(t *testing.T) {
+	const jsonValidator = `{
+		"type": "object",
+		"fields": {
+			"name": {"type": "string", "required": true},
+			"nickname": {"type": "string"},
+			"age": {"type": "integer"},
+			"tags": {
+				"type": "array",
+				"elements": {"type": "string"},
+				"minElements": 1,
+				"maxElements": 2
+			}
+		}
+	}`
+
+	var v Validator
+	err := json.Unmarshal([]byte(jsonValidator), &v)
+	if err != nil {
+		panic(err)
+	}
+
+	const document = `{"name": "Andrea", "age": 30, "tags": ["a"]}`
+
+	t.Run("Copy", func(t *testing.T) {
+		err = v.ValidatePatchesWithDocument([]byte(document), []byte(`[{"op": "copy", "from": "/name", "path": "/nickname"}]`))
+		if err != nil {
+			t.Error(err)
+		}
+
+		err = v.ValidatePatchesWithDocument([]byte(document), []byte(`[{"op": "copy", "from": "/age", "path": "/nickname"}]`))
+		if err == nil {
+			t.Error("Copying a number into a string field should be rejected")
+		}
+
+		err = v.ValidatePatchesWithDocument([]byte(document), []byte(`[{"op": "copy", "from": "/surname", "path": "/nickname"}]`))
+		if err == nil {
+			t.Error("The source of the copy doesn't exist")
+		}
+	})
+	t.Run("Move", func(t *testing.T) {
+		err = v.ValidatePatchesWithDocument([]byte(`{"name": "Andrea", "nickname": "Andre"}`), []byte(`[{"op": "move", "from": "/nickname", "path": "/name"}]`))
+		if err != nil {
+			t.Error(err)
+		}
+
+		err = v.ValidatePatchesWithDocument([]byte(document), []byte(`[{"op": "move", "from": "/name", "path": "/nickname"}]`))
+		if err == nil {
+			t.Error("Moving a required field away should be rejected")
+		}
+
+		err = v.ValidatePatches([]byte(`[{"op": "move", "from": "/nickname", "path": "/name"}]`))
+		if err == nil {
+			t.Error("A move cannot be validated without the document")
+		}
+	})
+	t.Run("Test", func(t *testing.T) {
+		err = v.ValidatePatches([]byte(`[{"op": "test", "path": "/surname", "value": 3}]`))
+		if err != nil {
+			t.Error(err)
+		}
+	})
+	t.Run("Sequential patches", func(t *testing.T) {
+		err = v.ValidatePatchesWithDocument([]byte(document), []byte(`[
+			{"op": "add", "path": "/nickname", "value": "Andre"},
+			{"op": "copy", "from": "/nickname", "path": "/name"},
+			{"op": "remove", "path": "/nickname"}
+		]`))
+		if err != nil {
+			t.Error(err)
+		}
+
+		err = v.ValidatePatchesWithDocument([]byte(document), []byte(`[{"op": "replace", "path": "/nickname", "value": "Andre"}]`))
+		if err == nil {
+			t.Error("Replacing a missing field should be rejected")
+		}
+	})
+	t.Run("Array limits", func(t *testing.T) {
+		err = v.ValidatePatchesWithDocument([]byte(document), []byte(`[{"op": "add", "path": "/tags/-", "value": "b"}]`))
+		if err != nil {
+			t.Error(err)
+		}
+
+		err = v.ValidatePatchesWithDocument([]byte(document), []byte(`[
+			{"op": "add", "path": "/tags/-", "value": "b"},
+			{"op": "add", "path": "/tags/-", "value": "c"}
+		]`))
+		if err == nil {
+			t.Error("Appending should not bypass maxElements")
+		}
+
+		err = v.ValidatePatchesWithDocument([]byte(`{"name": "Andrea", "tags": ["a", "b"]}`), []byte(`[{"op": "add", "path": "/tags/0", "value": "c"}]`))
+		if err == nil {
+			t.Error("Inserting should not bypass maxElements")
+		}
+
+		err = v.ValidatePatchesWithDocument([]byte(document), []byte(`[{"op": "remove", "path": "/tags/0"}]`))
+		if err == nil {
+			t.Error("Removing should not bypass minElements")
+		}
+
+		err = v.ValidatePatchesWithDocument([]byte(document), []byte(`[{"op": "replace", "path": "/tags/1", "value": "b"}]`))
+		if err == nil {
+			t.Error("The position 1 is outside the array")
+		}
+	})
+}
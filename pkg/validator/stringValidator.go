@@ -1,17 +1,26 @@
 package validator
 
 import (
+	"encoding/json"
 	"fmt"
 	"regexp"
 )
 
 type StringValidator struct {
+	regexMatch    *regexp.Regexp
+	noRegexMatch  *regexp.Regexp
+	allowedValues map[string]bool
+	formatChecker FormatChecker
 	Required      bool
+	Nullable      bool
+	Default       json.RawMessage // materialized when the field is missing
+	Trim          bool            // removes the leading and trailing whitespace when normalizing
 	MaxChars      *int
 	MinChars      *int
 	RegexMatch    *string // checks that the string matches the given regex
 	NoRegexMatch  *string // checks that the string doesn't match the given regex
 	AllowedValues *[]string
+	Format        *string // checks that the string has one of the builtin or registered formats
 }
 
 func (v *StringValidator) Type() string {
@@ -23,49 +32,38 @@ func (v *StringValidator) IsRequired() bool {
 }
 
 func (v *StringValidator) Validate(json interface{}, position string) error {
+	if json == nil && v.Nullable {
+		return nil
+	}
+
 	jsonString, ok := json.(string)
 
 	if !ok {
-		return ValidationError{"This field is not an string", position}
+		return ValidationError{Path: position, Code: "type", Params: map[string]interface{}{"type": "string"}, Message: "This field is not an string"}
 	}
 
 	if v.MaxChars != nil && len(jsonString) > *v.MaxChars {
-		return ValidationError{fmt.Sprintf("This string is longer than maxChars (%d)", *v.MaxChars), position}
+		return ValidationError{Path: position, Code: "maxChars", Params: map[string]interface{}{"maxChars": *v.MaxChars}, Message: fmt.Sprintf("This string is longer than maxChars (%d)", *v.MaxChars)}
 	}
 	if v.MinChars != nil && len(jsonString) < *v.MinChars {
-		return ValidationError{fmt.Sprintf("This string is shorter than minChars (%d)", *v.MinChars), position}
+		return ValidationError{Path: position, Code: "minChars", Params: map[string]interface{}{"minChars": *v.MinChars}, Message: fmt.Sprintf("This string is shorter than minChars (%d)", *v.MinChars)}
 	}
-	if v.RegexMatch != nil {
-		r, err := regexp.Compile(*v.RegexMatch)
-		if err != nil {
-			return ValidationError{"The validator for this field has an invalid RegexMatch", position}
-		}
-
-		if !r.MatchString(jsonString) {
-			return ValidationError{fmt.Sprintf("This string does not match the RegexMatch field: %s", *v.RegexMatch), position}
+	if v.regexMatch != nil {
+		if !v.regexMatch.MatchString(jsonString) {
+			return ValidationError{Path: position, Code: "regexMatch", Params: map[string]interface{}{"regexMatch": *v.RegexMatch}, Message: fmt.Sprintf("This string does not match the RegexMatch field: %s", *v.RegexMatch)}
 		}
 	}
-	if v.NoRegexMatch != nil {
-		r, err := regexp.Compile(*v.NoRegexMatch)
-		if err != nil {
-			return ValidationError{"The validator for this field has an invalid NoRegexMatch", position}
-		}
-
-		if r.MatchString(jsonString) {
-			return ValidationError{fmt.Sprintf("This string matches the NoRegexMatch field: %s", *v.NoRegexMatch), position}
+	if v.noRegexMatch != nil {
+		if v.noRegexMatch.MatchString(jsonString) {
+			return ValidationError{Path: position, Code: "noRegexMatch", Params: map[string]interface{}{"noRegexMatch": *v.NoRegexMatch}, Message: fmt.Sprintf("This string matches the NoRegexMatch field: %s", *v.NoRegexMatch)}
 		}
 	}
-	if v.AllowedValues != nil {
-		found := false
-		for _, allowedValue := range *v.AllowedValues {
-			if allowedValue == jsonString {
-				found = true
-				break
-			}
-		}
-
-		if !found {
-			return ValidationError{"The value is not in the AllowedValues", position}
+	if v.formatChecker != nil && !v.formatChecker(jsonString) {
+		return ValidationError{Path: position, Code: "format", Params: map[string]interface{}{"format": *v.Format}, Message: fmt.Sprintf("This string is not a valid %s", *v.Format)}
+	}
+	if v.allowedValues != nil {
+		if !v.allowedValues[jsonString] {
+			return ValidationError{Path: position, Code: "allowedValues", Params: map[string]interface{}{"allowedValues": *v.AllowedValues}, Message: "The value is not in the AllowedValues"}
 		}
 	}
 
@@ -77,7 +75,7 @@ func (v *StringValidator) ValidatePatch(patch Patch, position string) error {
 		switch patch.op {
 		case "remove":
 			if v.IsRequired() {
-				return ValidationError{"Cannot remove a required field", position}
+				return ValidationError{Path: position, Code: "required", Message: "Cannot remove a required field"}
 			}
 
 			return nil
@@ -85,10 +83,38 @@ func (v *StringValidator) ValidatePatch(patch Patch, position string) error {
 			return v.Validate(patch.value, position)
 		}
 	} else {
-		return ValidationError{"Cannot access a field inside a string", position}
+		return ValidationError{Path: position, Code: "invalidPath", Message: "Cannot access a field inside a string"}
 	}
 }
 
 func (v *StringValidator) InitializeAfterUnmarshaling(customTypes map[string]bool, rootValidator *Validator) error {
+	if v.RegexMatch != nil {
+		r, err := regexp.Compile(*v.RegexMatch)
+		if err != nil {
+			return fmt.Errorf("The RegexMatch %s is not a valid regular expression: %s", *v.RegexMatch, err.Error())
+		}
+		v.regexMatch = r
+	}
+	if v.NoRegexMatch != nil {
+		r, err := regexp.Compile(*v.NoRegexMatch)
+		if err != nil {
+			return fmt.Errorf("The NoRegexMatch %s is not a valid regular expression: %s", *v.NoRegexMatch, err.Error())
+		}
+		v.noRegexMatch = r
+	}
+	if v.Format != nil {
+		checker, ok := lookupFormat(*v.Format)
+		if !ok {
+			return fmt.Errorf("The format %s is not supported", *v.Format)
+		}
+		v.formatChecker = checker
+	}
+	if v.AllowedValues != nil {
+		v.allowedValues = make(map[string]bool, len(*v.AllowedValues))
+		for _, allowedValue := range *v.AllowedValues {
+			v.allowedValues[allowedValue] = true
+		}
+	}
+
 	return nil
 }
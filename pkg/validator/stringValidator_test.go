@@ -2,6 +2,7 @@ package validator
 
 import (
 	"encoding/json"
+	"strings"
 	"testing"
 )
 
@@ -134,3 +135,79 @@ func TestAllowedValuesStringValidation(t *testing.T) {
 		}
 	})
 }
+
+func TestInvalidRegexStringValidator(t *testing.T) {
+	for _, jsonValidator := range []string{
+		`{"type": "string", "regexMatch": "^[a-z"}`,
+		`{"type": "string", "noRegexMatch": "(abc"}`,
+	} {
+		var v Validator
+		err := json.Unmarshal([]byte(jsonValidator), &v)
+		if err == nil {
+			t.Errorf("The schema %s has an invalid regex but was loaded", jsonValidator)
+		}
+	}
+}
+
+func TestFormatStringValidation(t *testing.T) {
+	cases := map[string]struct {
+		valid   []string
+		invalid []string
+	}{
+		"email":     {[]string{"andrea@igloo.ooo"}, []string{"andrea", "Andrea <andrea@igloo.ooo>"}},
+		"uri":       {[]string{"https://igloo.ooo/path?q=1"}, []string{"igloo.ooo", "://x"}},
+		"uuid":      {[]string{"123e4567-e89b-12d3-a456-426614174000"}, []string{"123e4567e89b12d3a456426614174000"}},
+		"date":      {[]string{"2021-02-28"}, []string{"2021-02-30", "28/02/2021"}},
+		"date-time": {[]string{"2021-02-28T10:00:00Z", "2021-02-28T10:00:00.5+01:00"}, []string{"2021-02-28 10:00:00"}},
+		"objectId":  {[]string{"5f8d0d55b54764421b7156c9"}, []string{"5f8d0d55b54764421b7156c", "zf8d0d55b54764421b7156c9"}},
+		"ipv4":      {[]string{"192.168.1.1"}, []string{"256.1.1.1", "::1"}},
+		"ipv6":      {[]string{"::1", "2001:db8::ff00:42:8329"}, []string{"192.168.1.1"}},
+		"hexColor":  {[]string{"#fff", "#A0B1C2"}, []string{"fff", "#ggg"}},
+	}
+
+	for format, c := range cases {
+		var v Validator
+		err := json.Unmarshal([]byte(`{"type": "string", "format": "`+format+`"}`), &v)
+		if err != nil {
+			panic(err)
+		}
+
+		t.Run(format, func(t *testing.T) {
+			for _, value := range c.valid {
+				encoded, _ := json.Marshal(value)
+				if err := v.Validate(encoded); err != nil {
+					t.Errorf("%s should be a valid %s: %s", value, format, err)
+				}
+			}
+			for _, value := range c.invalid {
+				encoded, _ := json.Marshal(value)
+				if v.Validate(encoded) == nil {
+					t.Errorf("%s should not be a valid %s", value, format)
+				}
+			}
+		})
+	}
+}
+
+func TestCustomFormatStringValidation(t *testing.T) {
+	var v Validator
+	err := json.Unmarshal([]byte(`{"type": "string", "format": "upper"}`), &v)
+	if err == nil {
+		t.Error("The format upper is not registered but the schema was loaded")
+	}
+
+	RegisterFormat("upper", func(s string) bool {
+		return strings.ToUpper(s) == s
+	})
+	err = json.Unmarshal([]byte(`{"type": "string", "format": "upper"}`), &v)
+	if err != nil {
+		panic(err)
+	}
+
+	if err := v.Validate([]byte(`"ABC"`)); err != nil {
+		t.Error(err)
+	}
+	if v.Validate([]byte(`"abc"`)) == nil {
+		t.Error("abc is not uppercase")
+	}
+}
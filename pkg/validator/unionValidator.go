@@ -0,0 +1,173 @@
+package validator
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// UnionValidator validates objects whose schema is chosen by the value of
+// the Tag field. The variants describe the fields of the object other than
+// the tag
+type UnionValidator struct {
+	variantValidators map[string]FieldValidator
+	variantTags       []string
+	Tag               string
+	Variants          map[string]json.RawMessage
+	Required          bool
+	Nullable          bool
+	Default           json.RawMessage // materialized when the field is missing
+}
+
+func (v *UnionValidator) Type() string {
+	return "union"
+}
+
+func (v *UnionValidator) IsRequired() bool {
+	return v.Required
+}
+
+// variantNames returns the tags of the variants in a stable order
+func (v *UnionValidator) variantNames() []string {
+	return v.variantTags
+}
+
+// variant returns the validator of the variant selected by the tag of the
+// object and the object without the tag
+func (v *UnionValidator) variant(jsonObject map[string]interface{}, position string) (FieldValidator, map[string]interface{}, error) {
+	tagValue, ok := jsonObject[v.Tag]
+	if !ok {
+		return nil, nil, ValidationError{Path: position + "/" + escapePointer(v.Tag), Code: "required", Message: "Required field " + v.Tag + " is missing"}
+	}
+	tag, ok := tagValue.(string)
+	if !ok {
+		return nil, nil, ValidationError{Path: position + "/" + escapePointer(v.Tag), Code: "type", Params: map[string]interface{}{"type": "string"}, Message: "This field is not a string"}
+	}
+	validator, ok := v.variantValidators[tag]
+	if !ok {
+		return nil, nil, ValidationError{Path: position + "/" + escapePointer(v.Tag), Code: "invalidVariant", Params: map[string]interface{}{"variants": v.variantNames()}, Message: fmt.Sprintf("The value %s is not one of the variants %v", tag, v.variantNames())}
+	}
+
+	fields := make(map[string]interface{}, len(jsonObject))
+	for key, value := range jsonObject {
+		if key != v.Tag {
+			fields[key] = value
+		}
+	}
+
+	return validator, fields, nil
+}
+
+func (v *UnionValidator) Validate(json interface{}, position string) error {
+	if json == nil && v.Nullable {
+		return nil
+	}
+
+	jsonObject, ok := json.(map[string]interface{})
+	if !ok {
+		return ValidationError{Path: position, Code: "type", Params: map[string]interface{}{"type": "object"}, Message: "This field is not an object"}
+	}
+
+	validator, fields, err := v.variant(jsonObject, position)
+	if err != nil {
+		return err
+	}
+
+	return validator.Validate(fields, position)
+}
+
+func (v *UnionValidator) ValidatePatch(patch Patch, position string) error {
+	if patch.IsRootPosition() {
+		switch patch.op {
+		case "remove":
+			if v.IsRequired() {
+				return ValidationError{Path: position, Code: "required", Message: "Cannot remove a required field"}
+			}
+
+			return nil
+		default:
+			return v.Validate(patch.value, position)
+		}
+	}
+
+	current, hasCurrent := patch.document.(map[string]interface{})
+	hasCurrent = hasCurrent && patch.hasDocument
+
+	fieldPatch := patch
+	field, err := fieldPatch.UnshiftPosition()
+	if err != nil {
+		return ValidationError{Path: position, Code: "invalidPath", Message: err.Error()}
+	}
+
+	// changing the tag switches the variant, the other fields must be
+	// valid for the new one
+	if field == v.Tag {
+		if patch.op == "remove" {
+			return ValidationError{Path: position, Code: "tagRemoved", Message: "Cannot remove the tag of a union"}
+		} else if !fieldPatch.IsRootPosition() {
+			return ValidationError{Path: position + "/" + escapePointer(field), Code: "invalidPath", Message: "Cannot access a field inside a string"}
+		}
+		tag, ok := patch.value.(string)
+		if !ok {
+			return ValidationError{Path: position + "/" + escapePointer(field), Code: "type", Params: map[string]interface{}{"type": "string"}, Message: "This field is not a string"}
+		} else if _, ok := v.variantValidators[tag]; !ok {
+			return ValidationError{Path: position + "/" + escapePointer(field), Code: "invalidVariant", Params: map[string]interface{}{"variants": v.variantNames()}, Message: fmt.Sprintf("The value %s is not one of the variants %v", tag, v.variantNames())}
+		}
+		if !hasCurrent {
+			return nil
+		}
+
+		changed := make(map[string]interface{}, len(current))
+		for key, value := range current {
+			changed[key] = value
+		}
+		changed[v.Tag] = tag
+		if err := v.Validate(changed, position); err != nil {
+			return branchesError("invalidVariant", "The fields of the object are not valid for the variant "+tag, []branchError{{tag, err}}, position)
+		}
+
+		return nil
+	}
+
+	// the patch is validated against the variant of the current value, or
+	// against every variant if the value is unknown
+	if hasCurrent {
+		if validator, _, err := v.variant(current, position); err == nil {
+			return validator.ValidatePatch(patch, position)
+		}
+	}
+
+	var branchErrors []branchError
+	for _, name := range v.variantNames() {
+		err := v.variantValidators[name].ValidatePatch(patch, position)
+		if err == nil {
+			return nil
+		}
+
+		branchErrors = append(branchErrors, branchError{name, err})
+	}
+
+	return branchesError("noMatchingVariant", "The patch is not valid for any of the variants", branchErrors, position)
+}
+
+func (v *UnionValidator) InitializeAfterUnmarshaling(customTypes map[string]bool, rootValidator *Validator) error {
+	if v.Tag == "" {
+		return fmt.Errorf("A union validator must specify the tag field")
+	} else if len(v.Variants) == 0 {
+		return fmt.Errorf("A union validator must have at least one variant")
+	}
+
+	v.variantValidators = make(map[string]FieldValidator, len(v.Variants))
+	for name, variant := range v.Variants {
+		validator, err := UnmarshalValidator(variant, customTypes, rootValidator)
+		if err != nil {
+			return err
+		}
+
+		v.variantValidators[name] = validator
+		v.variantTags = append(v.variantTags, name)
+	}
+	sort.Strings(v.variantTags)
+
+	return nil
+}
@@ -0,0 +1,176 @@
+package validator
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestOptionsValidation(t *testing.T) {
+	const jsonValidator = `{
+		"type": "object",
+		"fields": {
+			"one": {
+				"type": "oneOf",
+				"options": [
+					{"type": "string"},
+					{"type": "float", "min": 0}
+				]
+			},
+			"any": {
+				"type": "anyOf",
+				"options": [
+					{"type": "float", "min": 0},
+					{"type": "integer"}
+				]
+			}
+		}
+	}`
+
+	var v Validator
+	err := json.Unmarshal([]byte(jsonValidator), &v)
+	if err != nil {
+		panic(err)
+	}
+
+	t.Run("Valid options", func(t *testing.T) {
+		err = v.Validate([]byte(`{"one": "hello", "any": 3}`))
+		if err != nil {
+			t.Error(err)
+		}
+	})
+	t.Run("Invalid option", func(t *testing.T) {
+		err = v.Validate([]byte(`{"one": -2, "any": 3}`))
+		if err == nil {
+			t.Error("-2 should not match any option")
+		} else if !strings.Contains(err.Error(), "option 0") || !strings.Contains(err.Error(), "option 1") {
+			t.Errorf("The error should explain why each option failed: %s", err)
+		}
+	})
+	t.Run("Ambiguous oneOf", func(t *testing.T) {
+		var exclusive Validator
+		err := json.Unmarshal([]byte(`{"type": "oneOf", "options": [{"type": "float"}, {"type": "integer"}]}`), &exclusive)
+		if err != nil {
+			panic(err)
+		}
+
+		err = exclusive.Validate([]byte("3"))
+		if err == nil {
+			t.Error("3 matches both options of a oneOf")
+		}
+	})
+	t.Run("Patch option", func(t *testing.T) {
+		err = v.ValidatePatches([]byte(`[{"op": "replace", "path": "/one", "value": 4}]`))
+		if err != nil {
+			t.Error(err)
+		}
+
+		err = v.ValidatePatches([]byte(`[{"op": "replace", "path": "/any", "value": "x"}]`))
+		if err == nil {
+			t.Error("The string x should not match any option")
+		}
+	})
+}
+
+func TestUnionValidation(t *testing.T) {
+	const jsonValidator = `{
+		"type": "union",
+		"tag": "kind",
+		"variants": {
+			"circle": {
+				"type": "object",
+				"fields": {
+					"radius": {"type": "float", "required": true, "min": 0}
+				}
+			},
+			"rectangle": {
+				"type": "object",
+				"fields": {
+					"width": {"type": "float", "required": true},
+					"height": {"type": "float", "required": true}
+				}
+			}
+		}
+	}`
+
+	var v Validator
+	err := json.Unmarshal([]byte(jsonValidator), &v)
+	if err != nil {
+		panic(err)
+	}
+
+	t.Run("Valid variant", func(t *testing.T) {
+		err = v.Validate([]byte(`{"kind": "circle", "radius": 2}`))
+		if err != nil {
+			t.Error(err)
+		}
+	})
+	t.Run("Invalid variant fields", func(t *testing.T) {
+		err = v.Validate([]byte(`{"kind": "circle", "width": 2}`))
+		if err == nil {
+			t.Error("A circle should not have a width")
+		}
+	})
+	t.Run("Unknown tag", func(t *testing.T) {
+		err = v.Validate([]byte(`{"kind": "triangle"}`))
+		if err == nil {
+			t.Error("triangle is not a variant")
+		}
+	})
+	t.Run("Missing tag", func(t *testing.T) {
+		err = v.Validate([]byte(`{"radius": 2}`))
+		if err == nil {
+			t.Error("The tag is required")
+		}
+	})
+
+	const circle = `{"kind": "circle", "radius": 2}`
+	t.Run("Patch current variant", func(t *testing.T) {
+		err = v.ValidatePatchesWithDocument([]byte(circle), []byte(`[{"op": "replace", "path": "/radius", "value": 3}]`))
+		if err != nil {
+			t.Error(err)
+		}
+
+		err = v.ValidatePatchesWithDocument([]byte(circle), []byte(`[{"op": "add", "path": "/width", "value": 3}]`))
+		if err == nil {
+			t.Error("A circle should not accept a width")
+		}
+	})
+	t.Run("Patch tag", func(t *testing.T) {
+		err = v.ValidatePatchesWithDocument(
+			[]byte(`{"kind": "circle", "radius": 2}`),
+			[]byte(`[{"op": "replace", "path": "/kind", "value": "rectangle"}]`),
+		)
+		if err == nil {
+			t.Error("A circle cannot become a rectangle without width and height")
+		} else if !strings.Contains(err.Error(), "rectangle") {
+			t.Errorf("The error should refer to the new variant: %s", err)
+		}
+
+		err = v.ValidatePatchesWithDocument(
+			[]byte(`{"kind": "circle"}`),
+			[]byte(`[{"op": "replace", "path": "/kind", "value": "circle"}]`),
+		)
+		if err == nil {
+			t.Error("The object is not a valid circle")
+		}
+
+		err = v.ValidatePatches([]byte(`[{"op": "remove", "path": "/kind"}]`))
+		if err == nil {
+			t.Error("The tag cannot be removed")
+		}
+	})
+	t.Run("Patch without document", func(t *testing.T) {
+		err = v.ValidatePatches([]byte(`[{"op": "replace", "path": "/height", "value": 3}]`))
+		if err != nil {
+			t.Error(err)
+		}
+
+		err = v.ValidatePatches([]byte(`[{"op": "replace", "path": "/depth", "value": 3}]`))
+		if err == nil {
+			t.Error("No variant has a depth")
+		} else if !strings.Contains(err.Error(), "circle") || !strings.Contains(err.Error(), "rectangle") {
+			t.Errorf("The error should explain why each variant failed: %s", err)
+		}
+	})
+}
@@ -3,26 +3,53 @@ package validator
 import (
 	"encoding/json"
 	"fmt"
+	"strconv"
 	"strings"
 )
 
+// ValidationError describes why a value doesn't match the schema, it can be
+// serialized to JSON to report it to the clients
 type ValidationError struct {
-	message  string
-	position string
+	// Path is the JSON Pointer of the invalid value
+	Path string `json:"path"`
+	// Patch is the index of the invalid patch when validating patches
+	Patch *int `json:"patch,omitempty"`
+	// Code identifies the violated constraint, e.g. maxChars or required
+	Code string `json:"code"`
+	// Params are the values of the violated constraint
+	Params  map[string]interface{} `json:"params,omitempty"`
+	Message string                 `json:"message"`
+	// Branches are the errors of each option or variant when the value
+	// doesn't match any of them
+	Branches map[string][]ValidationError `json:"branches,omitempty"`
 }
 
 func (e ValidationError) Error() string {
-	return "[" + e.position + "] " + e.message
+	position := "$" + e.Path
+	if e.Patch != nil {
+		position = fmt.Sprintf("Patch %d %s", *e.Patch, e.Path)
+	}
+
+	return "[" + position + "] " + e.Message
 }
 
 type CompositeValidationError struct {
-	errors []error
+	Errors []ValidationError `json:"errors"`
+}
+
+// add appends the validation errors contained in err
+func (e *CompositeValidationError) add(err error) {
+	if errors := ValidationErrors(err); errors != nil {
+		e.Errors = append(e.Errors, errors...)
+	} else {
+		e.Errors = append(e.Errors, ValidationError{Code: "invalid", Message: err.Error()})
+	}
 }
 
 func (e CompositeValidationError) Error() string {
 	message := ""
 
-	for _, err := range e.errors {
+	for _, err := range e.Errors {
 		if message != "" {
 			message += "\n" + err.Error()
 		} else {
@@ -33,10 +60,47 @@ func (e CompositeValidationError) Error() string {
 	return message
 }
 
+// ValidationErrors returns the list of validation errors contained in the
+// error returned by a Validator, or nil if err is not a validation error
+func ValidationErrors(err error) []ValidationError {
+	switch e := err.(type) {
+	case ValidationError:
+		return []ValidationError{e}
+	case CompositeValidationError:
+		return e.Errors
+	default:
+		return nil
+	}
+}
+
+// withPatchIndex sets the index of the patch on the validation errors
+func withPatchIndex(err error, index int) error {
+	errors := ValidationErrors(err)
+	if errors == nil {
+		return err
+	}
+
+	composite := CompositeValidationError{}
+	for _, e := range errors {
+		e.Patch = &index
+		composite.Errors = append(composite.Errors, e)
+	}
+	if len(composite.Errors) == 1 {
+		return composite.Errors[0]
+	}
+
+	return composite
+}
+
 type Patch struct {
 	op    string
 	path  string
+	from  string
 	value interface{}
+	// document is the current value at the position reached by the patch,
+	// it is available only if hasDocument is true
+	document    interface{}
+	hasDocument bool
 }
 
 func (p *Patch) UnmarshalJSON(data []byte) error {
@@ -54,7 +118,12 @@ func (p *Patch) UnmarshalJSON(data []byte) error {
 			return fmt.Errorf("Patch field \"op\" is not a string")
 		}
 
-		p.op = opString
+		switch opString {
+		case "add", "remove", "replace", "move", "copy", "test":
+			p.op = opString
+		default:
+			return fmt.Errorf("Patch operation \"%s\" is not supported", opString)
+		}
 	} else {
 		return fmt.Errorf("Patch field \"op\" not specified")
 	}
@@ -75,6 +144,16 @@ func (p *Patch) UnmarshalJSON(data []byte) error {
 		p.value = value
 	}
 
+	if p.op == "move" || p.op == "copy" {
+		fromString, ok := patchMap["from"].(string)
+
+		if !ok {
+			return fmt.Errorf("Patch field \"from\" not specified or not a string")
+		}
+
+		p.from = fromString
+	}
+
 	return nil
 }
 
@@ -82,6 +161,8 @@ func (p *Patch) IsRootPosition() bool {
 	return p.path == ""
 }
 
+// UnshiftPosition removes the first reference token from the path of the
+// patch and returns it unescaped
 func (p *Patch) UnshiftPosition() (string, error) {
 	if p.IsRootPosition() {
 		panic("UnshiftPosition should not be called if patch is at root position")
@@ -98,20 +179,46 @@ func (p *Patch) UnshiftPosition() (string, error) {
 	// Case 0 is when the original path was /
 	case 0:
 		p.path = ""
+		p.descendDocument("")
 		return "", nil
 
 	// Case 1 is when the original path was /something
 	case 1:
+		s := unescapePointer(parts[0])
 		p.path = ""
-		return parts[0], nil
+		p.descendDocument(s)
+		return s, nil
 
 	// Case 2 is when the original path was /something/somethingelse
 	case 2:
-		s := strings.Replace(parts[0], "/", "", 1)
+		s := unescapePointer(strings.Replace(parts[0], "/", "", 1))
 		p.path = "/" + parts[1]
+		p.descendDocument(s)
 
 		return s, nil
 	}
 
 	panic("This should never be reached")
 }
+
+// descendDocument moves the document of the patch to the child with the
+// passed key, the document becomes unavailable if the child doesn't exist
+func (p *Patch) descendDocument(key string) {
+	if !p.hasDocument {
+		return
+	}
+
+	switch document := p.document.(type) {
+	case map[string]interface{}:
+		p.document, p.hasDocument = document[key]
+	case []interface{}:
+		index, err := strconv.Atoi(key)
+		if err != nil || index < 0 || index >= len(document) {
+			p.document, p.hasDocument = nil, false
+		} else {
+			p.document = document[index]
+		}
+	default:
+		p.document, p.hasDocument = nil, false
+	}
+}
@@ -3,6 +3,7 @@ package validator
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 )
 
 type FieldValidator interface {
@@ -16,11 +17,17 @@ type FieldValidator interface {
 type Validator struct {
 	fieldValidator FieldValidator
 	customTypes map[string]FieldValidator
+	// defaultValidators are the validators with a default value, the defaults
+	// are checked once the whole schema is loaded
+	defaultValidators []FieldValidator
 }
 
 type CustomValidator struct{
 	sourceValidator *Validator
 	fieldName string
+	nullable bool
+	required bool
+	defaultValue json.RawMessage
 }
 
 func (v *CustomValidator) Type() string{
@@ -28,10 +35,18 @@ func (v *CustomValidator) Type() string{
 }
 
 func (v *CustomValidator) Validate(json interface{}, position string) error {
+	if json == nil && v.nullable {
+		return nil
+	}
+
 	return v.sourceValidator.customTypes[v.fieldName].Validate(json, position)
 }
 
 func (v *CustomValidator) ValidatePatch(patch Patch, position string) error {
+	if patch.IsRootPosition() && patch.op != "remove" && patch.value == nil && v.nullable {
+		return nil
+	}
+
 	return v.sourceValidator.customTypes[v.fieldName].ValidatePatch(patch, position)
 }
 
@@ -40,7 +55,7 @@ func (v *CustomValidator) InitializeAfterUnmarshaling(customTypes map[string]boo
 }
 
 func (v *CustomValidator) IsRequired() bool {
-	return v.sourceValidator.customTypes[v.fieldName].IsRequired()
+	return v.required || v.sourceValidator.customTypes[v.fieldName].IsRequired()
 }
 
 func (v *Validator) UnmarshalJSON(data []byte) error {
@@ -60,6 +75,7 @@ func (v *Validator) UnmarshalJSON(data []byte) error {
 	}
 
 	v.customTypes = make(map[string]FieldValidator)
+	v.defaultValidators = nil
 	for key, source := range validatorType.CustomTypes{
 		keyValidator, err := UnmarshalValidator(source, typesList, v)
 		if err != nil{
@@ -77,7 +93,7 @@ func (v *Validator) UnmarshalJSON(data []byte) error {
 	}
 
 	v.fieldValidator = validator
-	return nil
+	return v.checkDefaults()
 }
 
 func (v *Validator) Validate(jsonSource []byte) error {
@@ -88,9 +104,32 @@ func (v *Validator) Validate(jsonSource []byte) error {
 		return fmt.Errorf("Failed to parse json:\n" + err.Error())
 	}
 
-	return v.fieldValidator.Validate(decodedJson, "$")
+	return v.fieldValidator.Validate(decodedJson, "")
+}
+
+// Normalize materializes the default values of the missing fields and applies
+// the coercions of the schema to the document, then validates it and returns
+// the normalized document
+func (v *Validator) Normalize(jsonSource []byte) ([]byte, error) {
+	var decodedJson interface{}
+	err := json.Unmarshal([]byte(jsonSource), &decodedJson)
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to parse json:\n" + err.Error())
+	}
+
+	normalized := normalize(v.fieldValidator, decodedJson)
+	err = v.fieldValidator.Validate(normalized, "")
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(normalized)
 }
 
+// ValidatePatches validates the patches without knowing the document they
+// will be applied to, so move and copy operations and the cross-field
+// constraints cannot be validated
 func (v *Validator) ValidatePatches(jsonPatches []byte) error {
 	var patches []Patch
 	err := json.Unmarshal([]byte(jsonPatches), &patches)
@@ -101,10 +140,21 @@ func (v *Validator) ValidatePatches(jsonPatches []byte) error {
 	compositeError := CompositeValidationError{}
 	returnError := false
 	for id, patch := range patches {
-		err := v.fieldValidator.ValidatePatch(patch, fmt.Sprintf("Patch %d ", id))
+		position := ""
+
+		var err error
+		switch patch.op {
+		case "test":
+			// test operations don't modify the document
+			continue
+		case "move", "copy":
+			err = ValidationError{Path: position, Code: "unsupportedOperation", Message: "The " + patch.op + " operation can be validated only against the stored document"}
+		default:
+			err = v.fieldValidator.ValidatePatch(patch, position)
+		}
 
 		if err != nil {
-			compositeError.errors = append(compositeError.errors, err)
+			compositeError.add(withPatchIndex(err, id))
 			returnError = true
 		}
 	}
@@ -116,6 +166,93 @@ func (v *Validator) ValidatePatches(jsonPatches []byte) error {
 	}
 }
 
+// ValidatePatchesWithDocument validates the patches simulating them on the
+// document they will be applied to, so each patch is validated against the
+// document updated by the previous ones and the cross-field constraints
+// against the patched document
+func (v *Validator) ValidatePatchesWithDocument(jsonDocument []byte, jsonPatches []byte) error {
+	var document interface{}
+	err := json.Unmarshal(jsonDocument, &document)
+	if err != nil {
+		return fmt.Errorf("Failed to parse json:\n" + err.Error())
+	}
+
+	var patches []Patch
+	err = json.Unmarshal([]byte(jsonPatches), &patches)
+	if err != nil {
+		return fmt.Errorf("Could not parse json patches: " + err.Error())
+	}
+
+	compositeError := CompositeValidationError{}
+	for id, patch := range patches {
+		var err error
+		document, err = v.simulatePatch(document, patch, "")
+
+		if err != nil {
+			compositeError.add(withPatchIndex(err, id))
+		}
+	}
+
+	// the cross-field constraints are checked on the patched document, they
+	// can be broken by the combination of several patches
+	if len(compositeError.Errors) == 0 {
+		crossFieldErrors(v.fieldValidator, document, "", &compositeError)
+	}
+
+	if len(compositeError.Errors) > 0 {
+		return compositeError
+	}
+
+	return nil
+}
+
+// simulatePatch validates the patch against the document and returns the
+// document with the patch applied. A move is validated as the removal of the
+// source followed by the addition of its value at the destination and a copy
+// as the addition of the source value
+func (v *Validator) simulatePatch(document interface{}, patch Patch, position string) (interface{}, error) {
+	switch patch.op {
+	case "test":
+		return document, nil
+	case "move", "copy":
+		value, err := resolvePointer(document, patch.from)
+		if err != nil {
+			return document, ValidationError{Path: position, Code: "invalidPath", Message: err.Error()}
+		}
+
+		if patch.op == "move" {
+			if patch.path == patch.from {
+				return document, nil
+			} else if strings.HasPrefix(patch.path, patch.from+"/") {
+				return document, ValidationError{Path: position, Code: "invalidPath", Message: "Cannot move a value inside itself"}
+			}
+
+			document, err = v.simulatePatch(document, Patch{op: "remove", path: patch.from}, position)
+			if err != nil {
+				return document, err
+			}
+		} else {
+			value = deepCopy(value)
+		}
+
+		return v.simulatePatch(document, Patch{op: "add", path: patch.path, value: value}, position)
+	}
+
+	patch.document = document
+	patch.hasDocument = true
+	err := v.fieldValidator.ValidatePatch(patch, position)
+	if err != nil {
+		return document, err
+	}
+
+	updated, err := applyOperation(document, patch.op, patch.path, deepCopy(patch.value))
+	if err != nil {
+		return document, ValidationError{Path: position, Code: "invalidPath", Message: "The patch cannot be applied: " + err.Error()}
+	}
+
+	return updated, nil
+}
+
 func UnmarshalValidator(data []byte, customTypes map[string]bool, rootValidator *Validator) (FieldValidator, error) {
 	var validatorType struct {
 		Type string `json:"type"`
@@ -146,13 +283,44 @@ func UnmarshalValidator(data []byte, customTypes map[string]bool, rootValidator
 		var floatValidator FloatValidator
 		json.Unmarshal(data, &floatValidator)
 		validator = &floatValidator
+	case "integer":
+		var integerValidator IntegerValidator
+		json.Unmarshal(data, &integerValidator)
+		validator = &integerValidator
+	case "boolean":
+		var booleanValidator BooleanValidator
+		json.Unmarshal(data, &booleanValidator)
+		validator = &booleanValidator
+	case "null":
+		var nullValidator NullValidator
+		json.Unmarshal(data, &nullValidator)
+		validator = &nullValidator
+	case "oneOf", "anyOf":
+		var optionsValidator OptionsValidator
+		json.Unmarshal(data, &optionsValidator)
+		optionsValidator.exclusive = validatorType.Type == "oneOf"
+		validator = &optionsValidator
+	case "union":
+		var unionValidator UnionValidator
+		json.Unmarshal(data, &unionValidator)
+		validator = &unionValidator
+	case "map":
+		var mapValidator MapValidator
+		json.Unmarshal(data, &mapValidator)
+		validator = &mapValidator
 	case "any":
 		var anyValidator AnyValidator
 		json.Unmarshal(data, &anyValidator)
 		validator = &anyValidator
 	default:
 		if _, ok := customTypes[validatorType.Type]; ok{
-			validator = &CustomValidator{sourceValidator: rootValidator, fieldName: validatorType.Type}
+			var reference struct {
+				Nullable bool
+				Required bool
+				Default json.RawMessage
+			}
+			json.Unmarshal(data, &reference)
+			validator = &CustomValidator{sourceValidator: rootValidator, fieldName: validatorType.Type, nullable: reference.Nullable, required: reference.Required, defaultValue: reference.Default}
 		}else{
 			return nil, fmt.Errorf("Passed validation schema is missing the type field or the type (%s) is not supported", validatorType.Type)
 		}
@@ -163,5 +331,9 @@ func UnmarshalValidator(data []byte, customTypes map[string]bool, rootValidator
 		return nil, err
 	}
 
+	if rootValidator != nil && len(rawDefault(validator)) > 0 {
+		rootValidator.defaultValidators = append(rootValidator.defaultValidators, validator)
+	}
+
 	return validator, nil
 }